@@ -1,22 +1,76 @@
 package commands
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/Chromfalke/audio-workbench/internal/flac"
+	"github.com/Chromfalke/audio-workbench/internal/formats"
+	"github.com/Chromfalke/audio-workbench/internal/id3"
 	"github.com/Chromfalke/audio-workbench/internal/lib"
+	"github.com/Chromfalke/audio-workbench/internal/mp4"
+	"github.com/Chromfalke/audio-workbench/internal/ogg"
+	"github.com/Chromfalke/audio-workbench/internal/picture"
+	"github.com/Chromfalke/audio-workbench/internal/tagmodel"
+	"github.com/Chromfalke/audio-workbench/internal/vorbiscomment"
 )
 
 type LoudnessInfo struct {
-	I      string `json:"input_i"`
-	TP     string `json:"input_tp"`
-	LRA    string `json:"input_lra"`
-	Thresh string `json:"input_thresh"`
-	Offset string `json:"target_offset"`
+	I      float64
+	TP     float64
+	LRA    float64
+	Thresh float64
+	Offset float64
+
+	SamplePeak float64 // measured alongside by astats since loudnorm only reports the true peak
+}
+
+// loudnorm reports the values as strings which are -inf for silent input.
+func (loudnessInfo *LoudnessInfo) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		I      string `json:"input_i"`
+		TP     string `json:"input_tp"`
+		LRA    string `json:"input_lra"`
+		Thresh string `json:"input_thresh"`
+		Offset string `json:"target_offset"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	fields := []struct {
+		text  string
+		value *float64
+	}{
+		{raw.I, &loudnessInfo.I},
+		{raw.TP, &loudnessInfo.TP},
+		{raw.LRA, &loudnessInfo.LRA},
+		{raw.Thresh, &loudnessInfo.Thresh},
+		{raw.Offset, &loudnessInfo.Offset},
+	}
+	for _, field := range fields {
+		*field.value, err = strconv.ParseFloat(strings.TrimSpace(field.text), 64)
+		if err != nil {
+			return fmt.Errorf("Invalid loudness value %q: %s", field.text, err)
+		}
+	}
+	return nil
+}
+
+type LoudnessMeasurement struct {
+	Integrated float64 // integrated loudness in LUFS
+	SamplePeak float64 // sample peak in dBFS
+	TruePeak   float64 // true peak in dBTP
 }
 
 /*
@@ -24,35 +78,113 @@ type LoudnessInfo struct {
  */
 
 // Extract the sample rate of an audio file.
-func ExtractSampleRate(file string) (string, error) {
+func ExtractSampleRate(file string) (int, error) {
 	args := []string{"-v", "error", "-select_streams", "a:0", "-show_entries", "stream=sample_rate", "-of", "default=noprint_wrappers=1:nokey=1", file}
 	ffmpeg := exec.Command("ffprobe", args...)
 	output, err := ffmpeg.Output()
 	if err != nil {
-		return "", err
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
+
+// Extract the number of channels of an audio file.
+func ExtractChannels(file string) (int, error) {
+	args := []string{"-v", "error", "-select_streams", "a:0", "-show_entries", "stream=channels", "-of", "default=noprint_wrappers=1:nokey=1", file}
+	ffprobe := exec.Command("ffprobe", args...)
+	output, err := ffprobe.Output()
+	if err != nil {
+		return 0, err
 	}
-	return strings.Trim(string(output), "\n"), nil
+	return strconv.Atoi(strings.TrimSpace(string(output)))
 }
 
-// Extract the bitrate of an audio file.
-func ExtractBitrate(file lib.Mediafile) (string, error) {
-	if file.IsOpus {
+// Extract the duration of a media file in seconds. Returns NaN if ffprobe reports it as unknown.
+func ExtractDuration(file string) (float64, error) {
+	args := []string{"-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", file}
+	ffprobe := exec.Command("ffprobe", args...)
+	output, err := ffprobe.Output()
+	if err != nil {
+		return 0, err
+	}
+	text := strings.TrimSpace(string(output))
+	if text == "N/A" || text == "" {
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// Extract the bitrate of the audio stream in bits per second. Containers which don't store it per stream fall back to
+// the overall bitrate.
+func ExtractBitrate(file lib.Mediafile) (int, error) {
+	if file.IsOpus() {
 		// return 128kbit/s as a good default for opus
-		return "128000", nil
+		return 128000, nil
 	}
 
-	args := []string{"-v", "error", "-select_streams", "a:0", "-show_entries", "format=bit_rate", "-of", "default=noprint_wrappers=1:nokey=1", file.Path}
-	ffmpeg := exec.Command("ffprobe", args...)
-	output, err := ffmpeg.Output()
+	for _, entry := range []string{"stream=bit_rate", "format=bit_rate"} {
+		args := []string{"-v", "error", "-select_streams", "a:0", "-show_entries", entry, "-of", "default=noprint_wrappers=1:nokey=1", file.Path}
+		ffprobe := exec.Command("ffprobe", args...)
+		output, err := ffprobe.Output()
+		if err != nil {
+			return 0, err
+		}
+		bitrate, err := strconv.Atoi(strings.TrimSpace(string(output)))
+		if err == nil {
+			return bitrate, nil
+		}
+	}
+	return 0, nil
+}
+
+// Extract the sample format of the audio stream as one of formats.SampleFormats, planar formats are reported as their
+// packed counterpart. 24 bit audio decodes to 32 bit samples and is told apart by the raw sample size.
+func ExtractSampleFormat(file string) (string, error) {
+	args := []string{"-v", "error", "-select_streams", "a:0", "-show_entries", "stream=sample_fmt,bits_per_raw_sample", "-of", "default=noprint_wrappers=1", file}
+	ffprobe := exec.Command("ffprobe", args...)
+	output, err := ffprobe.Output()
+	if err != nil {
+		return "", err
+	}
+
+	var sampleFormat, bits string
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "sample_fmt":
+			sampleFormat = strings.TrimSuffix(value, "p")
+		case "bits_per_raw_sample":
+			bits = value
+		}
+	}
+	switch sampleFormat {
+	case "flt":
+		return "f32", nil
+	case "dbl":
+		return "f64", nil
+	case "s32", "s64":
+		if bits == "24" {
+			return "s24", nil
+		}
+		return "s32", nil
+	}
+	return sampleFormat, nil
+}
+
+// Extract the codec name of the audio stream.
+func ExtractCodec(file string) (string, error) {
+	args := []string{"-v", "error", "-select_streams", "a:0", "-show_entries", "stream=codec_name", "-of", "default=noprint_wrappers=1:nokey=1", file}
+	ffprobe := exec.Command("ffprobe", args...)
+	output, err := ffprobe.Output()
 	if err != nil {
 		return "", err
 	}
-	return strings.Trim(string(output), "\n"), nil
+	return strings.TrimSpace(string(output)), nil
 }
 
 // First pass with ffmpeg to analyze the loudness of an audio file.
 func ExtractLoudnessInfo(file string) (LoudnessInfo, error) {
-	ffmpegArgs := []string{"-i", file, "-af", "loudnorm=print_format=json", "-nostats", "-hide_banner", "-f", "null", "-"}
+	ffmpegArgs := []string{"-i", file, "-af", "astats=measure_perchannel=none,loudnorm=print_format=json", "-nostats", "-hide_banner", "-f", "null", "-"}
 	ffmpeg := exec.Command("ffmpeg", ffmpegArgs...)
 	output, err := ffmpeg.CombinedOutput()
 	if err != nil {
@@ -60,21 +192,35 @@ func ExtractLoudnessInfo(file string) (LoudnessInfo, error) {
 	}
 	start := strings.Index(string(output), "{")
 	end := strings.Index(string(output), "}")
+	if start == -1 || end < start {
+		return LoudnessInfo{}, fmt.Errorf("No loudness information in the ffmpeg output")
+	}
 
 	var loudnessInfo LoudnessInfo
 	err = json.Unmarshal([]byte(string(output)[start:end+1]), &loudnessInfo)
 	if err != nil {
 		return LoudnessInfo{}, err
 	}
+	loudnessInfo.SamplePeak, err = parseAstats(string(output), "Peak level dB")
+	if err != nil {
+		return LoudnessInfo{}, err
+	}
 
 	return loudnessInfo, nil
 }
 
 // Second pass with ffmpeg to normalize the loudness.
-func NormalizeLoudness(file lib.Mediafile, outpath string, targetLoudness float64, loudnessInfo LoudnessInfo, sampleRate string, bitrate string) error {
-	loudnorm := fmt.Sprintf("loudnorm=linear=true:I=%.2f:LRA=7.0:TP=-2.0:offset=%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s", targetLoudness, loudnessInfo.Offset, loudnessInfo.I, loudnessInfo.TP, loudnessInfo.LRA, loudnessInfo.Thresh)
-	args := []string{"-i", file.Path, "-af", loudnorm, "-ar", sampleRate, "-b:a", bitrate}
-	if !file.IsOpus {
+func NormalizeLoudness(file lib.Mediafile, outpath string, targetLoudness float64, loudnessInfo LoudnessInfo, sampleRate int, encoderArgs []string) error {
+	for _, value := range []float64{loudnessInfo.I, loudnessInfo.TP, loudnessInfo.LRA, loudnessInfo.Thresh, loudnessInfo.Offset} {
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			return fmt.Errorf("The measured loudness values are not finite")
+		}
+	}
+
+	loudnorm := fmt.Sprintf("loudnorm=linear=true:I=%.2f:LRA=7.0:TP=-2.0:offset=%.2f:measured_I=%.2f:measured_TP=%.2f:measured_LRA=%.2f:measured_thresh=%.2f", targetLoudness, loudnessInfo.Offset, loudnessInfo.I, loudnessInfo.TP, loudnessInfo.LRA, loudnessInfo.Thresh)
+	args := []string{"-i", file.Path, "-af", loudnorm, "-ar", strconv.Itoa(sampleRate)}
+	args = append(args, encoderArgs...)
+	if !file.IsOpus() {
 		args = append(args, []string{"-map", "0", "-map_metadata", "0", outpath}...)
 	} else {
 		args = append(args, []string{"-map_metadata", "0", outpath}...)
@@ -88,14 +234,39 @@ func NormalizeLoudness(file lib.Mediafile, outpath string, targetLoudness float6
 	return lib.RenameTempFile(file, outpath)
 }
 
-/*
- * Commands used during conversion
- */
+// Measure the overall RMS level in dBFS.
+func MeasureRMS(file string) (float64, error) {
+	args := []string{"-nostats", "-hide_banner", "-i", file, "-map", "0:a:0", "-af", "astats=measure_perchannel=none", "-f", "null", "-"}
+	ffmpeg := exec.Command("ffmpeg", args...)
+	output, err := ffmpeg.CombinedOutput()
+	if err != nil {
+		return 0, err
+	}
+
+	return parseAstats(string(output), "RMS level dB")
+}
+
+// Find a value in the overall statistics astats prints once the stream has ended. Silent input is reported as -inf.
+func parseAstats(output string, key string) (float64, error) {
+	start := strings.LastIndex(output, "Overall")
+	if start == -1 {
+		return 0, fmt.Errorf("No audio statistics in the ffmpeg output")
+	}
+	for _, line := range strings.Split(output[start:], "\n") {
+		_, value, ok := strings.Cut(line, key+":")
+		if ok {
+			return strconv.ParseFloat(strings.TrimSpace(value), 64)
+		}
+	}
 
-// Reformat the audio file
-func Convert(file lib.Mediafile, outpath string, sampleRate string, bitrate string) error {
-	args := []string{"-i", file.Path, "-ar", sampleRate, "-b:a", bitrate}
-	if !file.IsOpus {
+	return 0, fmt.Errorf("No %s in the ffmpeg output", key)
+}
+
+// Apply a fixed gain in dB.
+func ApplyGain(file lib.Mediafile, outpath string, gain float64, sampleRate int, encoderArgs []string) error {
+	args := []string{"-i", file.Path, "-af", fmt.Sprintf("volume=%.2fdB", gain), "-ar", strconv.Itoa(sampleRate)}
+	args = append(args, encoderArgs...)
+	if !file.IsOpus() {
 		args = append(args, []string{"-map", "0", "-map_metadata", "0", outpath}...)
 	} else {
 		args = append(args, []string{"-map_metadata", "0", outpath}...)
@@ -109,14 +280,394 @@ func Convert(file lib.Mediafile, outpath string, sampleRate string, bitrate stri
 	return lib.RenameTempFile(file, outpath)
 }
 
+/*
+ * Commands used for ReplayGain tagging
+ */
+
+// Measure the integrated loudness and the peaks with the EBU R128 filter. Multiple files are measured as one continuous
+// stream which is what album gain calculations expect.
+func MeasureLoudness(files ...string) (LoudnessMeasurement, error) {
+	if len(files) == 0 {
+		return LoudnessMeasurement{}, fmt.Errorf("No files to measure")
+	}
+
+	ebur128 := "ebur128=framelog=quiet:peak=sample+true"
+	args := []string{"-nostats", "-hide_banner"}
+	for _, file := range files {
+		args = append(args, "-i", file)
+	}
+	if len(files) == 1 {
+		args = append(args, "-map", "0:a:0", "-af", ebur128)
+	} else {
+		// bring all tracks to a common format so they can be concatenated
+		var filter strings.Builder
+		for i := range files {
+			fmt.Fprintf(&filter, "[%d:a:0]aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo[a%d];", i, i)
+		}
+		for i := range files {
+			fmt.Fprintf(&filter, "[a%d]", i)
+		}
+		fmt.Fprintf(&filter, "concat=n=%d:v=0:a=1,%s[out]", len(files), ebur128)
+		args = append(args, "-filter_complex", filter.String(), "-map", "[out]")
+	}
+	args = append(args, "-f", "null", "-")
+
+	ffmpeg := exec.Command("ffmpeg", args...)
+	output, err := ffmpeg.CombinedOutput()
+	if err != nil {
+		return LoudnessMeasurement{}, err
+	}
+
+	return parseEbur128Summary(string(output))
+}
+
+// Parse the summary the ebur128 filter prints once the stream has ended.
+func parseEbur128Summary(output string) (LoudnessMeasurement, error) {
+	start := strings.LastIndex(output, "Summary:")
+	if start == -1 {
+		return LoudnessMeasurement{}, fmt.Errorf("No loudness summary in the ffmpeg output")
+	}
+
+	var measurement LoudnessMeasurement
+	var section string
+	found := 0
+	for _, line := range strings.Split(output[start:], "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, ":") {
+			section = line
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		number, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case section == "Integrated loudness:" && key == "I":
+			measurement.Integrated = number
+			found++
+		case section == "Sample peak:" && key == "Peak":
+			measurement.SamplePeak = number
+			found++
+		case section == "True peak:" && key == "Peak":
+			measurement.TruePeak = number
+			found++
+		}
+	}
+	if found != 3 {
+		return LoudnessMeasurement{}, fmt.Errorf("Incomplete loudness summary in the ffmpeg output")
+	}
+
+	return measurement, nil
+}
+
+// Read the container and stream level tags. Keys are returned in upper case since the formats disagree on the casing.
+func ReadTags(file lib.Mediafile) (map[string]string, error) {
+	args := []string{"-v", "error", "-select_streams", "a:0", "-show_entries", "format_tags:stream_tags", "-of", "json", file.Path}
+	ffprobe := exec.Command("ffprobe", args...)
+	output, err := ffprobe.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		Streams []struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"streams"`
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+	err = json.Unmarshal(output, &probe)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	for _, stream := range probe.Streams {
+		for key, value := range stream.Tags {
+			tags[strings.ToUpper(key)] = value
+		}
+	}
+	for key, value := range probe.Format.Tags {
+		tags[strings.ToUpper(key)] = value
+	}
+	return tags, nil
+}
+
+// Set the given tags without re-encoding the audio. This is always done inplace.
+func SetTags(file lib.Mediafile, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	if hasVorbisComments(file) {
+		return editComments(file, func(comments *vorbiscomment.Comments) {
+			for key, value := range tags {
+				comments.Set(key, value)
+			}
+		})
+	}
+	if isMP3(file) {
+		return editID3(file, func(tag *id3.Tag) {
+			for key, value := range tags {
+				tag.SetUserText(key, value)
+			}
+		})
+	}
+	if isMP4(file) {
+		return editMP4(file, func(metadata *mp4.Metadata) {
+			for key, value := range tags {
+				metadata.SetText(mp4.ItemName(key), value)
+			}
+		})
+	}
+
+	var metadata []string
+	for key, value := range tags {
+		metadata = append(metadata, key+"="+value)
+	}
+	return remuxMetadata(file, metadata, false)
+}
+
+// Remove the given tags without re-encoding the audio. This is always done inplace.
+func RemoveTags(file lib.Mediafile, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if hasVorbisComments(file) {
+		return editComments(file, func(comments *vorbiscomment.Comments) {
+			for _, key := range keys {
+				comments.Remove(key)
+			}
+		})
+	}
+	if isMP3(file) {
+		return editID3(file, func(tag *id3.Tag) {
+			for _, key := range keys {
+				tag.SetUserText(key)
+			}
+		})
+	}
+	if isMP4(file) {
+		return editMP4(file, func(metadata *mp4.Metadata) {
+			for _, key := range keys {
+				metadata.Remove(mp4.ItemName(key))
+			}
+		})
+	}
+
+	var metadata []string
+	for _, key := range keys {
+		// an empty value makes ffmpeg drop the tag
+		metadata = append(metadata, key+"=")
+	}
+	return remuxMetadata(file, metadata, false)
+}
+
+// Read all tags into the common model.
+func ReadMetadata(file lib.Mediafile) (tagmodel.Tags, error) {
+	switch {
+	case hasVorbisComments(file) && isFLAC(file):
+		metadata, err := flac.Read(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		comments, err := metadata.Comments()
+		if err != nil {
+			return nil, err
+		}
+		return tagmodel.FromComments(comments), nil
+	case hasVorbisComments(file):
+		comments, err := ogg.ReadComments(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		return tagmodel.FromComments(comments), nil
+	case isMP3(file):
+		tag, err := id3.Read(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		return tagmodel.FromID3(tag), nil
+	case isMP4(file):
+		metadata, err := mp4.Read(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		return tagmodel.FromMP4(metadata), nil
+	}
+
+	probed, err := ReadTags(file)
+	if err != nil {
+		return nil, err
+	}
+	return tagmodel.FromFFmpeg(probed), nil
+}
+
+// Replace all tags with the model, pictures are kept. This is always done inplace. Returns the keys which the tag
+// system of the file can't represent.
+func WriteMetadata(file lib.Mediafile, tags tagmodel.Tags) ([]string, error) {
+	var lost []string
+	var err error
+	switch {
+	case hasVorbisComments(file):
+		err = editComments(file, func(comments *vorbiscomment.Comments) {
+			lost = tagmodel.ToComments(tags, comments)
+		})
+	case isMP3(file):
+		err = editID3(file, func(tag *id3.Tag) {
+			lost = tagmodel.ToID3(tags, tag)
+		})
+	case isMP4(file):
+		err = editMP4(file, func(metadata *mp4.Metadata) {
+			lost = tagmodel.ToMP4(tags, metadata)
+		})
+	default:
+		var metadata []string
+		metadata, lost = tagmodel.ToFFmpeg(tags, file.Format.Tags)
+		err = remuxMetadata(file, metadata, true)
+	}
+	return lost, err
+}
+
+// Remove all tags, chapters and pictures by copying the audio into a new container. In bitexact mode the muxers don't
+// add encoder tags. Pictures which can't be restored natively can be kept. This is always done inplace.
+func StripMetadata(file lib.Mediafile, keepPictures bool) error {
+	if file.IsReadOnly() {
+		return fmt.Errorf("Writing %s files is not supported", file.Format.Name)
+	}
+	tempfile := fmt.Sprintf("temp%s", filepath.Ext(file.Path))
+	args := []string{"-i", file.Path, "-map", "0:a"}
+	if keepPictures && !hasNativePictures(file) {
+		args = append(args, "-map", "0:v?")
+	}
+	args = append(args, "-c", "copy", "-map_metadata", "-1", "-map_chapters", "-1", "-fflags", "+bitexact", "-flags:a", "+bitexact", tempfile)
+	ffmpeg := exec.Command("ffmpeg", args...)
+	err := ffmpeg.Run()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tempfile, file.Path)
+	if err != nil {
+		return err
+	}
+
+	if hasVorbisComments(file) {
+		// the vendor string names the muxer
+		return editComments(file, func(comments *vorbiscomment.Comments) {
+			comments.Vendor = ""
+			comments.Fields = nil
+		})
+	}
+	return nil
+}
+
+// Copy all streams into a new container with changed metadata and replace the original file. With replace only the
+// given metadata is written, otherwise it is merged into the existing one.
+func remuxMetadata(file lib.Mediafile, metadata []string, replace bool) error {
+	if file.IsReadOnly() {
+		return fmt.Errorf("Writing %s files is not supported", file.Format.Name)
+	}
+	tempfile := fmt.Sprintf("temp%s", filepath.Ext(file.Path))
+	args := []string{"-i", file.Path, "-map", "0", "-c", "copy", "-map_metadata", "0"}
+	if replace {
+		args[len(args)-1] = "-1"
+	}
+	if file.Format.Tags == formats.ID3v2Chunk {
+		// the AIFF muxer only writes the ID3 chunk on request
+		args = append(args, "-write_id3v2", "1")
+	}
+	for _, entry := range metadata {
+		args = append(args, "-metadata", entry)
+	}
+	args = append(args, tempfile)
+	ffmpeg := exec.Command("ffmpeg", args...)
+	err := ffmpeg.Run()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempfile, file.Path)
+}
+
+/*
+ * Commands used during conversion
+ */
+
+// Reformat the audio file. Empty encoder arguments leave the choice to ffmpeg.
+func Convert(file lib.Mediafile, outpath string, sampleRate int, encoderArgs []string) error {
+	args := []string{"-i", file.Path, "-ar", strconv.Itoa(sampleRate)}
+	args = append(args, encoderArgs...)
+	// attached pictures are carried over separately since most targets can't take them as video streams
+	args = append(args, []string{"-map", "0:a", "-map_metadata", "0", outpath}...)
+	ffmpeg := exec.Command("ffmpeg", args...)
+	err := ffmpeg.Run()
+	if err != nil {
+		return err
+	}
+
+	return lib.RenameTempFile(file, outpath)
+}
+
 /*
  * Commands used during resampling
  */
 
+// Options of the resampler ffmpeg uses for sample rate and sample format conversions.
+type ResamplerOptions struct {
+	Engine    string  // swr or soxr, empty for the ffmpeg default
+	Precision int     // soxr precision in bits, 0 for the default
+	Cutoff    float64 // cutoff frequency as a fraction of the Nyquist frequency, 0 for the default
+}
+
+var ResamplerEngines = []string{"swr", "soxr"}
+
+func (options ResamplerOptions) Validate() error {
+	if options.Engine != "" && !slices.Contains(ResamplerEngines, options.Engine) {
+		return fmt.Errorf("Invalid resampler engine %s", options.Engine)
+	}
+	if options.Precision != 0 && (options.Precision < 15 || options.Precision > 33) {
+		return fmt.Errorf("Invalid precision %d, it has to be between 15 and 33 bits", options.Precision)
+	}
+	if options.Precision != 0 && options.Engine != "soxr" {
+		return fmt.Errorf("The precision is only supported by the soxr engine")
+	}
+	if options.Cutoff < 0 || options.Cutoff > 1 {
+		return fmt.Errorf("Invalid cutoff %g, it has to be between 0 and 1", options.Cutoff)
+	}
+	return nil
+}
+
+// The aresample filter converting to the sample rate with the options. They can't be passed as output options since
+// encoders such as libopus and aac have a cutoff option of their own which would take precedence.
+func (options ResamplerOptions) Filter(targetSampleRate int) string {
+	filter := fmt.Sprintf("aresample=%d", targetSampleRate)
+	if options.Engine != "" {
+		filter += ":resampler=" + options.Engine
+	}
+	if options.Precision != 0 {
+		filter += ":precision=" + strconv.Itoa(options.Precision)
+	}
+	if options.Cutoff != 0 {
+		filter += ":cutoff=" + strconv.FormatFloat(options.Cutoff, 'f', -1, 64)
+	}
+	return filter
+}
+
 // Resample an audio file
-func Resample(file lib.Mediafile, outpath string, targetSampleRate int, bitrate string) error {
-	args := []string{"-i", file.Path, "-ar", fmt.Sprintf("%d", targetSampleRate), "-b:a", bitrate}
-	if !file.IsOpus {
+func Resample(file lib.Mediafile, outpath string, targetSampleRate int, resampler ResamplerOptions, encoderArgs []string) error {
+	args := []string{"-i", file.Path, "-af", resampler.Filter(targetSampleRate), "-ar", fmt.Sprintf("%d", targetSampleRate)}
+	args = append(args, encoderArgs...)
+	if !file.IsOpus() {
 		args = append(args, []string{"-map", "0", "-map_metadata", "0", outpath}...)
 	} else {
 		args = append(args, []string{"-map_metadata", "0", outpath}...)
@@ -130,15 +681,45 @@ func Resample(file lib.Mediafile, outpath string, targetSampleRate int, bitrate
 	return lib.RenameTempFile(file, outpath)
 }
 
+// Resample mono float samples without touching the file system, used to verify the resampler options.
+func ResampleSamples(samples []float32, sampleRate int, targetSampleRate int, resampler ResamplerOptions) ([]float32, error) {
+	args := []string{"-v", "error", "-f", "f32le", "-ar", strconv.Itoa(sampleRate), "-ac", "1", "-i", "pipe:0", "-af", resampler.Filter(targetSampleRate)}
+	args = append(args, "-ar", strconv.Itoa(targetSampleRate), "-f", "f32le", "-c:a", "pcm_f32le", "pipe:1")
+
+	input := make([]byte, 4*len(samples))
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint32(input[4*i:], math.Float32bits(sample))
+	}
+	ffmpeg := exec.Command("ffmpeg", args...)
+	ffmpeg.Stdin = bytes.NewReader(input)
+	output, err := ffmpeg.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	resampled := make([]float32, len(output)/4)
+	for i := range resampled {
+		resampled[i] = math.Float32frombits(binary.LittleEndian.Uint32(output[4*i:]))
+	}
+	return resampled, nil
+}
+
 /*
  * Commands used during various operations
  */
 
 // Extract the embedded cover.
 func ExtractCover(file lib.Mediafile, imagePath string, videoTimestamp string) (bool, error) {
-	if file.IsOpus {
-		opustags := exec.Command("opustags", "--output-cover", imagePath, file.Path, "-i")
-		err := opustags.Run()
+	if hasNativePictures(file) {
+		pictures, err := readPictures(file)
+		if err != nil {
+			return false, err
+		}
+		pic, ok := frontCover(pictures)
+		if !ok {
+			return false, nil
+		}
+		err = os.WriteFile(imagePath, pic.Data, 0664)
 		if err != nil {
 			return false, err
 		}
@@ -173,17 +754,69 @@ func ExtractCover(file lib.Mediafile, imagePath string, videoTimestamp string) (
 
 // Embed a given image as a cover. This is always done inplace.
 func SetCover(file lib.Mediafile, cover string) error {
-	if file.IsOpus {
-		opustags := exec.Command("opustags", "--set-cover", cover, file.Path, "-i")
-		err := opustags.Run()
-		return err
+	return SetPicture(file, cover, picture.FrontCover)
+}
+
+// Names ffmpeg uses for the picture types in the comment of attached picture streams.
+var ffmpegPictureTypes = []string{
+	"Other",
+	"32x32 pixels 'file icon'",
+	"Other file icon",
+	"Cover (front)",
+	"Cover (back)",
+	"Leaflet page",
+	"Media (e.g. label side of CD)",
+	"Lead artist/lead performer/soloist",
+	"Artist/performer",
+	"Conductor",
+	"Band/Orchestra",
+	"Composer",
+	"Lyricist/text writer",
+	"Recording Location",
+	"During recording",
+	"During performance",
+	"Movie/video screen capture",
+	"A bright coloured fish",
+	"Illustration",
+	"Band/artist logotype",
+	"Publisher/Studio logotype",
+}
+
+// Embed an image as picture of the type. Front covers and file icons replace the existing picture of their type,
+// other types are added. MP4 files only hold front covers, the new cover replaces the first image which is the one
+// players show. This is always done inplace.
+func SetPicture(file lib.Mediafile, image string, pictureType uint32) error {
+	if isMP4(file) && pictureType != picture.FrontCover {
+		return fmt.Errorf("MP4 files have no picture types, only front covers can be set for %s", file.Path)
+	}
+	if hasNativePictures(file) {
+		data, err := os.ReadFile(image)
+		if err != nil {
+			return err
+		}
+		pictures, err := readPictures(file)
+		if err != nil {
+			return err
+		}
+		added := picture.New(pictureType, data)
+		if isMP4(file) {
+			if len(pictures) > 0 {
+				pictures = pictures[1:]
+			}
+			return writePictures(file, append([]picture.Picture{added}, pictures...))
+		}
+		return writePictures(file, addPicture(pictures, added))
+	}
+	if int(pictureType) >= len(ffmpegPictureTypes) {
+		return fmt.Errorf("Picture type %d is not supported for %s", pictureType, file.Path)
 	}
 
 	tempfile := fmt.Sprintf("temp%s", filepath.Ext(file.Path))
-	args := []string{"-i", file.Path, "-i", cover, "-map", "0", "-map", "1", "-c", "copy", "-metadata:s:v", `title="Album cover"`, "-metadata:s:v", `comment="Cover (front)"`}
-	if filepath.Ext(file.Path) == ".flac" {
-		args = append(args, "-disposition:v", "attached_pic")
+	args := []string{"-i", file.Path, "-i", image, "-map", "0", "-map", "1", "-c", "copy"}
+	if pictureType == picture.FrontCover {
+		args = append(args, "-metadata:s:v", "title=Album cover")
 	}
+	args = append(args, "-metadata:s:v", "comment="+ffmpegPictureTypes[pictureType])
 	args = append(args, tempfile)
 	ffmpeg := exec.Command("ffmpeg", args...)
 	err := ffmpeg.Run()
@@ -195,9 +828,201 @@ func SetCover(file lib.Mediafile, cover string) error {
 	return err
 }
 
-// Extract the audio from a video.
-func ExtractAudio(file lib.Mediafile, audioPath string) error {
-	args := []string{"-i", file.Path, "-map", "0:a", audioPath}
+// Read all embedded pictures to carry them over to another file. Formats without native picture support have none.
+func ReadPictures(file lib.Mediafile) ([]picture.Picture, error) {
+	if !hasNativePictures(file) {
+		return nil, nil
+	}
+	return readPictures(file)
+}
+
+// Embed pictures read from another file. Formats with native picture support keep all pictures with their types, others
+// get the front cover through ffmpeg and formats without covers are left alone. This is always done inplace.
+func WritePictures(file lib.Mediafile, pictures []picture.Picture) error {
+	if len(pictures) == 0 || !file.Format.Covers {
+		return nil
+	}
+	if hasNativePictures(file) {
+		return writePictures(file, pictures)
+	}
+
+	dir, err := os.MkdirTemp("", "audio-workbench")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	cover, _ := frontCover(pictures)
+	coverPath := filepath.Join(dir, "cover"+cover.Extension())
+	err = os.WriteFile(coverPath, cover.Data, 0664)
+	if err != nil {
+		return err
+	}
+	return SetCover(file, coverPath)
+}
+
+func isFLAC(file lib.Mediafile) bool {
+	return file.Format.Name == "flac"
+}
+
+func hasVorbisComments(file lib.Mediafile) bool {
+	return file.Format.Tags == formats.VorbisComments
+}
+
+// Check whether the file is an Ogg stream which carries Vorbis comments.
+func isOgg(file lib.Mediafile) bool {
+	return hasVorbisComments(file) && !isFLAC(file)
+}
+
+func isMP3(file lib.Mediafile) bool {
+	return file.Format.Tags == formats.ID3v2
+}
+
+func isMP4(file lib.Mediafile) bool {
+	return file.Format.Tags == formats.MP4Atoms
+}
+
+// Check whether the pictures of the file can be read and written without ffmpeg.
+func hasNativePictures(file lib.Mediafile) bool {
+	return file.Format.NativeTags() && file.Format.Covers
+}
+
+// Edit the iTunes metadata of an MP4 file in place.
+func editMP4(file lib.Mediafile, edit func(metadata *mp4.Metadata)) error {
+	metadata, err := mp4.Read(file.Path)
+	if err != nil {
+		return err
+	}
+	edit(metadata)
+	return metadata.Write(file.Path)
+}
+
+// Edit the ID3v2 tag of an mp3 file in place.
+func editID3(file lib.Mediafile, edit func(tag *id3.Tag)) error {
+	tag, err := id3.Read(file.Path)
+	if err != nil {
+		return err
+	}
+	edit(tag)
+	return tag.Write(file.Path)
+}
+
+// Edit the Vorbis comments of an Ogg or FLAC file in place.
+func editComments(file lib.Mediafile, edit func(comments *vorbiscomment.Comments)) error {
+	if isFLAC(file) {
+		metadata, err := flac.Read(file.Path)
+		if err != nil {
+			return err
+		}
+		comments, err := metadata.Comments()
+		if err != nil {
+			return err
+		}
+		edit(&comments)
+		metadata.SetComments(comments)
+		return metadata.Write(file.Path)
+	}
+
+	comments, err := ogg.ReadComments(file.Path)
+	if err != nil {
+		return err
+	}
+	edit(&comments)
+	return ogg.WriteComments(file.Path, comments)
+}
+
+// Read all embedded pictures natively.
+func readPictures(file lib.Mediafile) ([]picture.Picture, error) {
+	switch {
+	case isFLAC(file):
+		metadata, err := flac.Read(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		return metadata.Pictures()
+	case isOgg(file):
+		comments, err := ogg.ReadComments(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		return comments.Pictures()
+	case isMP3(file):
+		tag, err := id3.Read(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		return tag.Pictures()
+	case isMP4(file):
+		metadata, err := mp4.Read(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		return metadata.Pictures(), nil
+	}
+	return nil, fmt.Errorf("Reading pictures from %s is not supported", file.Path)
+}
+
+// Replace all embedded pictures natively. This is always done inplace.
+func writePictures(file lib.Mediafile, pictures []picture.Picture) error {
+	switch {
+	case isFLAC(file):
+		metadata, err := flac.Read(file.Path)
+		if err != nil {
+			return err
+		}
+		metadata.SetPictures(pictures)
+		return metadata.Write(file.Path)
+	case isOgg(file):
+		comments, err := ogg.ReadComments(file.Path)
+		if err != nil {
+			return err
+		}
+		comments.SetPictures(pictures)
+		return ogg.WriteComments(file.Path, comments)
+	case isMP3(file):
+		return editID3(file, func(tag *id3.Tag) {
+			tag.SetPictures(pictures)
+		})
+	case isMP4(file):
+		return editMP4(file, func(metadata *mp4.Metadata) {
+			metadata.SetPictures(pictures)
+		})
+	}
+	return fmt.Errorf("Writing pictures to %s is not supported", file.Path)
+}
+
+// Pick the front cover or the first picture if there is no front cover.
+func frontCover(pictures []picture.Picture) (picture.Picture, bool) {
+	for _, pic := range pictures {
+		if pic.Type == picture.FrontCover {
+			return pic, true
+		}
+	}
+	if len(pictures) > 0 {
+		return pictures[0], true
+	}
+	return picture.Picture{}, false
+}
+
+// Add a front cover in front of the other pictures and any other picture after them, players that ignore the types
+// show the first picture. Pictures of a type which may only exist once are replaced.
+func addPicture(pictures []picture.Picture, added picture.Picture) []picture.Picture {
+	var result []picture.Picture
+	for _, pic := range pictures {
+		if pic.Type != added.Type || !picture.Unique(added.Type) {
+			result = append(result, pic)
+		}
+	}
+	if added.Type == picture.FrontCover {
+		return append([]picture.Picture{added}, result...)
+	}
+	return append(result, added)
+}
+
+// Extract the audio from a video. Empty encoder arguments leave the choice to ffmpeg.
+func ExtractAudio(file lib.Mediafile, audioPath string, sampleRate int, encoderArgs []string) error {
+	args := []string{"-i", file.Path, "-map", "0:a", "-ar", strconv.Itoa(sampleRate)}
+	args = append(args, encoderArgs...)
+	args = append(args, audioPath)
 	ffmpeg := exec.Command("ffmpeg", args...)
 	err := ffmpeg.Run()
 	return err
@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Chromfalke/audio-workbench/internal/picture"
+)
+
+func TestAddPicture(t *testing.T) {
+	existing := []picture.Picture{
+		{Type: picture.FrontCover, Description: "old front"},
+		{Type: picture.BackCover, Description: "old back"},
+		{Type: picture.FileIcon, Description: "old icon"},
+	}
+	tests := []struct {
+		added picture.Picture
+		want  []string
+	}{
+		{picture.Picture{Type: picture.FrontCover, Description: "new front"}, []string{"new front", "old back", "old icon"}},
+		{picture.Picture{Type: picture.BackCover, Description: "new back"}, []string{"old front", "old back", "old icon", "new back"}},
+		{picture.Picture{Type: picture.FileIcon, Description: "new icon"}, []string{"old front", "old back", "new icon"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, pic := range addPicture(existing, test.added) {
+			got = append(got, pic.Description)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("addPicture(%s) = %q, want %q", test.added.Description, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package flac
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+type StreamInfo struct {
+	MinBlockSize  uint16
+	MaxBlockSize  uint16
+	MinFrameSize  uint32
+	MaxFrameSize  uint32
+	SampleRate    uint32
+	Channels      uint8
+	BitsPerSample uint8
+	TotalSamples  uint64
+	MD5           [16]byte
+}
+
+type SeekPoint struct {
+	SampleNumber uint64 // 0xFFFFFFFFFFFFFFFF marks a placeholder
+	Offset       uint64 // relative to the first frame
+	Samples      uint16
+}
+
+type CueSheet struct {
+	MediaCatalogNumber string
+	LeadInSamples      uint64
+	IsCD               bool
+	Tracks             []CueTrack
+}
+
+type CueTrack struct {
+	Offset      uint64
+	Number      uint8
+	ISRC        string
+	IsAudio     bool
+	PreEmphasis bool
+	Indices     []CueIndex
+}
+
+type CueIndex struct {
+	Offset uint64
+	Number uint8
+}
+
+func (metadata *Metadata) StreamInfo() (StreamInfo, error) {
+	data, _ := metadata.block(StreamInfoBlock)
+	if len(data) < 34 {
+		return StreamInfo{}, fmt.Errorf("Truncated STREAMINFO block")
+	}
+
+	info := StreamInfo{
+		MinBlockSize:  uint16(readBits(data, 0, 16)),
+		MaxBlockSize:  uint16(readBits(data, 16, 16)),
+		MinFrameSize:  uint32(readBits(data, 32, 24)),
+		MaxFrameSize:  uint32(readBits(data, 56, 24)),
+		SampleRate:    uint32(readBits(data, 80, 20)),
+		Channels:      uint8(readBits(data, 100, 3)) + 1,
+		BitsPerSample: uint8(readBits(data, 103, 5)) + 1,
+		TotalSamples:  readBits(data, 108, 36),
+	}
+	copy(info.MD5[:], data[18:34])
+	return info, nil
+}
+
+// Replace the STREAMINFO block. Only needed by tools that change the stream itself.
+func (metadata *Metadata) SetStreamInfo(info StreamInfo) {
+	data := make([]byte, 34)
+	writeBits(data, 0, 16, uint64(info.MinBlockSize))
+	writeBits(data, 16, 16, uint64(info.MaxBlockSize))
+	writeBits(data, 32, 24, uint64(info.MinFrameSize))
+	writeBits(data, 56, 24, uint64(info.MaxFrameSize))
+	writeBits(data, 80, 20, uint64(info.SampleRate))
+	writeBits(data, 100, 3, uint64(info.Channels-1))
+	writeBits(data, 103, 5, uint64(info.BitsPerSample-1))
+	writeBits(data, 108, 36, info.TotalSamples)
+	copy(data[18:34], info.MD5[:])
+	metadata.replaceBlocks(StreamInfoBlock, data)
+}
+
+func (metadata *Metadata) SeekTable() ([]SeekPoint, error) {
+	data, ok := metadata.block(SeekTableBlock)
+	if !ok {
+		return nil, nil
+	}
+	if len(data)%18 != 0 {
+		return nil, fmt.Errorf("Invalid SEEKTABLE block size")
+	}
+
+	var points []SeekPoint
+	for offset := 0; offset < len(data); offset += 18 {
+		points = append(points, SeekPoint{
+			SampleNumber: binary.BigEndian.Uint64(data[offset:]),
+			Offset:       binary.BigEndian.Uint64(data[offset+8:]),
+			Samples:      binary.BigEndian.Uint16(data[offset+16:]),
+		})
+	}
+	return points, nil
+}
+
+// Replace the seek table. An empty table removes the block.
+func (metadata *Metadata) SetSeekTable(points []SeekPoint) {
+	if len(points) == 0 {
+		metadata.replaceBlocks(SeekTableBlock)
+		return
+	}
+
+	data := make([]byte, 0, 18*len(points))
+	for _, point := range points {
+		data = binary.BigEndian.AppendUint64(data, point.SampleNumber)
+		data = binary.BigEndian.AppendUint64(data, point.Offset)
+		data = binary.BigEndian.AppendUint16(data, point.Samples)
+	}
+	metadata.replaceBlocks(SeekTableBlock, data)
+}
+
+// Return the cue sheet and whether the file has one.
+func (metadata *Metadata) CueSheet() (CueSheet, bool, error) {
+	data, ok := metadata.block(CueSheetBlock)
+	if !ok {
+		return CueSheet{}, false, nil
+	}
+	truncated := fmt.Errorf("Truncated CUESHEET block")
+	if len(data) < 396 {
+		return CueSheet{}, true, truncated
+	}
+
+	sheet := CueSheet{
+		MediaCatalogNumber: strings.TrimRight(string(data[:128]), "\x00"),
+		LeadInSamples:      binary.BigEndian.Uint64(data[128:]),
+		IsCD:               data[136]&0x80 != 0,
+	}
+	trackCount := int(data[395])
+	offset := 396
+	for range trackCount {
+		if offset+36 > len(data) {
+			return CueSheet{}, true, truncated
+		}
+		track := CueTrack{
+			Offset:      binary.BigEndian.Uint64(data[offset:]),
+			Number:      data[offset+8],
+			ISRC:        strings.TrimRight(string(data[offset+9:offset+21]), "\x00"),
+			IsAudio:     data[offset+21]&0x80 == 0,
+			PreEmphasis: data[offset+21]&0x40 != 0,
+		}
+		indexCount := int(data[offset+35])
+		offset += 36
+		for range indexCount {
+			if offset+12 > len(data) {
+				return CueSheet{}, true, truncated
+			}
+			track.Indices = append(track.Indices, CueIndex{
+				Offset: binary.BigEndian.Uint64(data[offset:]),
+				Number: data[offset+8],
+			})
+			offset += 12
+		}
+		sheet.Tracks = append(sheet.Tracks, track)
+	}
+
+	return sheet, true, nil
+}
+
+func (metadata *Metadata) SetCueSheet(sheet CueSheet) {
+	data := make([]byte, 396)
+	copy(data[:128], sheet.MediaCatalogNumber)
+	binary.BigEndian.PutUint64(data[128:], sheet.LeadInSamples)
+	if sheet.IsCD {
+		data[136] = 0x80
+	}
+	data[395] = byte(len(sheet.Tracks))
+
+	for _, track := range sheet.Tracks {
+		entry := make([]byte, 36)
+		binary.BigEndian.PutUint64(entry, track.Offset)
+		entry[8] = track.Number
+		copy(entry[9:21], track.ISRC)
+		if !track.IsAudio {
+			entry[21] |= 0x80
+		}
+		if track.PreEmphasis {
+			entry[21] |= 0x40
+		}
+		entry[35] = byte(len(track.Indices))
+		data = append(data, entry...)
+		for _, index := range track.Indices {
+			entry := make([]byte, 12)
+			binary.BigEndian.PutUint64(entry, index.Offset)
+			entry[8] = index.Number
+			data = append(data, entry...)
+		}
+	}
+	metadata.replaceBlocks(CueSheetBlock, data)
+}
+
+// Remove the cue sheet.
+func (metadata *Metadata) RemoveCueSheet() {
+	metadata.replaceBlocks(CueSheetBlock)
+}
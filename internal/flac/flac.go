@@ -0,0 +1,293 @@
+package flac
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Chromfalke/audio-workbench/internal/lib"
+	"github.com/Chromfalke/audio-workbench/internal/picture"
+	"github.com/Chromfalke/audio-workbench/internal/vorbiscomment"
+)
+
+// Metadata block types.
+const (
+	StreamInfoBlock    = 0
+	PaddingBlock       = 1
+	ApplicationBlock   = 2
+	SeekTableBlock     = 3
+	VorbisCommentBlock = 4
+	CueSheetBlock      = 5
+	PictureBlock       = 6
+)
+
+// Padding added when the metadata no longer fits and the file has to be rewritten.
+const defaultPadding = 8192
+
+const maxBlockSize = 1<<24 - 1
+
+type Block struct {
+	Type byte
+	Data []byte
+}
+
+// Metadata blocks of a FLAC file. Padding is not kept as a block but recalculated on write.
+type Metadata struct {
+	Blocks []Block
+
+	start       int64 // offset of the fLaC marker, non-zero if an ID3v2 tag precedes it
+	audioOffset int64 // offset of the first frame
+}
+
+// Read all metadata blocks.
+func Read(path string) (*Metadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	metadata := &Metadata{}
+	marker := make([]byte, 4)
+	_, err = io.ReadFull(reader, marker)
+	if err != nil {
+		return nil, fmt.Errorf("Not a FLAC file")
+	}
+	if string(marker[:3]) == "ID3" {
+		// some taggers put an ID3v2 tag in front of the stream
+		header := make([]byte, 6)
+		_, err = io.ReadFull(reader, header)
+		if err != nil {
+			return nil, fmt.Errorf("Not a FLAC file")
+		}
+		size := int64(header[2])<<21 | int64(header[3])<<14 | int64(header[4])<<7 | int64(header[5])
+		if header[1]&0x10 != 0 {
+			size += 10
+		}
+		_, err = reader.Discard(int(size))
+		if err != nil {
+			return nil, fmt.Errorf("Not a FLAC file")
+		}
+		metadata.start = size + 10
+		_, err = io.ReadFull(reader, marker)
+		if err != nil {
+			return nil, fmt.Errorf("Not a FLAC file")
+		}
+	}
+	if string(marker) != "fLaC" {
+		return nil, fmt.Errorf("Not a FLAC file")
+	}
+
+	offset := metadata.start + 4
+	for last := false; !last; {
+		header := make([]byte, 4)
+		_, err = io.ReadFull(reader, header)
+		if err != nil {
+			return nil, fmt.Errorf("Truncated metadata block header")
+		}
+		last = header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, fmt.Errorf("Truncated metadata block")
+		}
+		offset += 4 + int64(length)
+		if blockType == 127 {
+			return nil, fmt.Errorf("Invalid metadata block type")
+		}
+		if blockType != PaddingBlock {
+			metadata.Blocks = append(metadata.Blocks, Block{Type: blockType, Data: data})
+		}
+	}
+	metadata.audioOffset = offset
+
+	if len(metadata.Blocks) == 0 || metadata.Blocks[0].Type != StreamInfoBlock {
+		return nil, fmt.Errorf("The first metadata block is not STREAMINFO")
+	}
+	return metadata, nil
+}
+
+// Return the data of the first block of a type.
+func (metadata *Metadata) block(blockType byte) ([]byte, bool) {
+	for _, block := range metadata.Blocks {
+		if block.Type == blockType {
+			return block.Data, true
+		}
+	}
+	return nil, false
+}
+
+// Replace all blocks of a type. The first existing block of the type keeps its position, new blocks are appended.
+func (metadata *Metadata) replaceBlocks(blockType byte, data ...[]byte) {
+	position := -1
+	var blocks []Block
+	for _, block := range metadata.Blocks {
+		if block.Type == blockType {
+			if position == -1 {
+				position = len(blocks)
+			}
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+	if position == -1 {
+		position = len(blocks)
+	}
+
+	var replacement []Block
+	for _, entry := range data {
+		replacement = append(replacement, Block{Type: blockType, Data: entry})
+	}
+	metadata.Blocks = append(blocks[:position], append(replacement, blocks[position:]...)...)
+}
+
+// Return the vorbis comments. An empty set of comments is returned if the file has none.
+func (metadata *Metadata) Comments() (vorbiscomment.Comments, error) {
+	data, ok := metadata.block(VorbisCommentBlock)
+	if !ok {
+		return vorbiscomment.Comments{Vendor: "audio-workbench"}, nil
+	}
+	return vorbiscomment.Parse(data)
+}
+
+func (metadata *Metadata) SetComments(comments vorbiscomment.Comments) {
+	metadata.replaceBlocks(VorbisCommentBlock, comments.Bytes())
+}
+
+// Return all embedded pictures in file order.
+func (metadata *Metadata) Pictures() ([]picture.Picture, error) {
+	var pictures []picture.Picture
+	for _, block := range metadata.Blocks {
+		if block.Type != PictureBlock {
+			continue
+		}
+		pic, err := picture.Parse(block.Data)
+		if err != nil {
+			return nil, err
+		}
+		pictures = append(pictures, pic)
+	}
+	return pictures, nil
+}
+
+// Replace all embedded pictures.
+func (metadata *Metadata) SetPictures(pictures []picture.Picture) {
+	var data [][]byte
+	for _, pic := range pictures {
+		data = append(data, pic.Bytes())
+	}
+	metadata.replaceBlocks(PictureBlock, data...)
+}
+
+// Serialize the blocks followed by a padding block of the given size. A negative size omits the padding.
+func (metadata *Metadata) serialize(padding int) ([]byte, error) {
+	blocks := metadata.Blocks
+	if padding >= 0 {
+		blocks = append(blocks[:len(blocks):len(blocks)], Block{Type: PaddingBlock, Data: make([]byte, padding)})
+	}
+
+	data := []byte("fLaC")
+	for i, block := range blocks {
+		if len(block.Data) > maxBlockSize {
+			return nil, fmt.Errorf("Metadata block of type %d exceeds the maximum size", block.Type)
+		}
+		header := block.Type
+		if i == len(blocks)-1 {
+			header |= 0x80
+		}
+		length := len(block.Data)
+		data = append(data, header, byte(length>>16), byte(length>>8), byte(length))
+		data = append(data, block.Data...)
+	}
+	return data, nil
+}
+
+// Write the metadata back. If the new metadata fits into the space of the old metadata including its padding the file
+// is changed in place, otherwise or if that would leave more padding than a block holds the file is rewritten with
+// fresh padding.
+func (metadata *Metadata) Write(path string) error {
+	available := metadata.audioOffset - metadata.start
+	data, err := metadata.serialize(-1)
+	if err != nil {
+		return err
+	}
+
+	// a single padding block can't hold more than 16 MiB, larger gaps are closed by rewriting the file
+	if padding := available - int64(len(data)) - 4; padding >= 0 && padding <= maxBlockSize {
+		data, err = metadata.serialize(int(padding))
+		if err != nil {
+			return err
+		}
+	}
+	if int64(len(data)) == available {
+		file, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = file.WriteAt(data, metadata.start)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+
+	data, err = metadata.serialize(defaultPadding)
+	if err != nil {
+		return err
+	}
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	err = lib.ReplaceFile(path, func(w io.Writer) error {
+		_, err := io.CopyN(w, source, metadata.start)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+		_, err = source.Seek(metadata.audioOffset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, source)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	metadata.audioOffset = metadata.start + int64(len(data))
+	return nil
+}
+
+// Read an unsigned big endian number of up to 64 bits.
+func readBits(data []byte, offset int, count int) uint64 {
+	var value uint64
+	for i := range count {
+		bit := offset + i
+		value = value<<1 | uint64(data[bit/8]>>(7-bit%8)&0x01)
+	}
+	return value
+}
+
+func writeBits(data []byte, offset int, count int, value uint64) {
+	for i := range count {
+		bit := offset + i
+		mask := byte(0x80 >> (bit % 8))
+		if value>>(count-1-i)&0x01 != 0 {
+			data[bit/8] |= mask
+		} else {
+			data[bit/8] &^= mask
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package flac
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Chromfalke/audio-workbench/internal/picture"
+	"github.com/Chromfalke/audio-workbench/internal/vorbiscomment"
+)
+
+var audio = bytes.Repeat([]byte{0xff, 0xf8, 0x69, 0x18, 0x00, 0x00, 0xbf, 0x03}, 64)
+
+var streamInfo = StreamInfo{
+	MinBlockSize:  4096,
+	MaxBlockSize:  4096,
+	MinFrameSize:  14,
+	MaxFrameSize:  9542,
+	SampleRate:    44100,
+	Channels:      2,
+	BitsPerSample: 16,
+	TotalSamples:  1<<33 + 12345,
+	MD5:           [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+}
+
+// Write a file with STREAMINFO, comments and padding in front of some stand-in audio frames. A non-empty prefix is
+// written in front of the fLaC marker as an ID3v2 tag.
+func writeFixture(t *testing.T, prefix []byte, padding int) string {
+	t.Helper()
+	metadata := &Metadata{}
+	metadata.SetStreamInfo(streamInfo)
+	metadata.SetComments(vorbiscomment.Comments{Vendor: "test", Fields: []vorbiscomment.Field{{Key: "TITLE", Value: "Title"}}})
+	data, err := metadata.serialize(padding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefix) > 0 {
+		size := len(prefix)
+		header := []byte{'I', 'D', '3', 4, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+		data = append(append(header, prefix...), data...)
+	}
+	data = append(data, audio...)
+
+	path := filepath.Join(t.TempDir(), "test.flac")
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readFixture(t *testing.T, path string) (*Metadata, []byte) {
+	t.Helper()
+	metadata, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[metadata.audioOffset:], audio) {
+		t.Fatalf("The audio doesn't start at offset %d", metadata.audioOffset)
+	}
+	return metadata, data
+}
+
+func checkComments(t *testing.T, metadata *Metadata, want vorbiscomment.Comments) {
+	t.Helper()
+	comments, err := metadata.Comments()
+	if err != nil {
+		t.Fatalf("Comments: %s", err)
+	}
+	if comments.Vendor != want.Vendor || len(comments.Fields) != len(want.Fields) {
+		t.Fatalf("Comments = %+v, want %+v", comments, want)
+	}
+	for i := range want.Fields {
+		if comments.Fields[i] != want.Fields[i] {
+			t.Errorf("Field %d is %+v, want %+v", i, comments.Fields[i], want.Fields[i])
+		}
+	}
+}
+
+func TestWriteInPlace(t *testing.T) {
+	for _, prefix := range [][]byte{nil, bytes.Repeat([]byte{0}, 100)} {
+		path := writeFixture(t, prefix, 1000)
+		metadata, original := readFixture(t, path)
+		audioOffset := metadata.audioOffset
+
+		comments := vorbiscomment.Comments{Vendor: "audio-workbench", Fields: []vorbiscomment.Field{{Key: "TITLE", Value: "New"}, {Key: "ARTIST", Value: "Artist"}}}
+		metadata.SetComments(comments)
+		err := metadata.Write(path)
+		if err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+
+		reread, data := readFixture(t, path)
+		if len(data) != len(original) || reread.audioOffset != audioOffset {
+			t.Errorf("The file has %d bytes with the audio at %d, want %d bytes with the audio at %d", len(data), reread.audioOffset, len(original), audioOffset)
+		}
+		if !bytes.Equal(data[:metadata.start], original[:metadata.start]) {
+			t.Errorf("The data in front of the fLaC marker changed")
+		}
+		checkComments(t, reread, comments)
+		info, err := reread.StreamInfo()
+		if err != nil || info != streamInfo {
+			t.Errorf("StreamInfo = %+v, %v, want %+v", info, err, streamInfo)
+		}
+	}
+}
+
+func TestWriteLargePadding(t *testing.T) {
+	path := writeFixture(t, nil, 100)
+	metadata, _ := readFixture(t, path)
+	large := []picture.Picture{{Type: picture.FrontCover, MIME: "image/png", Data: make([]byte, maxBlockSize-100)}}
+	metadata.SetPictures(large)
+	err := metadata.Write(path)
+	if err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+
+	// removing the picture frees more space than a padding block can hold
+	metadata, _ = readFixture(t, path)
+	metadata.SetPictures(nil)
+	err = metadata.Write(path)
+	if err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	reread, data := readFixture(t, path)
+	if len(data) > 1<<20 {
+		t.Errorf("The file still has %d bytes after removing the picture", len(data))
+	}
+	pictures, err := reread.Pictures()
+	if err != nil || len(pictures) != 0 {
+		t.Errorf("Pictures = %d pictures, %v, want none", len(pictures), err)
+	}
+	checkComments(t, reread, vorbiscomment.Comments{Vendor: "test", Fields: []vorbiscomment.Field{{Key: "TITLE", Value: "Title"}}})
+}
+
+func TestWriteExactFit(t *testing.T) {
+	path := writeFixture(t, nil, 0)
+	metadata, original := readFixture(t, path)
+
+	// the comments lose exactly the four bytes an empty padding block needs
+	comments := vorbiscomment.Comments{Vendor: "tes", Fields: []vorbiscomment.Field{{Key: "TITLE", Value: "Ti"}}}
+	metadata.SetComments(comments)
+	err := metadata.Write(path)
+	if err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	reread, data := readFixture(t, path)
+	if len(data) != len(original) {
+		t.Errorf("The file has %d bytes, want %d", len(data), len(original))
+	}
+	checkComments(t, reread, comments)
+}
+
+func TestWriteRewrite(t *testing.T) {
+	for _, prefix := range [][]byte{nil, bytes.Repeat([]byte{0}, 100)} {
+		path := writeFixture(t, prefix, 100)
+		metadata, original := readFixture(t, path)
+
+		pictures := []picture.Picture{
+			{Type: picture.FrontCover, MIME: "image/png", Data: bytes.Repeat([]byte{0x42}, 5000)},
+			{Type: picture.BackCover, MIME: "image/jpeg", Data: []byte{1, 2, 3}},
+		}
+		metadata.SetPictures(pictures)
+		err := metadata.Write(path)
+		if err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+
+		reread, data := readFixture(t, path)
+		if reread.audioOffset != metadata.audioOffset {
+			t.Errorf("The audio starts at %d, Write reported %d", reread.audioOffset, metadata.audioOffset)
+		}
+		if !bytes.Equal(data[:metadata.start], original[:metadata.start]) {
+			t.Errorf("The data in front of the fLaC marker changed")
+		}
+		serialized, err := reread.serialize(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if padding := reread.audioOffset - reread.start - int64(len(serialized)) - 4; padding != defaultPadding {
+			t.Errorf("The rewritten file has %d bytes of padding, want %d", padding, defaultPadding)
+		}
+		read, err := reread.Pictures()
+		if err != nil {
+			t.Fatalf("Pictures: %s", err)
+		}
+		if len(read) != len(pictures) || read[0].Type != picture.FrontCover || !bytes.Equal(read[0].Data, pictures[0].Data) || read[1].Type != picture.BackCover {
+			t.Errorf("Pictures = %d pictures, want %d", len(read), len(pictures))
+		}
+
+		// the fresh padding is used by the next change
+		size := len(data)
+		reread.SetPictures(pictures[:1])
+		err = reread.Write(path)
+		if err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		last, data := readFixture(t, path)
+		if len(data) != size || last.audioOffset != reread.audioOffset {
+			t.Errorf("The file has %d bytes with the audio at %d, want %d bytes with the audio at %d", len(data), last.audioOffset, size, reread.audioOffset)
+		}
+	}
+}
+
+func TestBlocksRoundTrip(t *testing.T) {
+	metadata := &Metadata{}
+	metadata.SetStreamInfo(streamInfo)
+	points := []SeekPoint{{SampleNumber: 0, Offset: 0, Samples: 4096}, {SampleNumber: 1 << 40, Offset: 123456, Samples: 4096}, {SampleNumber: 0xffffffffffffffff}}
+	metadata.SetSeekTable(points)
+	sheet := CueSheet{
+		MediaCatalogNumber: "1234567890123",
+		LeadInSamples:      88200,
+		IsCD:               true,
+		Tracks: []CueTrack{
+			{Offset: 0, Number: 1, ISRC: "USRC17607839", IsAudio: true, Indices: []CueIndex{{Offset: 0, Number: 1}}},
+			{Offset: 588000, Number: 2, IsAudio: true, PreEmphasis: true, Indices: []CueIndex{{Offset: 0, Number: 0}, {Offset: 588, Number: 1}}},
+			{Offset: 1176000, Number: 170},
+		},
+	}
+	metadata.SetCueSheet(sheet)
+
+	info, err := metadata.StreamInfo()
+	if err != nil || info != streamInfo {
+		t.Errorf("StreamInfo = %+v, %v, want %+v", info, err, streamInfo)
+	}
+	read, err := metadata.SeekTable()
+	if err != nil || len(read) != len(points) {
+		t.Fatalf("SeekTable = %+v, %v, want %+v", read, err, points)
+	}
+	for i := range points {
+		if read[i] != points[i] {
+			t.Errorf("Seek point %d is %+v, want %+v", i, read[i], points[i])
+		}
+	}
+
+	readSheet, ok, err := metadata.CueSheet()
+	if err != nil || !ok {
+		t.Fatalf("CueSheet: %v, %v", ok, err)
+	}
+	if readSheet.MediaCatalogNumber != sheet.MediaCatalogNumber || readSheet.LeadInSamples != sheet.LeadInSamples || readSheet.IsCD != sheet.IsCD || len(readSheet.Tracks) != len(sheet.Tracks) {
+		t.Fatalf("CueSheet = %+v, want %+v", readSheet, sheet)
+	}
+	for i, track := range sheet.Tracks {
+		got := readSheet.Tracks[i]
+		if got.Offset != track.Offset || got.Number != track.Number || got.ISRC != track.ISRC || got.IsAudio != track.IsAudio || got.PreEmphasis != track.PreEmphasis || len(got.Indices) != len(track.Indices) {
+			t.Errorf("Track %d is %+v, want %+v", i, got, track)
+			continue
+		}
+		for j := range track.Indices {
+			if got.Indices[j] != track.Indices[j] {
+				t.Errorf("Index %d of track %d is %+v, want %+v", j, i, got.Indices[j], track.Indices[j])
+			}
+		}
+	}
+
+	metadata.SetSeekTable(nil)
+	metadata.RemoveCueSheet()
+	if len(metadata.Blocks) != 1 || metadata.Blocks[0].Type != StreamInfoBlock {
+		t.Errorf("Blocks left after removing the seek table and cue sheet: %+v", metadata.Blocks)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	dir := t.TempDir()
+	streamInfoBlock := append([]byte{0x80, 0, 0, 34}, make([]byte, 34)...)
+	tests := map[string][]byte{
+		"no marker":              []byte("OggS"),
+		"truncated block":        append([]byte("fLaC"), streamInfoBlock[:20]...),
+		"comments first":         append([]byte("fLaC\x84\x00\x00\x00"), streamInfoBlock...),
+		"truncated ID3v2 prefix": []byte("ID3\x04\x00\x00\x00\x00\x01\x00fLaC"),
+	}
+	for name, data := range tests {
+		path := filepath.Join(dir, "invalid.flac")
+		err := os.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Read(path)
+		if err == nil {
+			t.Errorf("%s: Read succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package formats
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policies for parameters a format can't store.
+const (
+	Snap   = "snap"   // use the closest legal value
+	Refuse = "refuse" // fail the file
+)
+
+var Policies = []string{Snap, Refuse}
+
+// Parameters of an encode which are constrained by the target format. Zero values are unknown or left to the encoder
+// and aren't checked.
+type Parameters struct {
+	SampleRate int
+	Channels   int
+	Bitrate    int
+}
+
+// Check the parameters against the constraints of the format. With the snap policy illegal values are replaced by the
+// closest legal ones and the adjustments are described, with the refuse policy they result in an error.
+func (format Format) Constrain(params Parameters, policy string) (Parameters, []string, error) {
+	var violations []string
+	adjusted := params
+
+	if params.SampleRate != 0 && len(format.SampleRates) > 0 {
+		closest := closestRate(format.SampleRates, params.SampleRate)
+		if closest != params.SampleRate {
+			adjusted.SampleRate = closest
+			violations = append(violations, fmt.Sprintf("sample rate %d Hz to %d Hz", params.SampleRate, closest))
+		}
+	}
+	if params.Channels != 0 && format.MaxChannels != 0 && params.Channels > format.MaxChannels {
+		adjusted.Channels = format.MaxChannels
+		violations = append(violations, fmt.Sprintf("%d channels to %d", params.Channels, format.MaxChannels))
+	}
+	if params.Bitrate != 0 && format.MaxBitrate != 0 {
+		adjusted.Bitrate = min(max(params.Bitrate, format.MinBitrate), format.MaxBitrate)
+		if adjusted.Bitrate != params.Bitrate {
+			violations = append(violations, fmt.Sprintf("bitrate %d kbit/s to %d kbit/s", params.Bitrate/1000, adjusted.Bitrate/1000))
+		}
+	}
+
+	if len(violations) > 0 && policy == Refuse {
+		return params, nil, fmt.Errorf("%s can't store the parameters, it would need to change the %s", format.Name, strings.Join(violations, ", "))
+	}
+	return adjusted, violations, nil
+}
+
+// Return the legal rate closest to the rate, ties go to the higher rate.
+func closestRate(rates []int, rate int) int {
+	closest := rates[0]
+	for _, candidate := range rates {
+		distance := abs(candidate - rate)
+		if distance < abs(closest-rate) || (distance == abs(closest-rate) && candidate > closest) {
+			closest = candidate
+		}
+	}
+	return closest
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
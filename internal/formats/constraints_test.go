@@ -0,0 +1,61 @@
+package formats
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestClosestRate(t *testing.T) {
+	tests := []struct {
+		rates []int
+		rate  int
+		want  int
+	}{
+		{opusSampleRates, 48000, 48000},
+		{opusSampleRates, 44100, 48000},
+		{opusSampleRates, 96000, 48000},
+		{opusSampleRates, 22050, 24000},
+		{opusSampleRates, 11025, 12000},
+		{opusSampleRates, 4000, 8000},
+		{mp3SampleRates, 96000, 48000},
+		{mp3SampleRates, 88200, 48000},
+		// 10000 Hz is as far from 8000 Hz as from 12000 Hz
+		{opusSampleRates, 10000, 12000},
+		{aacSampleRates, 192000, 96000},
+	}
+	for _, test := range tests {
+		if got := closestRate(test.rates, test.rate); got != test.want {
+			t.Errorf("closestRate(%v, %d) = %d, want %d", test.rates, test.rate, got, test.want)
+		}
+	}
+}
+
+func TestConstrain(t *testing.T) {
+	tests := []struct {
+		format     string
+		params     Parameters
+		want       Parameters
+		violations []string
+	}{
+		{"flac", Parameters{SampleRate: 192000, Channels: 2}, Parameters{SampleRate: 192000, Channels: 2}, nil},
+		{"opus", Parameters{SampleRate: 44100, Channels: 2, Bitrate: 128000}, Parameters{SampleRate: 48000, Channels: 2, Bitrate: 128000}, []string{"sample rate 44100 Hz to 48000 Hz"}},
+		{"mp3", Parameters{SampleRate: 96000, Channels: 6, Bitrate: 640000}, Parameters{SampleRate: 48000, Channels: 2, Bitrate: 320000}, []string{"sample rate 96000 Hz to 48000 Hz", "6 channels to 2", "bitrate 640 kbit/s to 320 kbit/s"}},
+		{"vorbis", Parameters{Bitrate: 32000}, Parameters{Bitrate: 45000}, []string{"bitrate 32 kbit/s to 45 kbit/s"}},
+		{"wav", Parameters{Channels: 24}, Parameters{Channels: 18}, []string{"24 channels to 18"}},
+		{"aac", Parameters{}, Parameters{}, nil},
+	}
+	for _, test := range tests {
+		got, violations, err := format(t, test.format).Constrain(test.params, Snap)
+		if err != nil || got != test.want || !slices.Equal(violations, test.violations) {
+			t.Errorf("Constrain(%s, %+v) = %+v, %q, %v, want %+v, %q", test.format, test.params, got, violations, err, test.want, test.violations)
+		}
+
+		got, _, err = format(t, test.format).Constrain(test.params, Refuse)
+		if (err != nil) != (len(test.violations) > 0) {
+			t.Errorf("Constrain(%s, %+v) with the refuse policy returned %v", test.format, test.params, err)
+		}
+		if got != test.params {
+			t.Errorf("Constrain(%s, %+v) with the refuse policy changed the parameters to %+v", test.format, test.params, got)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package formats
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Tag systems used by the formats.
+const (
+	NoTags         = ""
+	VorbisComments = "vorbis"    // Ogg and FLAC
+	ID3v2          = "id3"       // ID3v2 tag in front of the stream
+	ID3v2Chunk     = "id3-chunk" // ID3v2 tag inside an AIFF chunk, written by ffmpeg
+	MP4Atoms       = "mp4"       // iTunes style ilst atoms
+	APEv2          = "ape"       // APEv2 tag at the end of the file
+	RIFFInfo       = "riff"      // RIFF INFO chunk
+)
+
+type Format struct {
+	Name        string
+	Extensions  []string // the first extension is used for output files
+	Encoder     string   // ffmpeg encoder, empty if the format can only be read
+	Lossless    bool
+	SampleRates []int // empty if any rate is accepted
+	MinBitrate  int   // bitrate range in bits per second for lossy formats
+	MaxBitrate  int
+	BitDepths   []int // integer bit depths that can be stored, empty for lossy formats
+	Float       bool  // whether 32 bit float samples can be stored
+	MaxChannels int
+	Tags        string
+	Covers      bool
+}
+
+var mp3SampleRates = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000}
+var opusSampleRates = []int{8000, 12000, 16000, 24000, 48000}
+var aacSampleRates = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000, 64000, 88200, 96000}
+
+var All = []Format{
+	{
+		Name:        "flac",
+		Extensions:  []string{".flac"},
+		Encoder:     "flac",
+		Lossless:    true,
+		BitDepths:   []int{16, 24},
+		MaxChannels: 8,
+		Tags:        VorbisComments,
+		Covers:      true,
+	},
+	{
+		Name:        "mp3",
+		Extensions:  []string{".mp3"},
+		Encoder:     "libmp3lame",
+		SampleRates: mp3SampleRates,
+		MinBitrate:  32000,
+		MaxBitrate:  320000,
+		MaxChannels: 2,
+		Tags:        ID3v2,
+		Covers:      true,
+	},
+	{
+		Name:        "opus",
+		Extensions:  []string{".opus"},
+		Encoder:     "libopus",
+		SampleRates: opusSampleRates,
+		MinBitrate:  6000,
+		MaxBitrate:  510000,
+		MaxChannels: 8,
+		Tags:        VorbisComments,
+		Covers:      true,
+	},
+	{
+		Name:        "vorbis",
+		Extensions:  []string{".ogg", ".oga"},
+		Encoder:     "libvorbis",
+		MinBitrate:  45000,
+		MaxBitrate:  500000,
+		MaxChannels: 8,
+		Tags:        VorbisComments,
+		Covers:      true,
+	},
+	{
+		Name:        "wav",
+		Extensions:  []string{".wav"},
+		Encoder:     "pcm_s16le",
+		Lossless:    true,
+		BitDepths:   []int{8, 16, 24, 32},
+		Float:       true,
+		MaxChannels: 18,
+		Tags:        RIFFInfo,
+	},
+	{
+		Name:        "aiff",
+		Extensions:  []string{".aiff", ".aif"},
+		Encoder:     "pcm_s16be",
+		Lossless:    true,
+		BitDepths:   []int{8, 16, 24, 32},
+		Float:       true,
+		MaxChannels: 18,
+		Tags:        ID3v2Chunk,
+	},
+	{
+		Name:        "wavpack",
+		Extensions:  []string{".wv"},
+		Encoder:     "wavpack",
+		Lossless:    true,
+		BitDepths:   []int{8, 16, 24, 32},
+		Float:       true,
+		MaxChannels: 8,
+		Tags:        APEv2,
+	},
+	{
+		Name:        "ape",
+		Extensions:  []string{".ape"},
+		Lossless:    true,
+		BitDepths:   []int{8, 16, 24},
+		MaxChannels: 2,
+		Tags:        APEv2,
+	},
+	{
+		Name:        "aac",
+		Extensions:  []string{".m4a", ".m4b"},
+		Encoder:     "aac",
+		SampleRates: aacSampleRates,
+		MinBitrate:  16000,
+		MaxBitrate:  512000,
+		MaxChannels: 8,
+		Tags:        MP4Atoms,
+		Covers:      true,
+	},
+	{
+		Name:        "alac",
+		Extensions:  []string{".m4a"},
+		Encoder:     "alac",
+		Lossless:    true,
+		BitDepths:   []int{16, 24},
+		MaxChannels: 8,
+		Tags:        MP4Atoms,
+		Covers:      true,
+	},
+}
+
+func ByName(name string) (Format, bool) {
+	for _, format := range All {
+		if format.Name == name {
+			return format, true
+		}
+	}
+	return Format{}, false
+}
+
+// Return the format for a file path. Formats sharing an extension resolve to the first one, the codec has to be probed
+// to tell them apart.
+func ForPath(path string) (Format, bool) {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, format := range All {
+		if slices.Contains(format.Extensions, ext) {
+			return format, true
+		}
+	}
+	return Format{}, false
+}
+
+// Return the format of an ffmpeg codec name, used for audio streams in videos and extensions shared by formats.
+func ForCodec(codec string) (Format, bool) {
+	if strings.HasPrefix(codec, "pcm_") {
+		return ByName("wav")
+	}
+	for _, format := range All {
+		if format.Name == codec || format.Encoder == codec {
+			return format, true
+		}
+	}
+	return Format{}, false
+}
+
+// Names of the formats ffmpeg can encode to.
+func Encodable() []string {
+	var names []string
+	for _, format := range All {
+		if format.Encoder != "" {
+			names = append(names, format.Name)
+		}
+	}
+	return names
+}
+
+func (format Format) Extension() string {
+	return format.Extensions[0]
+}
+
+// Check whether tags and pictures of the format can be edited without ffmpeg.
+func (format Format) NativeTags() bool {
+	return format.Tags == VorbisComments || format.Tags == ID3v2 || format.Tags == MP4Atoms
+}
@@ -0,0 +1,281 @@
+package formats
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Encoder settings for a target format. Settings which don't apply to the target are ignored.
+type Quality struct {
+	Bitrate          int     // bits per second, 0 leaves the choice to the encoder
+	VBRQuality       int     // mp3 VBR quality from 0 (best) to 9, -1 for a constant bitrate
+	Application      string  // opus: voip, audio or lowdelay
+	FrameDuration    float64 // opus frame size in milliseconds
+	CompressionLevel int     // flac compression level from 0 to 12, -1 for the encoder default
+	SampleFormat     string  // sample format of lossless formats, see SampleFormats
+	Dither           string  // dither applied when the sample format is reduced, see Dithers
+}
+
+// Quality with every setting left to the encoder or to the defaults of QualityFor.
+var AutoQuality = Quality{VBRQuality: -1, CompressionLevel: -1}
+
+var SampleFormats = []string{"u8", "s16", "s24", "s32", "f32", "f64"}
+var Dithers = []string{"none", "triangular", "noise-shaped"}
+var OpusApplications = []string{"voip", "audio", "lowdelay"}
+var OpusFrameDurations = []float64{2.5, 5, 10, 20, 40, 60, 80, 100, 120}
+
+func (quality Quality) Validate() error {
+	if quality.Bitrate < 0 {
+		return fmt.Errorf("Invalid bitrate %d", quality.Bitrate)
+	}
+	if quality.VBRQuality < -1 || quality.VBRQuality > 9 {
+		return fmt.Errorf("Invalid VBR quality %d, it has to be between 0 and 9", quality.VBRQuality)
+	}
+	if quality.Application != "" && !slices.Contains(OpusApplications, quality.Application) {
+		return fmt.Errorf("Invalid opus application %s", quality.Application)
+	}
+	if quality.FrameDuration != 0 && !slices.Contains(OpusFrameDurations, quality.FrameDuration) {
+		return fmt.Errorf("Invalid opus frame duration %g ms", quality.FrameDuration)
+	}
+	if quality.CompressionLevel < -1 || quality.CompressionLevel > 12 {
+		return fmt.Errorf("Invalid compression level %d, it has to be between 0 and 12", quality.CompressionLevel)
+	}
+	if quality.SampleFormat != "" && !slices.Contains(SampleFormats, quality.SampleFormat) {
+		return fmt.Errorf("Invalid sample format %s", quality.SampleFormat)
+	}
+	if quality.Dither != "" && !slices.Contains(Dithers, quality.Dither) {
+		return fmt.Errorf("Invalid dither %s", quality.Dither)
+	}
+	return nil
+}
+
+// Replace the settings which are set in the override. A bitrate switches mp3 to a constant bitrate and a VBR quality
+// switches it to a variable one.
+func (quality Quality) Override(override Quality) Quality {
+	if override.Bitrate > 0 {
+		quality.Bitrate = override.Bitrate
+		quality.VBRQuality = -1
+	}
+	if override.VBRQuality >= 0 {
+		quality.VBRQuality = override.VBRQuality
+		quality.Bitrate = 0
+	}
+	if override.Application != "" {
+		quality.Application = override.Application
+	}
+	if override.FrameDuration != 0 {
+		quality.FrameDuration = override.FrameDuration
+	}
+	if override.CompressionLevel >= 0 {
+		quality.CompressionLevel = override.CompressionLevel
+	}
+	if override.SampleFormat != "" {
+		quality.SampleFormat = override.SampleFormat
+	}
+	if override.Dither != "" {
+		quality.Dither = override.Dither
+	}
+	return quality
+}
+
+// Default settings for encoding a source to the target format. Lossless sources get settings that are transparent for
+// the target, lossy sources keep their bitrate within the range of the target since a higher one can't recover
+// anything. A source bitrate or sample format of zero value means it is unknown.
+func QualityFor(source Format, sourceBitrate int, sourceSampleFormat string, target Format) Quality {
+	quality := AutoQuality
+	lossless := source.Lossless || sourceBitrate == 0
+
+	switch target.Name {
+	case "mp3":
+		if lossless {
+			quality.VBRQuality = 0
+		} else {
+			quality.Bitrate = min(max(sourceBitrate, 32000), 320000)
+		}
+	case "opus":
+		quality.Application = "audio"
+		quality.FrameDuration = 20
+		if lossless {
+			quality.Bitrate = 160000
+		} else {
+			quality.Bitrate = min(max(sourceBitrate, 32000), 256000)
+		}
+	case "vorbis":
+		if lossless {
+			quality.Bitrate = 192000
+		} else {
+			quality.Bitrate = min(max(sourceBitrate, 64000), 320000)
+		}
+	case "aac":
+		// AAC is transparent at 256 kbit/s
+		if lossless {
+			quality.Bitrate = 256000
+		} else {
+			quality.Bitrate = min(max(sourceBitrate, 32000), 256000)
+		}
+	case "flac":
+		quality.CompressionLevel = 8
+	}
+
+	if target.Lossless {
+		// decoded lossy audio has no meaningful bit depth, 16 bit covers its dynamic range
+		quality.SampleFormat = "s16"
+		if source.Lossless && slices.Contains(SampleFormats, sourceSampleFormat) {
+			quality.SampleFormat = target.closestSampleFormat(sourceSampleFormat)
+		}
+	}
+	return quality
+}
+
+// Parse a bit depth of 8, 16, 24 or 32 bit integer or float (32 bit) into a sample format.
+func ParseBitDepth(value string) (string, error) {
+	switch value {
+	case "8":
+		return "u8", nil
+	case "16", "24", "32":
+		return "s" + value, nil
+	case "float":
+		return "f32", nil
+	}
+	return "", fmt.Errorf("Invalid bit depth %s, it has to be 8, 16, 24, 32 or float", value)
+}
+
+// Number of bits of a sample format.
+func sampleBits(sampleFormat string) int {
+	bits, _ := strconv.Atoi(sampleFormat[1:])
+	return bits
+}
+
+// Check whether converting between the sample formats loses precision. Integer samples fit into float samples with
+// more bits, float samples never fit into integer samples.
+func Reduces(from string, to string) bool {
+	if from == "" || to == "" {
+		return false
+	}
+	if from[0] == 'f' && to[0] != 'f' {
+		return true
+	}
+	if from[0] != 'f' && to[0] == 'f' {
+		return sampleBits(from) >= sampleBits(to)
+	}
+	return sampleBits(from) > sampleBits(to)
+}
+
+func (format Format) SupportsSampleFormat(sampleFormat string) bool {
+	switch sampleFormat {
+	case "f32":
+		return format.Float
+	case "f64":
+		// only PCM has double precision samples
+		return format.Float && (format.Name == "wav" || format.Name == "aiff")
+	}
+	return slices.Contains(format.BitDepths, sampleBits(sampleFormat))
+}
+
+// Return the sample format if the format can store it, otherwise the largest integer format below it or the smallest
+// one the format has.
+func (format Format) closestSampleFormat(sampleFormat string) string {
+	if format.SupportsSampleFormat(sampleFormat) || len(format.BitDepths) == 0 {
+		return sampleFormat
+	}
+	closest := format.BitDepths[0]
+	for _, bits := range format.BitDepths {
+		if bits <= sampleBits(sampleFormat) || sampleFormat[0] == 'f' {
+			closest = bits
+		}
+	}
+	if closest == 8 {
+		return "u8"
+	}
+	return fmt.Sprintf("s%d", closest)
+}
+
+// The ffmpeg arguments selecting and configuring the encoder. Formats without an encoder leave the choice to ffmpeg.
+func (format Format) EncoderArgs(quality Quality) []string {
+	if format.Encoder == "" {
+		return nil
+	}
+
+	encoder := format.Encoder
+	var args []string
+	switch format.Name {
+	case "mp3":
+		if quality.VBRQuality >= 0 {
+			args = append(args, "-q:a", strconv.Itoa(quality.VBRQuality))
+		} else if quality.Bitrate > 0 {
+			args = append(args, "-b:a", strconv.Itoa(quality.Bitrate))
+		}
+	case "opus":
+		if quality.Bitrate > 0 {
+			args = append(args, "-b:a", strconv.Itoa(quality.Bitrate))
+		}
+		if quality.Application != "" {
+			args = append(args, "-application", quality.Application)
+		}
+		if quality.FrameDuration != 0 {
+			args = append(args, "-frame_duration", strconv.FormatFloat(quality.FrameDuration, 'f', -1, 64))
+		}
+	case "vorbis", "aac":
+		if quality.Bitrate > 0 {
+			args = append(args, "-b:a", strconv.Itoa(quality.Bitrate))
+		}
+	case "flac":
+		if quality.CompressionLevel >= 0 {
+			args = append(args, "-compression_level", strconv.Itoa(quality.CompressionLevel))
+		}
+		args = append(args, sampleFormatArgs(quality.SampleFormat, false)...)
+	case "wavpack", "alac":
+		args = append(args, sampleFormatArgs(quality.SampleFormat, true)...)
+	case "wav":
+		if quality.SampleFormat != "" {
+			encoder = pcmEncoder(quality.SampleFormat, "le")
+		}
+	case "aiff":
+		if quality.SampleFormat != "" {
+			encoder = pcmEncoder(quality.SampleFormat, "be")
+		}
+	}
+	if format.Lossless && quality.SampleFormat != "" {
+		switch quality.Dither {
+		case "triangular":
+			args = append(args, "-dither_method", "triangular")
+		case "noise-shaped":
+			args = append(args, "-dither_method", "shibata")
+		}
+	}
+	return append([]string{"-c:a", encoder}, args...)
+}
+
+// Arguments selecting the sample format of encoders which aren't chosen by it. 24 bit samples are passed as 32 bit
+// samples with a raw sample size of 24 bit.
+func sampleFormatArgs(sampleFormat string, planar bool) []string {
+	var args []string
+	switch sampleFormat {
+	case "":
+		return nil
+	case "s24":
+		sampleFormat = "s32"
+		args = append(args, "-bits_per_raw_sample", "24")
+	case "f32":
+		sampleFormat = "flt"
+	case "f64":
+		sampleFormat = "dbl"
+	}
+	if planar {
+		sampleFormat += "p"
+	}
+	return append([]string{"-sample_fmt", sampleFormat}, args...)
+}
+
+// Name of the ffmpeg PCM encoder for a sample format and byte order.
+func pcmEncoder(sampleFormat string, byteOrder string) string {
+	if sampleFormat == "u8" {
+		if byteOrder == "be" {
+			// AIFF only stores signed samples
+			return "pcm_s8"
+		}
+		return "pcm_u8"
+	}
+	return "pcm_" + sampleFormat + byteOrder
+}
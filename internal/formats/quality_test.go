@@ -0,0 +1,136 @@
+package formats
+
+import (
+	"slices"
+	"testing"
+)
+
+func format(t *testing.T, name string) Format {
+	t.Helper()
+	format, ok := ByName(name)
+	if !ok {
+		t.Fatalf("Unknown format %s", name)
+	}
+	return format
+}
+
+func TestQualityFor(t *testing.T) {
+	tests := []struct {
+		source       string
+		bitrate      int
+		sampleFormat string
+		target       string
+		want         Quality
+	}{
+		{"flac", 0, "s16", "mp3", Quality{VBRQuality: 0, CompressionLevel: -1}},
+		{"aac", 128000, "", "mp3", Quality{Bitrate: 128000, VBRQuality: -1, CompressionLevel: -1}},
+		{"opus", 16000, "", "mp3", Quality{Bitrate: 32000, VBRQuality: -1, CompressionLevel: -1}},
+		{"flac", 0, "s24", "opus", Quality{Bitrate: 160000, VBRQuality: -1, Application: "audio", FrameDuration: 20, CompressionLevel: -1}},
+		{"mp3", 320000, "", "opus", Quality{Bitrate: 256000, VBRQuality: -1, Application: "audio", FrameDuration: 20, CompressionLevel: -1}},
+		{"mp3", 0, "", "vorbis", Quality{Bitrate: 192000, VBRQuality: -1, CompressionLevel: -1}},
+		{"opus", 32000, "", "vorbis", Quality{Bitrate: 64000, VBRQuality: -1, CompressionLevel: -1}},
+		{"wav", 0, "s16", "aac", Quality{Bitrate: 256000, VBRQuality: -1, CompressionLevel: -1}},
+		{"wav", 0, "s24", "flac", Quality{VBRQuality: -1, CompressionLevel: 8, SampleFormat: "s24"}},
+		{"wav", 0, "s32", "flac", Quality{VBRQuality: -1, CompressionLevel: 8, SampleFormat: "s24"}},
+		{"wav", 0, "f32", "flac", Quality{VBRQuality: -1, CompressionLevel: 8, SampleFormat: "s24"}},
+		{"wav", 0, "u8", "flac", Quality{VBRQuality: -1, CompressionLevel: 8, SampleFormat: "s16"}},
+		{"wav", 0, "f32", "wavpack", Quality{VBRQuality: -1, CompressionLevel: -1, SampleFormat: "f32"}},
+		{"mp3", 320000, "fltp", "flac", Quality{VBRQuality: -1, CompressionLevel: 8, SampleFormat: "s16"}},
+		{"flac", 0, "", "wav", Quality{VBRQuality: -1, CompressionLevel: -1, SampleFormat: "s16"}},
+	}
+	for _, test := range tests {
+		got := QualityFor(format(t, test.source), test.bitrate, test.sampleFormat, format(t, test.target))
+		if got != test.want {
+			t.Errorf("QualityFor(%s at %d with %q, %s) = %+v, want %+v", test.source, test.bitrate, test.sampleFormat, test.target, got, test.want)
+		}
+	}
+}
+
+func TestReduces(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{"s16", "s24", false},
+		{"s24", "s16", true},
+		{"s24", "s24", false},
+		{"s16", "f32", false},
+		{"s24", "f32", false},
+		{"s32", "f32", true},
+		{"s32", "f64", false},
+		{"f32", "s32", true},
+		{"f64", "f32", true},
+		{"f32", "f64", false},
+		{"", "s16", false},
+		{"s24", "", false},
+	}
+	for _, test := range tests {
+		if got := Reduces(test.from, test.to); got != test.want {
+			t.Errorf("Reduces(%q, %q) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestClosestSampleFormat(t *testing.T) {
+	tests := []struct {
+		format       string
+		sampleFormat string
+		want         string
+	}{
+		{"flac", "s16", "s16"},
+		{"flac", "s32", "s24"},
+		{"flac", "f64", "s24"},
+		{"flac", "u8", "s16"},
+		{"alac", "s32", "s24"},
+		{"wav", "f64", "f64"},
+		{"wavpack", "f64", "s32"},
+		{"wavpack", "u8", "u8"},
+		{"mp3", "s24", "s24"},
+	}
+	for _, test := range tests {
+		if got := format(t, test.format).closestSampleFormat(test.sampleFormat); got != test.want {
+			t.Errorf("closestSampleFormat(%s, %s) = %s, want %s", test.format, test.sampleFormat, got, test.want)
+		}
+	}
+}
+
+func TestEncoderArgs(t *testing.T) {
+	tests := []struct {
+		format  string
+		quality Quality
+		want    []string
+	}{
+		{"mp3", Quality{VBRQuality: 2, Bitrate: 192000}, []string{"-c:a", "libmp3lame", "-q:a", "2"}},
+		{"mp3", Quality{VBRQuality: -1, Bitrate: 192000}, []string{"-c:a", "libmp3lame", "-b:a", "192000"}},
+		{"opus", Quality{Bitrate: 96000, Application: "voip", FrameDuration: 2.5}, []string{"-c:a", "libopus", "-b:a", "96000", "-application", "voip", "-frame_duration", "2.5"}},
+		{"aac", AutoQuality, []string{"-c:a", "aac"}},
+		{"flac", Quality{CompressionLevel: 8, SampleFormat: "s16"}, []string{"-c:a", "flac", "-compression_level", "8", "-sample_fmt", "s16"}},
+		{"flac", Quality{CompressionLevel: -1, SampleFormat: "s24"}, []string{"-c:a", "flac", "-sample_fmt", "s32", "-bits_per_raw_sample", "24"}},
+		{"alac", Quality{SampleFormat: "s24"}, []string{"-c:a", "alac", "-sample_fmt", "s32p", "-bits_per_raw_sample", "24"}},
+		{"wavpack", Quality{SampleFormat: "f32"}, []string{"-c:a", "wavpack", "-sample_fmt", "fltp"}},
+		{"wav", Quality{SampleFormat: "s24", Dither: "triangular"}, []string{"-c:a", "pcm_s24le", "-dither_method", "triangular"}},
+		{"wav", Quality{SampleFormat: "u8"}, []string{"-c:a", "pcm_u8"}},
+		{"wav", Quality{Dither: "noise-shaped"}, []string{"-c:a", "pcm_s16le"}},
+		{"aiff", Quality{SampleFormat: "u8", Dither: "noise-shaped"}, []string{"-c:a", "pcm_s8", "-dither_method", "shibata"}},
+		{"aiff", Quality{SampleFormat: "f64"}, []string{"-c:a", "pcm_f64be"}},
+		{"ape", Quality{SampleFormat: "s16"}, nil},
+	}
+	for _, test := range tests {
+		if got := format(t, test.format).EncoderArgs(test.quality); !slices.Equal(got, test.want) {
+			t.Errorf("EncoderArgs(%s, %+v) = %q, want %q", test.format, test.quality, got, test.want)
+		}
+	}
+}
+
+func TestParseBitDepth(t *testing.T) {
+	tests := map[string]string{"8": "u8", "16": "s16", "24": "s24", "32": "s32", "float": "f32"}
+	for value, want := range tests {
+		if got, err := ParseBitDepth(value); err != nil || got != want {
+			t.Errorf("ParseBitDepth(%s) = %s, %v, want %s", value, got, err, want)
+		}
+	}
+	if _, err := ParseBitDepth("12"); err == nil {
+		t.Errorf("ParseBitDepth(12) succeeded")
+	}
+}
@@ -0,0 +1,460 @@
+package id3
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/picture"
+)
+
+// Comments (COMM) and unsynchronised lyrics (USLT) share the same layout.
+type Comment struct {
+	Language    string // ISO-639-2 code
+	Description string
+	Text        string
+}
+
+// Synchronised lyrics frame (SYLT) with timestamps in milliseconds.
+type SyncedLyrics struct {
+	Language    string // ISO-639-2 code
+	Description string
+	Lines       []SyncedLine
+}
+
+type SyncedLine struct {
+	Time uint32
+	Text string
+}
+
+// Timestamp format and content type of SYLT frames.
+const (
+	millisecondTimestamps = 2
+	lyricsContent         = 1
+)
+
+// User defined text frame (TXXX).
+type UserText struct {
+	Description string
+	Values      []string
+}
+
+// Chapter frame (CHAP). Times are in milliseconds, unused byte offsets are 0xFFFFFFFF.
+type Chapter struct {
+	ElementID   string
+	StartTime   uint32
+	EndTime     uint32
+	StartOffset uint32
+	EndOffset   uint32
+	Frames      []Frame
+}
+
+// Table of contents frame (CTOC).
+type TableOfContents struct {
+	ElementID string
+	TopLevel  bool
+	Ordered   bool
+	Children  []string
+	Frames    []Frame
+}
+
+// Replace all frames matching the filter. The first match keeps its position, new frames are appended otherwise.
+func (tag *Tag) replaceFrames(match func(Frame) bool, replacement ...Frame) {
+	position := -1
+	var frames []Frame
+	for _, frame := range tag.Frames {
+		if match(frame) {
+			if position == -1 {
+				position = len(frames)
+			}
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	if position == -1 {
+		position = len(frames)
+	}
+	tag.Frames = append(frames[:position], append(replacement, frames[position:]...)...)
+}
+
+func byID(id string) func(Frame) bool {
+	return func(frame Frame) bool {
+		return frame.ID == id
+	}
+}
+
+// Remove all frames with the ID.
+func (tag *Tag) Remove(id string) {
+	tag.replaceFrames(byID(id))
+}
+
+// Return the values of a text frame. ID3v2.4 separates multiple values with null bytes, ID3v2.3 values are returned as
+// they are.
+func (tag *Tag) Text(id string) []string {
+	for _, frame := range tag.Frames {
+		if frame.ID != id || len(frame.Data) == 0 {
+			continue
+		}
+		values, err := decodeStrings(frame.Data[1:], frame.Data[0])
+		if err != nil {
+			return nil
+		}
+		return values
+	}
+	return nil
+}
+
+// Replace a text frame. No values remove the frame.
+func (tag *Tag) SetText(id string, values ...string) {
+	if len(values) == 0 {
+		tag.Remove(id)
+		return
+	}
+	tag.replaceFrames(byID(id), Frame{ID: id, Data: tag.encodeTexts(values)})
+}
+
+// Encode text values including the leading encoding byte.
+func (tag *Tag) encodeTexts(values []string) []byte {
+	encoding := chooseEncoding(tag.Version, values...)
+	return append([]byte{encoding}, tag.encodeValues(values, encoding)...)
+}
+
+// Encode text values with the separator of the tag version.
+func (tag *Tag) encodeValues(values []string, encoding byte) []byte {
+	if tag.Version == 3 {
+		return encodeString(strings.Join(values, "/"), encoding)
+	}
+	var data []byte
+	for i, value := range values {
+		if i > 0 {
+			data = append(data, terminator(encoding)...)
+		}
+		data = append(data, encodeString(value, encoding)...)
+	}
+	return data
+}
+
+func (tag *Tag) UserTexts() []UserText {
+	var texts []UserText
+	for _, frame := range tag.Frames {
+		if frame.ID != "TXXX" || len(frame.Data) == 0 {
+			continue
+		}
+		encoding := frame.Data[0]
+		description, rest := splitString(frame.Data[1:], encoding)
+		descriptionText, err := decodeString(description, encoding)
+		if err != nil {
+			continue
+		}
+		values, err := decodeStrings(rest, encoding)
+		if err != nil {
+			continue
+		}
+		texts = append(texts, UserText{Description: descriptionText, Values: values})
+	}
+	return texts
+}
+
+// Return the values of the TXXX frame with the description. Descriptions are compared case insensitively.
+func (tag *Tag) UserText(description string) []string {
+	for _, text := range tag.UserTexts() {
+		if strings.EqualFold(text.Description, description) {
+			return text.Values
+		}
+	}
+	return nil
+}
+
+// Replace the TXXX frame with the description. No values remove the frame.
+func (tag *Tag) SetUserText(description string, values ...string) {
+	match := func(frame Frame) bool {
+		if frame.ID != "TXXX" || len(frame.Data) == 0 {
+			return false
+		}
+		existing, _ := splitString(frame.Data[1:], frame.Data[0])
+		text, err := decodeString(existing, frame.Data[0])
+		return err == nil && strings.EqualFold(text, description)
+	}
+	if len(values) == 0 {
+		tag.replaceFrames(match)
+		return
+	}
+
+	encoding := chooseEncoding(tag.Version, append([]string{description}, values...)...)
+	data := []byte{encoding}
+	data = append(data, encodeString(description, encoding)...)
+	data = append(data, terminator(encoding)...)
+	data = append(data, tag.encodeValues(values, encoding)...)
+	tag.replaceFrames(match, Frame{ID: "TXXX", Data: data})
+}
+
+func (tag *Tag) comments(id string) []Comment {
+	var comments []Comment
+	for _, frame := range tag.Frames {
+		if frame.ID != id || len(frame.Data) < 4 {
+			continue
+		}
+		encoding := frame.Data[0]
+		description, text := splitString(frame.Data[4:], encoding)
+		descriptionText, err := decodeString(description, encoding)
+		if err != nil {
+			continue
+		}
+		textValue, err := decodeString(trimTerminator(text, encoding), encoding)
+		if err != nil {
+			continue
+		}
+		comments = append(comments, Comment{Language: string(frame.Data[1:4]), Description: descriptionText, Text: textValue})
+	}
+	return comments
+}
+
+func (tag *Tag) setComments(id string, comments []Comment) {
+	var frames []Frame
+	for _, comment := range comments {
+		encoding := chooseEncoding(tag.Version, comment.Description, comment.Text)
+		language := []byte((comment.Language + "XXX")[:3])
+		data := append([]byte{encoding}, language...)
+		data = append(data, encodeString(comment.Description, encoding)...)
+		data = append(data, terminator(encoding)...)
+		data = append(data, encodeString(comment.Text, encoding)...)
+		frames = append(frames, Frame{ID: id, Data: data})
+	}
+	tag.replaceFrames(byID(id), frames...)
+}
+
+func (tag *Tag) Comments() []Comment {
+	return tag.comments("COMM")
+}
+
+func (tag *Tag) SetComments(comments []Comment) {
+	tag.setComments("COMM", comments)
+}
+
+// Return the unsynchronised lyrics (USLT).
+func (tag *Tag) Lyrics() []Comment {
+	return tag.comments("USLT")
+}
+
+func (tag *Tag) SetLyrics(lyrics []Comment) {
+	tag.setComments("USLT", lyrics)
+}
+
+// Return the synchronised lyrics (SYLT). Frames timed in MPEG frames are skipped.
+func (tag *Tag) SyncedLyrics() []SyncedLyrics {
+	var lyrics []SyncedLyrics
+	for _, frame := range tag.Frames {
+		if frame.ID != "SYLT" || len(frame.Data) < 6 || frame.Data[4] != millisecondTimestamps {
+			continue
+		}
+		encoding := frame.Data[0]
+		description, rest := splitString(frame.Data[6:], encoding)
+		descriptionText, err := decodeString(description, encoding)
+		if err != nil {
+			continue
+		}
+		synced := SyncedLyrics{Language: string(frame.Data[1:4]), Description: descriptionText}
+		for len(rest) > 0 {
+			var text []byte
+			text, rest = splitString(rest, encoding)
+			if len(rest) < 4 {
+				break
+			}
+			textValue, err := decodeString(text, encoding)
+			if err != nil {
+				break
+			}
+			synced.Lines = append(synced.Lines, SyncedLine{Time: binary.BigEndian.Uint32(rest), Text: textValue})
+			rest = rest[4:]
+		}
+		lyrics = append(lyrics, synced)
+	}
+	return lyrics
+}
+
+func (tag *Tag) SetSyncedLyrics(lyrics []SyncedLyrics) {
+	var frames []Frame
+	for _, synced := range lyrics {
+		texts := []string{synced.Description}
+		for _, line := range synced.Lines {
+			texts = append(texts, line.Text)
+		}
+		encoding := chooseEncoding(tag.Version, texts...)
+		language := []byte((synced.Language + "XXX")[:3])
+		data := append([]byte{encoding}, language...)
+		data = append(data, millisecondTimestamps, lyricsContent)
+		data = append(data, encodeString(synced.Description, encoding)...)
+		data = append(data, terminator(encoding)...)
+		for _, line := range synced.Lines {
+			data = append(data, encodeString(line.Text, encoding)...)
+			data = append(data, terminator(encoding)...)
+			data = binary.BigEndian.AppendUint32(data, line.Time)
+		}
+		frames = append(frames, Frame{ID: "SYLT", Data: data})
+	}
+	tag.replaceFrames(byID("SYLT"), frames...)
+}
+
+// Return the attached pictures (APIC).
+func (tag *Tag) Pictures() ([]picture.Picture, error) {
+	var pictures []picture.Picture
+	for _, frame := range tag.Frames {
+		if frame.ID != "APIC" {
+			continue
+		}
+		if len(frame.Data) < 2 {
+			return nil, fmt.Errorf("Truncated APIC frame")
+		}
+		encoding := frame.Data[0]
+		mime, rest := splitString(frame.Data[1:], ISO88591)
+		if len(rest) < 1 {
+			return nil, fmt.Errorf("Truncated APIC frame")
+		}
+		pictureType := rest[0]
+		description, data := splitString(rest[1:], encoding)
+		descriptionText, err := decodeString(description, encoding)
+		if err != nil {
+			return nil, err
+		}
+
+		pic := picture.New(uint32(pictureType), data)
+		if len(mime) > 0 {
+			pic.MIME = strings.ToLower(string(mime))
+			if !strings.Contains(pic.MIME, "/") {
+				// ID3v2.2 style image formats
+				pic.MIME = "image/" + strings.ReplaceAll(pic.MIME, "jpg", "jpeg")
+			}
+		}
+		pic.Description = descriptionText
+		pictures = append(pictures, pic)
+	}
+	return pictures, nil
+}
+
+// Replace all attached pictures.
+func (tag *Tag) SetPictures(pictures []picture.Picture) {
+	var frames []Frame
+	for _, pic := range pictures {
+		encoding := chooseEncoding(tag.Version, pic.Description)
+		data := []byte{encoding}
+		data = append(data, encodeString(pic.MIME, ISO88591)...)
+		data = append(data, 0, byte(pic.Type))
+		data = append(data, encodeString(pic.Description, encoding)...)
+		data = append(data, terminator(encoding)...)
+		data = append(data, pic.Data...)
+		frames = append(frames, Frame{ID: "APIC", Data: data})
+	}
+	tag.replaceFrames(byID("APIC"), frames...)
+}
+
+func (tag *Tag) Chapters() ([]Chapter, error) {
+	var chapters []Chapter
+	for _, frame := range tag.Frames {
+		if frame.ID != "CHAP" {
+			continue
+		}
+		id, rest := splitString(frame.Data, ISO88591)
+		if len(rest) < 16 {
+			return nil, fmt.Errorf("Truncated CHAP frame")
+		}
+		frames, err := parseFrames(rest[16:], tag.Version)
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, Chapter{
+			ElementID:   string(id),
+			StartTime:   binary.BigEndian.Uint32(rest[0:]),
+			EndTime:     binary.BigEndian.Uint32(rest[4:]),
+			StartOffset: binary.BigEndian.Uint32(rest[8:]),
+			EndOffset:   binary.BigEndian.Uint32(rest[12:]),
+			Frames:      frames,
+		})
+	}
+	return chapters, nil
+}
+
+func (tag *Tag) SetChapters(chapters []Chapter) {
+	var frames []Frame
+	for _, chapter := range chapters {
+		data := append([]byte(chapter.ElementID), 0)
+		data = binary.BigEndian.AppendUint32(data, chapter.StartTime)
+		data = binary.BigEndian.AppendUint32(data, chapter.EndTime)
+		data = binary.BigEndian.AppendUint32(data, chapter.StartOffset)
+		data = binary.BigEndian.AppendUint32(data, chapter.EndOffset)
+		data = append(data, tag.serializeFrames(chapter.Frames)...)
+		frames = append(frames, Frame{ID: "CHAP", Data: data})
+	}
+	tag.replaceFrames(byID("CHAP"), frames...)
+}
+
+func (tag *Tag) TablesOfContents() ([]TableOfContents, error) {
+	var tables []TableOfContents
+	for _, frame := range tag.Frames {
+		if frame.ID != "CTOC" {
+			continue
+		}
+		id, rest := splitString(frame.Data, ISO88591)
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("Truncated CTOC frame")
+		}
+		table := TableOfContents{
+			ElementID: string(id),
+			TopLevel:  rest[0]&0x02 != 0,
+			Ordered:   rest[0]&0x01 != 0,
+		}
+		count := int(rest[1])
+		rest = rest[2:]
+		for range count {
+			var child []byte
+			child, rest = splitString(rest, ISO88591)
+			table.Children = append(table.Children, string(child))
+		}
+		frames, err := parseFrames(rest, tag.Version)
+		if err != nil {
+			return nil, err
+		}
+		table.Frames = frames
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+func (tag *Tag) SetTablesOfContents(tables []TableOfContents) {
+	var frames []Frame
+	for _, table := range tables {
+		data := append([]byte(table.ElementID), 0)
+		var flags byte
+		if table.TopLevel {
+			flags |= 0x02
+		}
+		if table.Ordered {
+			flags |= 0x01
+		}
+		data = append(data, flags, byte(len(table.Children)))
+		for _, child := range table.Children {
+			data = append(data, child...)
+			data = append(data, 0)
+		}
+		data = append(data, tag.serializeFrames(table.Frames)...)
+		frames = append(frames, Frame{ID: "CTOC", Data: data})
+	}
+	tag.replaceFrames(byID("CTOC"), frames...)
+}
+
+// Title of a chapter taken from its TIT2 sub frame.
+func (chapter Chapter) Title() string {
+	sub := Tag{Version: 4, Frames: chapter.Frames}
+	values := sub.Text("TIT2")
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func trimTerminator(data []byte, encoding byte) []byte {
+	size := terminatorSize(encoding)
+	for len(data) >= size && data[len(data)-1] == 0 && (size == 1 || data[len(data)-2] == 0) {
+		data = data[:len(data)-size]
+	}
+	return data
+}
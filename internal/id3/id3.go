@@ -0,0 +1,358 @@
+package id3
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Chromfalke/audio-workbench/internal/lib"
+)
+
+// Padding added when the tag no longer fits and the file has to be rewritten.
+const defaultPadding = 2048
+
+// Flags in the tag header.
+const (
+	unsynchronisationFlag = 0x80
+	extendedHeaderFlag    = 0x40
+	footerFlag            = 0x10
+)
+
+// Format flags in the second flag byte of ID3v2.4 frames.
+const (
+	groupingFlag    = 0x40
+	compressionFlag = 0x08
+	encryptionFlag  = 0x04
+	frameUnsyncFlag = 0x02
+	dataLengthFlag  = 0x01
+)
+
+// Format flags of ID3v2.3 frames.
+const (
+	v23Compression = 0x80
+	v23Encryption  = 0x40
+	v23Grouping    = 0x20
+)
+
+// Status flags in the first flag byte. ID3v2.3 keeps them one bit higher than ID3v2.4, frames store them as in ID3v2.4.
+const (
+	v24StatusMask = 0x7000
+	v23StatusMask = 0xe000
+)
+
+type Frame struct {
+	ID    string
+	Flags uint16 // status flags, format flags only remain for encrypted frames which are kept as they are
+	Data  []byte
+}
+
+type Tag struct {
+	Version           byte // major version, 3 or 4
+	Unsynchronisation bool // apply unsynchronisation when writing
+	Frames            []Frame
+
+	size int // size of the existing tag including header, padding and footer
+}
+
+// Read the ID3v2 tag at the start of a file. A file without a tag results in an empty ID3v2.4 tag.
+func Read(path string) (*Tag, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 10)
+	_, err = io.ReadFull(file, header)
+	if err != nil || string(header[:3]) != "ID3" {
+		return &Tag{Version: 4}, nil
+	}
+	size := synchsafe(header[6:10])
+	body := make([]byte, size)
+	_, err = io.ReadFull(file, body)
+	if err != nil {
+		return nil, fmt.Errorf("Truncated ID3v2 tag")
+	}
+
+	tag, err := parse(header, body)
+	if err != nil {
+		return nil, err
+	}
+	tag.size = 10 + size
+	if header[5]&footerFlag != 0 {
+		tag.size += 10
+	}
+	return tag, nil
+}
+
+func parse(header []byte, body []byte) (*Tag, error) {
+	version := header[3]
+	flags := header[5]
+	if version == 2 {
+		return nil, fmt.Errorf("ID3v2.2 tags are not supported")
+	}
+	if version != 3 && version != 4 {
+		return nil, fmt.Errorf("Unknown ID3v2 version 2.%d", version)
+	}
+
+	tag := &Tag{Version: version, Unsynchronisation: flags&unsynchronisationFlag != 0}
+	if version == 3 && tag.Unsynchronisation {
+		body = resynchronise(body)
+	}
+	if flags&extendedHeaderFlag != 0 {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("Truncated extended header")
+		}
+		var skip int
+		if version == 3 {
+			skip = int(binary.BigEndian.Uint32(body)) + 4
+		} else {
+			skip = synchsafe(body[:4])
+		}
+		if skip > len(body) {
+			return nil, fmt.Errorf("Truncated extended header")
+		}
+		body = body[skip:]
+	}
+
+	frames, err := parseFrames(body, version)
+	if err != nil {
+		return nil, err
+	}
+	tag.Frames = frames
+	return tag, nil
+}
+
+// Parse a sequence of frames until the data or the padding starts.
+func parseFrames(data []byte, version byte) ([]Frame, error) {
+	var frames []Frame
+	for len(data) >= 10 && data[0] != 0 {
+		id := string(data[:4])
+		var size int
+		if version == 4 {
+			size = synchsafe(data[4:8])
+		} else {
+			size = int(binary.BigEndian.Uint32(data[4:8]))
+		}
+		flags := binary.BigEndian.Uint16(data[8:10])
+		if 10+size > len(data) {
+			return nil, fmt.Errorf("Frame %s exceeds the tag", id)
+		}
+		content := data[10 : 10+size]
+		data = data[10+size:]
+
+		frame, err := decodeFrame(id, flags, content, version)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// Undo the format flags of a frame so only the plain frame content remains.
+func decodeFrame(id string, flags uint16, content []byte, version byte) (Frame, error) {
+	var compressed, encrypted bool
+	var status uint16
+	if version == 4 {
+		format := byte(flags)
+		status = flags & v24StatusMask
+		compressed = format&compressionFlag != 0
+		encrypted = format&encryptionFlag != 0
+		if encrypted {
+			return Frame{ID: id, Flags: flags, Data: content}, nil
+		}
+		if format&groupingFlag != 0 && len(content) > 0 {
+			content = content[1:]
+		}
+		if format&dataLengthFlag != 0 && len(content) >= 4 {
+			content = content[4:]
+		}
+		if format&frameUnsyncFlag != 0 {
+			content = resynchronise(content)
+		}
+	} else {
+		format := byte(flags)
+		status = flags & v23StatusMask >> 1
+		compressed = format&v23Compression != 0
+		encrypted = format&v23Encryption != 0
+		if encrypted {
+			return Frame{ID: id, Flags: flags, Data: content}, nil
+		}
+		if compressed && len(content) >= 4 {
+			// decompressed size
+			content = content[4:]
+		}
+		if format&v23Grouping != 0 && len(content) > 0 {
+			content = content[1:]
+		}
+	}
+
+	if compressed {
+		reader, err := zlib.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return Frame{}, fmt.Errorf("Failed to decompress frame %s: %s", id, err)
+		}
+		content, err = io.ReadAll(reader)
+		if err != nil {
+			return Frame{}, fmt.Errorf("Failed to decompress frame %s: %s", id, err)
+		}
+	}
+
+	return Frame{ID: id, Flags: status, Data: content}, nil
+}
+
+// Serialize the frames in the version of the tag.
+func (tag *Tag) serializeFrames(frames []Frame) []byte {
+	var data []byte
+	for _, frame := range frames {
+		content := frame.Data
+		flags := frame.Flags
+		encrypted := byte(flags)&encryptionFlag != 0 && tag.Version == 4 || byte(flags)&v23Encryption != 0 && tag.Version == 3
+		if !encrypted {
+			if tag.Version == 3 {
+				flags = flags << 1 & v23StatusMask
+			} else {
+				flags &= v24StatusMask
+				if tag.Unsynchronisation {
+					unsynchronised := unsynchronise(content)
+					if len(unsynchronised) != len(content) {
+						content = unsynchronised
+						flags |= frameUnsyncFlag
+					}
+				}
+			}
+		}
+
+		data = append(data, frame.ID...)
+		if tag.Version == 4 {
+			data = append(data, putSynchsafe(len(content))...)
+		} else {
+			data = binary.BigEndian.AppendUint32(data, uint32(len(content)))
+		}
+		data = binary.BigEndian.AppendUint16(data, flags)
+		data = append(data, content...)
+	}
+	return data
+}
+
+// Serialize the whole tag with the given amount of padding.
+func (tag *Tag) serialize(padding int) []byte {
+	body := tag.serializeFrames(tag.Frames)
+	var flags byte
+	if tag.Unsynchronisation {
+		flags |= unsynchronisationFlag
+		if tag.Version == 3 {
+			body = unsynchronise(body)
+		}
+	}
+	body = append(body, make([]byte, padding)...)
+
+	header := []byte{'I', 'D', '3', tag.Version, 0, flags}
+	header = append(header, putSynchsafe(len(body))...)
+	return append(header, body...)
+}
+
+// Write the tag to the start of the file. If the new tag fits into the old one including its padding the file is
+// changed in place, otherwise the file is rewritten with fresh padding.
+func (tag *Tag) Write(path string) error {
+	data := tag.serialize(0)
+	if len(data) <= tag.size {
+		data = tag.serialize(tag.size - len(data))
+		file, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = file.WriteAt(data, 0)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+
+	data = tag.serialize(defaultPadding)
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	_, err = source.Seek(int64(tag.size), io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	err = lib.ReplaceFile(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, source)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	tag.size = len(data)
+	return nil
+}
+
+// Remove the tag from the file.
+func Remove(path string) error {
+	tag, err := Read(path)
+	if err != nil {
+		return err
+	}
+	if tag.size == 0 {
+		return nil
+	}
+
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	_, err = source.Seek(int64(tag.size), io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return lib.ReplaceFile(path, func(w io.Writer) error {
+		_, err := io.Copy(w, source)
+		return err
+	})
+}
+
+func synchsafe(data []byte) int {
+	return int(data[0]&0x7f)<<21 | int(data[1]&0x7f)<<14 | int(data[2]&0x7f)<<7 | int(data[3]&0x7f)
+}
+
+func putSynchsafe(value int) []byte {
+	return []byte{byte(value >> 21 & 0x7f), byte(value >> 14 & 0x7f), byte(value >> 7 & 0x7f), byte(value & 0x7f)}
+}
+
+// Insert a zero byte after every 0xFF that is followed by a byte which could be mistaken for a frame sync.
+func unsynchronise(data []byte) []byte {
+	result := make([]byte, 0, len(data))
+	for i, b := range data {
+		result = append(result, b)
+		if b == 0xff && (i+1 == len(data) || data[i+1] >= 0xe0 || data[i+1] == 0x00) {
+			result = append(result, 0x00)
+		}
+	}
+	return result
+}
+
+// Remove the zero bytes inserted by unsynchronisation.
+func resynchronise(data []byte) []byte {
+	result := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		result = append(result, data[i])
+		if data[i] == 0xff && i+1 < len(data) && data[i+1] == 0x00 {
+			i++
+		}
+	}
+	return result
+}
@@ -0,0 +1,283 @@
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSynchsafe(t *testing.T) {
+	tests := []struct {
+		value int
+		data  []byte
+	}{
+		{0, []byte{0, 0, 0, 0}},
+		{127, []byte{0, 0, 0, 0x7f}},
+		{128, []byte{0, 0, 1, 0}},
+		{200, []byte{0, 0, 1, 0x48}},
+		{1<<28 - 1, []byte{0x7f, 0x7f, 0x7f, 0x7f}},
+	}
+	for _, test := range tests {
+		if got := putSynchsafe(test.value); !bytes.Equal(got, test.data) {
+			t.Errorf("putSynchsafe(%d) = % x, want % x", test.value, got, test.data)
+		}
+		if got := synchsafe(test.data); got != test.value {
+			t.Errorf("synchsafe(% x) = %d, want %d", test.data, got, test.value)
+		}
+	}
+}
+
+func TestUnsynchronise(t *testing.T) {
+	tests := []struct {
+		plain  []byte
+		unsync []byte
+	}{
+		{[]byte{0x01, 0x02}, []byte{0x01, 0x02}},
+		{[]byte{0xff, 0x7f}, []byte{0xff, 0x7f}},
+		{[]byte{0xff, 0xe0}, []byte{0xff, 0x00, 0xe0}},
+		{[]byte{0xff, 0xfb, 0x90}, []byte{0xff, 0x00, 0xfb, 0x90}},
+		{[]byte{0xff, 0x00}, []byte{0xff, 0x00, 0x00}},
+		{[]byte{0xff, 0xff, 0xff}, []byte{0xff, 0x00, 0xff, 0x00, 0xff, 0x00}},
+		{[]byte{0x41, 0xff}, []byte{0x41, 0xff, 0x00}},
+	}
+	for _, test := range tests {
+		if got := unsynchronise(test.plain); !bytes.Equal(got, test.unsync) {
+			t.Errorf("unsynchronise(% x) = % x, want % x", test.plain, got, test.unsync)
+		}
+		if got := resynchronise(test.unsync); !bytes.Equal(got, test.plain) {
+			t.Errorf("resynchronise(% x) = % x, want % x", test.unsync, got, test.plain)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		text     string
+		encoding byte
+		data     []byte
+	}{
+		{"Abc", ISO88591, []byte("Abc")},
+		{"Café", ISO88591, []byte{'C', 'a', 'f', 0xe9}},
+		{"Ab", UTF16, []byte{0xff, 0xfe, 'A', 0, 'b', 0}},
+		{"𝄞", UTF16, []byte{0xff, 0xfe, 0x34, 0xd8, 0x1e, 0xdd}},
+		{"Ab", UTF16BE, []byte{0, 'A', 0, 'b'}},
+		{"Café", UTF8, []byte("Café")},
+	}
+	for _, test := range tests {
+		if got := encodeString(test.text, test.encoding); !bytes.Equal(got, test.data) {
+			t.Errorf("encodeString(%q, %d) = % x, want % x", test.text, test.encoding, got, test.data)
+		}
+		if got, err := decodeString(test.data, test.encoding); err != nil || got != test.text {
+			t.Errorf("decodeString(% x, %d) = %q, %v, want %q", test.data, test.encoding, got, err, test.text)
+		}
+	}
+
+	// big endian byte order mark
+	if got, _ := decodeString([]byte{0xfe, 0xff, 0, 'A'}, UTF16); got != "A" {
+		t.Errorf("decodeString of big endian UTF-16 = %q, want %q", got, "A")
+	}
+	// a UTF-16 terminator is only found at even offsets
+	first, rest := splitString([]byte{0xff, 0xfe, 0x00, 0x01, 0x00, 0x00, 'B', 0}, UTF16)
+	if !bytes.Equal(first, []byte{0xff, 0xfe, 0x00, 0x01}) || !bytes.Equal(rest, []byte{'B', 0}) {
+		t.Errorf("splitString = % x, % x", first, rest)
+	}
+
+	if got := chooseEncoding(4, "Abc"); got != UTF8 {
+		t.Errorf("chooseEncoding(4) = %d, want %d", got, UTF8)
+	}
+	if got := chooseEncoding(3, "Café"); got != ISO88591 {
+		t.Errorf("chooseEncoding(3, Latin-1) = %d, want %d", got, ISO88591)
+	}
+	if got := chooseEncoding(3, "Abc", "東京"); got != UTF16 {
+		t.Errorf("chooseEncoding(3, CJK) = %d, want %d", got, UTF16)
+	}
+}
+
+// Serialize the tag and parse it again.
+func roundTrip(t *testing.T, tag *Tag) *Tag {
+	t.Helper()
+	data := tag.serialize(32)
+	parsed, err := parse(data[:10], data[10:])
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	return parsed
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	comments := []Comment{{Language: "eng", Description: "", Text: "Plain comment"}, {Language: "deu", Description: "Beschreibung", Text: "Käse"}}
+	lyrics := []Comment{{Language: "jpn", Description: "", Text: "東京\nline two"}}
+	synced := []SyncedLyrics{{Language: "eng", Description: "LRC", Lines: []SyncedLine{{Time: 0, Text: "First"}, {Time: 12340, Text: "Zweite Zeile ÿ"}, {Time: 1 << 31, Text: ""}}}}
+	// a false frame sync once encoded as ISO-8859-1 in ID3v2.3
+	binaryValue := string([]rune{0xff, 0xfb, 0xff})
+
+	for _, version := range []byte{3, 4} {
+		for _, unsync := range []bool{false, true} {
+			tag := &Tag{Version: version, Unsynchronisation: unsync}
+			tag.SetText("TIT2", "Title")
+			tag.SetText("TPE1", "One", "Two")
+			tag.SetText("TALB", "東京")
+			tag.SetText("TCON", binaryValue)
+			tag.SetUserText("MusicBrainz Album Id", "0123", "4567")
+			tag.SetComments(comments)
+			tag.SetLyrics(lyrics)
+			tag.SetSyncedLyrics(synced)
+
+			parsed := roundTrip(t, tag)
+			name := func(field string) string {
+				return field + " in ID3v2." + string('0'+version)
+			}
+			if parsed.Version != version || parsed.Unsynchronisation != unsync {
+				t.Errorf("%s: parsed version %d and unsynchronisation %v", name("header"), parsed.Version, parsed.Unsynchronisation)
+			}
+			artists := []string{"One", "Two"}
+			if version == 3 {
+				artists = []string{"One/Two"}
+			}
+			tests := []struct {
+				field string
+				got   any
+				want  any
+			}{
+				{"TIT2", parsed.Text("TIT2"), []string{"Title"}},
+				{"TPE1", parsed.Text("TPE1"), artists},
+				{"TALB", parsed.Text("TALB"), []string{"東京"}},
+				{"TCON", parsed.Text("TCON"), []string{binaryValue}},
+				{"TXXX", parsed.UserText("musicbrainz album id"), map[byte][]string{3: {"0123/4567"}, 4: {"0123", "4567"}}[version]},
+				{"COMM", parsed.Comments(), comments},
+				{"USLT", parsed.Lyrics(), lyrics},
+				{"SYLT", parsed.SyncedLyrics(), synced},
+			}
+			for _, test := range tests {
+				if !reflect.DeepEqual(test.got, test.want) {
+					t.Errorf("%s: got %+v, want %+v", name(test.field), test.got, test.want)
+				}
+			}
+		}
+	}
+}
+
+func TestFrameSizes(t *testing.T) {
+	// a frame longer than 127 bytes has a different size field in both versions
+	value := strings.Repeat("a", 199)
+	tests := []struct {
+		version byte
+		size    []byte
+	}{
+		{3, []byte{0, 0, 0, 200}},
+		{4, []byte{0, 0, 1, 0x48}},
+	}
+	for _, test := range tests {
+		tag := &Tag{Version: test.version}
+		tag.SetText("TIT2", value)
+		data := tag.serialize(0)
+		if got := data[14:18]; !bytes.Equal(got, test.size) {
+			t.Errorf("ID3v2.%d frame size is % x, want % x", test.version, got, test.size)
+		}
+		if got := synchsafe(data[6:10]); got != 210 {
+			t.Errorf("ID3v2.%d tag size is %d, want 210", test.version, got)
+		}
+		if got := roundTrip(t, tag).Text("TIT2"); len(got) != 1 || got[0] != value {
+			t.Errorf("ID3v2.%d: TIT2 = %q", test.version, got)
+		}
+	}
+}
+
+func TestUnsynchronisedFrames(t *testing.T) {
+	frame := Frame{ID: "PRIV", Data: []byte{'x', 0, 0xff, 0xe0, 0xff}}
+
+	// ID3v2.4 unsynchronises each frame and flags it
+	tag := &Tag{Version: 4, Unsynchronisation: true, Frames: []Frame{frame}}
+	data := tag.serialize(0)
+	if data[5]&unsynchronisationFlag == 0 || binary.BigEndian.Uint16(data[18:20])&frameUnsyncFlag == 0 {
+		t.Errorf("ID3v2.4 tag flags %02x and frame flags %04x lack unsynchronisation", data[5], binary.BigEndian.Uint16(data[18:20]))
+	}
+	if got := synchsafe(data[14:18]); got != 7 {
+		t.Errorf("ID3v2.4 frame size is %d, want the unsynchronised size 7", got)
+	}
+
+	// ID3v2.3 unsynchronises the whole tag and keeps the sizes of the plain frames
+	tag = &Tag{Version: 3, Unsynchronisation: true, Frames: []Frame{frame}}
+	data = tag.serialize(0)
+	if got := binary.BigEndian.Uint32(data[14:18]); got != 5 {
+		t.Errorf("ID3v2.3 frame size is %d, want the plain size 5", got)
+	}
+	if bytes.Contains(data, []byte{0xff, 0xe0}) {
+		t.Errorf("ID3v2.3 tag contains a false frame sync")
+	}
+
+	for _, version := range []byte{3, 4} {
+		tag := &Tag{Version: version, Unsynchronisation: true, Frames: []Frame{frame}}
+		parsed := roundTrip(t, tag)
+		if len(parsed.Frames) != 1 || !bytes.Equal(parsed.Frames[0].Data, frame.Data) {
+			t.Errorf("ID3v2.%d: frames after the round trip are %+v", version, parsed.Frames)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x64}, 100)
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	err := os.WriteFile(path, audio, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []byte{3, 4} {
+		tag, err := Read(path)
+		if err != nil {
+			t.Fatalf("Read: %s", err)
+		}
+		tag.Version = version
+		tag.SetText("TIT2", strings.Repeat("Long title ", 400))
+		err = tag.Write(path)
+		if err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data[tag.size:], audio) {
+			t.Fatalf("ID3v2.%d: the audio doesn't follow the rewritten tag", version)
+		}
+
+		// a smaller tag reuses the space
+		size := len(data)
+		tag.SetText("TIT2", "Short")
+		err = tag.Write(path)
+		if err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		data, err = os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != size || !bytes.Equal(data[tag.size:], audio) {
+			t.Errorf("ID3v2.%d: the file has %d bytes after writing in place, want %d", version, len(data), size)
+		}
+		reread, err := Read(path)
+		if err != nil {
+			t.Fatalf("Read: %s", err)
+		}
+		if got := reread.Text("TIT2"); len(got) != 1 || got[0] != "Short" || reread.Version != version {
+			t.Errorf("ID3v2.%d: TIT2 = %q after writing in place", version, got)
+		}
+	}
+
+	err = Remove(path)
+	if err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, audio) {
+		t.Errorf("The file has %d bytes after removing the tag, want %d", len(data), len(audio))
+	}
+}
@@ -0,0 +1,134 @@
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Text encodings of ID3v2 frames.
+const (
+	ISO88591 = 0
+	UTF16    = 1 // with byte order mark
+	UTF16BE  = 2 // ID3v2.4 only
+	UTF8     = 3 // ID3v2.4 only
+)
+
+// Length of the string terminator of an encoding.
+func terminatorSize(encoding byte) int {
+	if encoding == UTF16 || encoding == UTF16BE {
+		return 2
+	}
+	return 1
+}
+
+// Split off the next terminated string. A missing terminator consumes the rest of the data.
+func splitString(data []byte, encoding byte) ([]byte, []byte) {
+	size := terminatorSize(encoding)
+	for i := 0; i+size <= len(data); i += size {
+		if size == 1 && data[i] == 0 || size == 2 && data[i] == 0 && data[i+1] == 0 {
+			return data[:i], data[i+size:]
+		}
+	}
+	return data, nil
+}
+
+func decodeString(data []byte, encoding byte) (string, error) {
+	switch encoding {
+	case ISO88591:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	case UTF16, UTF16BE:
+		var order binary.ByteOrder = binary.BigEndian
+		if encoding == UTF16 && len(data) >= 2 {
+			switch {
+			case data[0] == 0xff && data[1] == 0xfe:
+				order = binary.LittleEndian
+				data = data[2:]
+			case data[0] == 0xfe && data[1] == 0xff:
+				data = data[2:]
+			}
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = order.Uint16(data[2*i:])
+		}
+		return string(utf16.Decode(units)), nil
+	case UTF8:
+		if !utf8.Valid(data) {
+			return string(bytes.ToValidUTF8(data, []byte("�"))), nil
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("Unknown text encoding %d", encoding)
+}
+
+// Decode a list of terminated strings.
+func decodeStrings(data []byte, encoding byte) ([]string, error) {
+	var values []string
+	for len(data) > 0 {
+		var value []byte
+		value, data = splitString(data, encoding)
+		text, err := decodeString(value, encoding)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, text)
+	}
+	if len(values) == 0 {
+		values = []string{""}
+	}
+	return values, nil
+}
+
+// Choose the encoding used for writing. ID3v2.4 always uses UTF-8, ID3v2.3 falls back to UTF-16 for text outside of
+// ISO-8859-1.
+func chooseEncoding(version byte, texts ...string) byte {
+	if version == 4 {
+		return UTF8
+	}
+	for _, text := range texts {
+		for _, r := range text {
+			if r > 0xff {
+				return UTF16
+			}
+		}
+	}
+	return ISO88591
+}
+
+func encodeString(text string, encoding byte) []byte {
+	switch encoding {
+	case ISO88591:
+		data := make([]byte, 0, len(text))
+		for _, r := range text {
+			if r > 0xff {
+				r = '?'
+			}
+			data = append(data, byte(r))
+		}
+		return data
+	case UTF16:
+		data := []byte{0xff, 0xfe}
+		for _, unit := range utf16.Encode([]rune(text)) {
+			data = binary.LittleEndian.AppendUint16(data, unit)
+		}
+		return data
+	case UTF16BE:
+		var data []byte
+		for _, unit := range utf16.Encode([]rune(text)) {
+			data = binary.BigEndian.AppendUint16(data, unit)
+		}
+		return data
+	}
+	return []byte(text)
+}
+
+func terminator(encoding byte) []byte {
+	return make([]byte, terminatorSize(encoding))
+}
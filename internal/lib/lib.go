@@ -2,16 +2,43 @@ package lib
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"slices"
-	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/formats"
 )
 
 type Mediafile struct {
 	Path    string
-	IsOpus  bool
 	IsVideo bool
+	Format  formats.Format // zero value for videos and files of unknown formats
+}
+
+func NewMediafile(path string) Mediafile {
+	videoExtensions := []string{".mp4", ".mkv", ".mov"}
+	format, _ := formats.ForPath(path)
+	return Mediafile{
+		Path:    path,
+		IsVideo: slices.Contains(videoExtensions, filepath.Ext(path)),
+		Format:  format,
+	}
+}
+
+// Check whether the file is an audio file of a known format.
+func (file Mediafile) IsAudio() bool {
+	return file.Format.Name != ""
+}
+
+// Check whether the file is an Opus file according to the format table.
+func (file Mediafile) IsOpus() bool {
+	return file.Format.Name == "opus"
+}
+
+// Check whether ffmpeg can only read the format of the file, so the file can't be written back.
+func (file Mediafile) IsReadOnly() bool {
+	return file.IsAudio() && file.Format.Encoder == ""
 }
 
 /*
@@ -32,7 +59,6 @@ func CollectInputFiles(input string) ([]Mediafile, error) {
 		return []Mediafile{}, err
 	}
 
-	videoExtensions := []string{".mp4", ".mkv", ".mov"}
 	var files []Mediafile
 	if inputInfo.IsDir() {
 		entries, err := os.ReadDir(input)
@@ -41,20 +67,11 @@ func CollectInputFiles(input string) ([]Mediafile, error) {
 		}
 		for _, entry := range entries {
 			if !entry.IsDir() {
-				file := Mediafile{
-					Path:    filepath.Join(input, entry.Name()),
-					IsOpus:  strings.HasSuffix(entry.Name(), ".opus"),
-					IsVideo: slices.Contains(videoExtensions, filepath.Ext(entry.Name())),
-				}
-				files = append(files, file)
+				files = append(files, NewMediafile(filepath.Join(input, entry.Name())))
 			}
 		}
 	} else {
-		files = []Mediafile{Mediafile{
-			Path:    input,
-			IsOpus:  strings.HasSuffix(input, ".opus"),
-			IsVideo: slices.Contains(videoExtensions, filepath.Ext(input)),
-		}}
+		files = []Mediafile{NewMediafile(input)}
 	}
 
 	return files, nil
@@ -75,8 +92,67 @@ func RenameTempFile(file Mediafile, outpath string) error {
 	tempfile := fmt.Sprintf("temp%s", filepath.Ext(outpath))
 	if filepath.Base(outpath) == "temp"+filepath.Ext(outpath) {
 		err := os.Rename(tempfile, file.Path)
-		return fmt.Errorf("Failed to overwrite the original file for %s: %s\n", file.Path, err)
+		if err != nil {
+			return fmt.Errorf("Failed to overwrite the original file for %s: %s\n", file.Path, err)
+		}
 	}
 
 	return nil
 }
+
+// Check whether the output path points to the temporary file used for inplace operations.
+func IsTempPath(file Mediafile, outpath string) bool {
+	return outpath == fmt.Sprintf("temp%s", filepath.Ext(file.Path))
+}
+
+func CopyFile(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// Rewrite a file through a temporary file in the same directory. The original is only replaced once the writer
+// succeeded and keeps its permissions.
+func ReplaceFile(path string, write func(io.Writer) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), ".audio-workbench-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	err = write(temp)
+	if err != nil {
+		temp.Close()
+		return err
+	}
+	err = temp.Chmod(info.Mode().Perm())
+	if err != nil {
+		temp.Close()
+		return err
+	}
+	err = temp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
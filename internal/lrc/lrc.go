@@ -0,0 +1,121 @@
+package lrc
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Line struct {
+	Time time.Duration
+	Text string
+}
+
+// Lyrics in the LRC format. Plain text lyrics have no timestamps and aren't synced.
+type Lyrics struct {
+	Synced bool
+	Tags   []Tag // ID tags such as ar and ti, the offset tag is applied to the lines while parsing
+	Lines  []Line
+}
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+var timestampPattern = regexp.MustCompile(`^\[(\d+):(\d+(?:[.:]\d+)?)\]`)
+var tagPattern = regexp.MustCompile(`^\[([A-Za-z#]+):(.*)\]$`)
+
+// Parse LRC or plain text lyrics. Lines may have several timestamps, the lines are sorted by time. ID tags are only
+// read from lyrics with timestamps, plain text is kept as it is.
+func Parse(text string) Lyrics {
+	var lyrics Lyrics
+	var offset time.Duration
+	var timed, plain []Line
+	var tags []Tag
+	for _, raw := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+
+		var times []time.Duration
+		for {
+			match := timestampPattern.FindStringSubmatch(raw)
+			if match == nil {
+				break
+			}
+			minutes, _ := strconv.Atoi(match[1])
+			seconds, _ := strconv.ParseFloat(strings.Replace(match[2], ":", ".", 1), 64)
+			times = append(times, time.Duration(minutes)*time.Minute+time.Duration(seconds*float64(time.Second)))
+			raw = raw[len(match[0]):]
+		}
+		for _, at := range times {
+			timed = append(timed, Line{Time: at, Text: strings.TrimSpace(raw)})
+		}
+		if len(times) > 0 {
+			continue
+		}
+
+		// ID tags only exist in LRC files, plain text keeps lines such as [Chorus: Artist]
+		plain = append(plain, Line{Text: raw})
+		match := tagPattern.FindStringSubmatch(raw)
+		if match != nil {
+			key, value := strings.ToLower(match[1]), strings.TrimSpace(match[2])
+			if key == "offset" {
+				// a positive offset shows the lyrics earlier
+				milliseconds, _ := strconv.Atoi(value)
+				offset = time.Duration(milliseconds) * time.Millisecond
+				continue
+			}
+			tags = append(tags, Tag{Key: key, Value: value})
+		}
+	}
+
+	if len(timed) > 0 {
+		// lines without timestamps can't be placed
+		lyrics.Synced = true
+		lyrics.Tags = tags
+		for i := range timed {
+			timed[i].Time = max(timed[i].Time-offset, 0)
+		}
+		slices.SortStableFunc(timed, func(a Line, b Line) int { return cmp.Compare(a.Time, b.Time) })
+		lyrics.Lines = timed
+	} else {
+		lyrics.Lines = plain
+		// drop the blank lines around the text
+		for len(lyrics.Lines) > 0 && lyrics.Lines[0].Text == "" {
+			lyrics.Lines = lyrics.Lines[1:]
+		}
+		for len(lyrics.Lines) > 0 && lyrics.Lines[len(lyrics.Lines)-1].Text == "" {
+			lyrics.Lines = lyrics.Lines[:len(lyrics.Lines)-1]
+		}
+	}
+	return lyrics
+}
+
+// Format the lyrics as LRC. Plain lyrics are written without timestamps.
+func (lyrics Lyrics) String() string {
+	var builder strings.Builder
+	for _, tag := range lyrics.Tags {
+		fmt.Fprintf(&builder, "[%s:%s]\n", tag.Key, tag.Value)
+	}
+	for _, line := range lyrics.Lines {
+		if lyrics.Synced {
+			centiseconds := line.Time.Milliseconds() / 10
+			fmt.Fprintf(&builder, "[%02d:%02d.%02d]", centiseconds/6000, centiseconds/100%60, centiseconds%100)
+		}
+		builder.WriteString(line.Text + "\n")
+	}
+	return builder.String()
+}
+
+// Return the text of the lyrics without timestamps and tags.
+func (lyrics Lyrics) Plain() string {
+	var lines []string
+	for _, line := range lyrics.Lines {
+		lines = append(lines, line.Text)
+	}
+	return strings.Join(lines, "\n")
+}
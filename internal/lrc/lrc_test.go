@@ -0,0 +1,75 @@
+package lrc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Lyrics
+	}{
+		{
+			"timestamps",
+			"[ar:Artist]\n[ti: Title ]\n[00:01.50]First\n[01:02.25] Second \n[00:03:10]Colon\n[00:04]Whole",
+			Lyrics{Synced: true, Tags: []Tag{{"ar", "Artist"}, {"ti", "Title"}}, Lines: []Line{
+				{1500 * time.Millisecond, "First"},
+				{3100 * time.Millisecond, "Colon"},
+				{4 * time.Second, "Whole"},
+				{62250 * time.Millisecond, "Second"},
+			}},
+		},
+		{
+			"several timestamps per line",
+			"[00:10.00][00:01.00]Chorus\n[00:05.00]Verse",
+			Lyrics{Synced: true, Lines: []Line{{1 * time.Second, "Chorus"}, {5 * time.Second, "Verse"}, {10 * time.Second, "Chorus"}}},
+		},
+		{
+			"offset",
+			"[offset:+500]\r\n[00:01.00]First\r\n[00:00.20]Clamped",
+			Lyrics{Synced: true, Lines: []Line{{0, "Clamped"}, {500 * time.Millisecond, "First"}}},
+		},
+		{
+			"negative offset",
+			"[offset:-250]\n[00:01.00]Later",
+			Lyrics{Synced: true, Lines: []Line{{1250 * time.Millisecond, "Later"}}},
+		},
+		{
+			"lines without timestamps are dropped",
+			"Header\n[00:01.00]Timed",
+			Lyrics{Synced: true, Lines: []Line{{1 * time.Second, "Timed"}}},
+		},
+		{
+			"plain text",
+			"\n[Chorus: Artist]\nFirst line\n\n[Verse]\nSecond line  \n\n",
+			Lyrics{Lines: []Line{{0, "[Chorus: Artist]"}, {0, "First line"}, {0, ""}, {0, "[Verse]"}, {0, "Second line"}}},
+		},
+	}
+	for _, test := range tests {
+		if got := Parse(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Parse = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	synced := Lyrics{Synced: true, Tags: []Tag{{"ar", "Artist"}}, Lines: []Line{{1500 * time.Millisecond, "First"}, {62259 * time.Millisecond, "Second"}, {100 * time.Minute, "Late"}}}
+	want := "[ar:Artist]\n[00:01.50]First\n[01:02.25]Second\n[100:00.00]Late\n"
+	if got := synced.String(); got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+	if got := synced.Plain(); got != "First\nSecond\nLate" {
+		t.Errorf("Plain = %q", got)
+	}
+
+	plain := "[Chorus: Artist]\nFirst line"
+	if got := Parse(plain).String(); got != plain+"\n" {
+		t.Errorf("String of plain text = %q, want %q", got, plain+"\n")
+	}
+	if got := Parse(want).String(); got != want {
+		t.Errorf("String after parsing = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,294 @@
+package mp3
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Size of a global gain step in dB.
+const GainStep = 1.5
+
+var bitrates = [2][16]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG 1
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG 2 and 2.5
+}
+
+var sampleRates = map[byte][3]int{
+	3: {44100, 48000, 32000}, // MPEG 1
+	2: {22050, 24000, 16000}, // MPEG 2
+	0: {11025, 12000, 8000},  // MPEG 2.5
+}
+
+type header struct {
+	version    byte // 3 = MPEG 1, 2 = MPEG 2, 0 = MPEG 2.5
+	protected  bool
+	sampleRate int
+	channels   int
+	length     int
+}
+
+// Parse a layer III frame header. Other layers and free format bitrates are not supported.
+func parseHeader(data []byte) (header, bool) {
+	if len(data) < 4 || data[0] != 0xff || data[1]&0xe0 != 0xe0 {
+		return header{}, false
+	}
+	version := data[1] >> 3 & 0x03
+	layer := data[1] >> 1 & 0x03
+	if version == 1 || layer != 1 {
+		return header{}, false
+	}
+	bitrateIndex := data[2] >> 4
+	sampleRateIndex := data[2] >> 2 & 0x03
+	if bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return header{}, false
+	}
+
+	h := header{
+		version:    version,
+		protected:  data[1]&0x01 == 0,
+		sampleRate: sampleRates[version][sampleRateIndex],
+		channels:   2,
+	}
+	if data[3]>>6 == 3 {
+		h.channels = 1
+	}
+	padding := int(data[2] >> 1 & 0x01)
+	if version == 3 {
+		h.length = 144000*bitrates[0][bitrateIndex]/h.sampleRate + padding
+	} else {
+		h.length = 72000*bitrates[1][bitrateIndex]/h.sampleRate + padding
+	}
+
+	return h, true
+}
+
+// Bit offsets of the global gain fields relative to the start of the side information.
+func (h header) gainOffsets() []int {
+	var offsets []int
+	if h.version == 3 {
+		base := 9 + 5 + 4*h.channels
+		if h.channels == 2 {
+			base = 9 + 3 + 4*h.channels
+		}
+		for granule := range 2 {
+			for channel := range h.channels {
+				offsets = append(offsets, base+(granule*h.channels+channel)*59+21)
+			}
+		}
+	} else {
+		base := 8 + h.channels
+		for channel := range h.channels {
+			offsets = append(offsets, base+channel*63+21)
+		}
+	}
+	return offsets
+}
+
+func (h header) sideInfoSize() int {
+	switch {
+	case h.version == 3 && h.channels == 1:
+		return 17
+	case h.version == 3:
+		return 32
+	case h.channels == 1:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// Call the handler for the side information of every audio frame.
+func walkFrames(data []byte, handler func(frame []byte, h header)) error {
+	pos := skipID3v2(data)
+	end := trailingTags(data)
+	var first *header
+	frames := 0
+	for pos+4 <= end {
+		if string(data[pos:min(pos+8, end)]) == "APETAGEX" || string(data[pos:min(pos+11, end)]) == "LYRICSBEGIN" {
+			// trailing tags the size check at the end of the file didn't catch end the search
+			break
+		}
+		h, ok := parseHeader(data[pos:end])
+		if !ok || pos+h.length > end || (first != nil && (h.version != first.version || h.sampleRate != first.sampleRate)) {
+			// resynchronize on garbage
+			pos++
+			continue
+		}
+		if first == nil {
+			first = &h
+		}
+		if !isInfoFrame(data[pos:pos+h.length], h) {
+			handler(data[pos:pos+h.length], h)
+		}
+		frames++
+		pos += h.length
+	}
+	if frames == 0 {
+		return fmt.Errorf("No MPEG layer III frames found")
+	}
+	return nil
+}
+
+// Check for the Xing/Info or VBRI frame encoders put in front of the audio. It carries no audio and its side information
+// is empty.
+func isInfoFrame(frame []byte, h header) bool {
+	start := 4 + h.sideInfoSize()
+	if h.protected {
+		start += 2
+	}
+	if start+4 <= len(frame) {
+		tag := string(frame[start : start+4])
+		if tag == "Xing" || tag == "Info" {
+			return true
+		}
+	}
+	return len(frame) >= 40 && string(frame[36:40]) == "VBRI"
+}
+
+func skipID3v2(data []byte) int {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return 0
+	}
+	size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9])
+	size += 10
+	if data[5]&0x10 != 0 {
+		// footer
+		size += 10
+	}
+	return min(size, len(data))
+}
+
+// Find where the tags appended to the audio start: an ID3v1 tag, an APEv2 tag and a Lyrics3v2 tag in any order of
+// nesting mp3 taggers produce. Returns the length of the data if there are none.
+func trailingTags(data []byte) int {
+	end := len(data)
+	for {
+		switch {
+		case end >= 128 && string(data[end-128:end-125]) == "TAG":
+			end -= 128
+		case end >= 32 && string(data[end-32:end-24]) == "APETAGEX":
+			// the size covers the items and the footer, the header is flagged separately
+			size := int(binary.LittleEndian.Uint32(data[end-20 : end-16]))
+			if binary.LittleEndian.Uint32(data[end-12:end-8])&0x80000000 != 0 {
+				size += 32
+			}
+			if size < 32 || size > end {
+				return end
+			}
+			end -= size
+		case end >= 15 && string(data[end-9:end]) == "LYRICS200":
+			var size int
+			_, err := fmt.Sscanf(string(data[end-15:end-9]), "%06d", &size)
+			if err != nil || size+15 > end {
+				return end
+			}
+			end -= size + 15
+		default:
+			return end
+		}
+	}
+}
+
+func readBits(data []byte, offset int, count int) int {
+	value := 0
+	for i := range count {
+		bit := offset + i
+		value = value<<1 | int(data[bit/8]>>(7-bit%8)&0x01)
+	}
+	return value
+}
+
+func writeBits(data []byte, offset int, count int, value int) {
+	for i := range count {
+		bit := offset + i
+		mask := byte(0x80 >> (bit % 8))
+		if value>>(count-1-i)&0x01 != 0 {
+			data[bit/8] |= mask
+		} else {
+			data[bit/8] &^= mask
+		}
+	}
+}
+
+// CRC-16 used for protected frames. It covers the last two header bytes and the side information.
+func checksum(frame []byte, sideInfoSize int) uint16 {
+	crc := uint16(0xffff)
+	data := append([]byte{frame[2], frame[3]}, frame[6:6+sideInfoSize]...)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func sideInfo(frame []byte, h header) []byte {
+	start := 4
+	if h.protected {
+		start = 6
+	}
+	if start+h.sideInfoSize() > len(frame) {
+		return nil
+	}
+	return frame[start : start+h.sideInfoSize()]
+}
+
+// Return the smallest and the largest global gain of all granules.
+func GainRange(path string) (int, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	lowest, highest := 255, 0
+	err = walkFrames(data, func(frame []byte, h header) {
+		info := sideInfo(frame, h)
+		if info == nil {
+			return
+		}
+		for _, offset := range h.gainOffsets() {
+			gain := readBits(info, offset, 8)
+			lowest = min(lowest, gain)
+			highest = max(highest, gain)
+		}
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return lowest, highest, nil
+}
+
+// Shift the global gain of every granule by the given number of 1.5 dB steps. The file is changed in place and keeps
+// its size. Gains that would leave the valid range are clamped.
+func AdjustGain(path string, steps int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	err = walkFrames(data, func(frame []byte, h header) {
+		info := sideInfo(frame, h)
+		if info == nil {
+			return
+		}
+		for _, offset := range h.gainOffsets() {
+			gain := readBits(info, offset, 8)
+			writeBits(info, offset, 8, max(min(gain+steps, 255), 0))
+		}
+		if h.protected {
+			binary.BigEndian.PutUint16(frame[4:6], checksum(frame, h.sideInfoSize()))
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0)
+}
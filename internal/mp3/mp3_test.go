@@ -0,0 +1,240 @@
+package mp3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type layout struct {
+	name      string
+	header    [4]byte
+	length    int
+	version   byte
+	channels  int
+	protected bool
+}
+
+var layouts = []layout{
+	{"MPEG 1 stereo", [4]byte{0xff, 0xfb, 0x90, 0x04}, 417, 3, 2, false},
+	{"MPEG 1 joint stereo with CRC", [4]byte{0xff, 0xfa, 0x90, 0x44}, 417, 3, 2, true},
+	{"MPEG 1 mono", [4]byte{0xff, 0xfb, 0x90, 0xc4}, 417, 3, 1, false},
+	{"MPEG 2 stereo", [4]byte{0xff, 0xf3, 0x80, 0x04}, 208, 2, 2, false},
+	{"MPEG 2 mono with CRC", [4]byte{0xff, 0xf2, 0x80, 0xc4}, 208, 2, 1, true},
+	{"MPEG 2.5 mono", [4]byte{0xff, 0xe3, 0x80, 0xc4}, 417, 0, 1, false},
+}
+
+type bitWriter struct {
+	data []byte
+	bits int
+}
+
+func (w *bitWriter) write(count int, value int) {
+	for i := count - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if value>>i&0x01 != 0 {
+			w.data[w.bits/8] |= 0x80 >> (w.bits % 8)
+		}
+		w.bits++
+	}
+}
+
+// Build the side information field by field as laid out in ISO 11172-3 and 13818-3. All fields apart from the global
+// gains are set to ones so a gain written at a wrong offset shows up in them.
+func buildSideInfo(l layout, gains []int) []byte {
+	var w bitWriter
+	granules := 1
+	if l.version == 3 {
+		granules = 2
+		w.write(9, 0x1ff)
+		if l.channels == 1 {
+			w.write(5, 0x1f)
+		} else {
+			w.write(3, 0x07)
+		}
+		w.write(4*l.channels, 0xff)
+	} else {
+		w.write(8, 0xff)
+		w.write(l.channels, 0x03)
+	}
+	for granule := range granules {
+		for channel := range l.channels {
+			w.write(12, 0xfff) // part2_3_length
+			w.write(9, 0x1ff)  // big_values
+			w.write(8, gains[granule*l.channels+channel])
+			if l.version == 3 {
+				w.write(4, 0x0f) // scalefac_compress
+			} else {
+				w.write(9, 0x1ff)
+			}
+			w.write(1, 0)      // window_switching_flag
+			w.write(15, 0x7ff) // table_select
+			w.write(4, 0x0f)   // region0_count
+			w.write(3, 0x07)   // region1_count
+			if l.version == 3 {
+				w.write(1, 1) // preflag
+			}
+			w.write(2, 0x03) // scalefac_scale and count1table_select
+		}
+	}
+	return w.data
+}
+
+func buildFrame(l layout, gains []int) []byte {
+	frame := make([]byte, l.length)
+	copy(frame, l.header[:])
+	start := 4
+	if l.protected {
+		start = 6
+	}
+	copy(frame[start:], buildSideInfo(l, gains))
+	if l.protected {
+		h, _ := parseHeader(frame)
+		binary.BigEndian.PutUint16(frame[4:6], checksum(frame, h.sideInfoSize()))
+	}
+	return frame
+}
+
+func buildInfoFrame(l layout) []byte {
+	frame := make([]byte, l.length)
+	copy(frame, l.header[:])
+	h, _ := parseHeader(frame)
+	start := 4 + h.sideInfoSize()
+	if l.protected {
+		start += 2
+	}
+	copy(frame[start:], "Info")
+	return frame
+}
+
+func TestParseHeader(t *testing.T) {
+	for _, l := range layouts {
+		h, ok := parseHeader(l.header[:])
+		if !ok {
+			t.Errorf("%s: header not recognized", l.name)
+			continue
+		}
+		if h.version != l.version || h.channels != l.channels || h.protected != l.protected || h.length != l.length {
+			t.Errorf("%s: parsed %+v", l.name, h)
+		}
+		if size := len(buildSideInfo(l, make([]int, 4))); h.sideInfoSize() != size {
+			t.Errorf("%s: side information has %d bytes, want %d", l.name, h.sideInfoSize(), size)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// CRC-16 with polynomial 0x8005 and initial value 0xffff has the check value 0xaee7
+	frame := []byte{0xff, 0xfa, '1', '2', 0, 0, '3', '4', '5', '6', '7', '8', '9'}
+	if got := checksum(frame, 7); got != 0xaee7 {
+		t.Errorf("checksum = %04x, want aee7", got)
+	}
+}
+
+// Tags following the audio, the APEv2 item holds a complete frame that must not be touched.
+func trailingData(l layout) []byte {
+	item := buildFrame(l, []int{1, 2, 3, 4})
+	var ape bytes.Buffer
+	binary.Write(&ape, binary.LittleEndian, uint32(len(item)))
+	binary.Write(&ape, binary.LittleEndian, uint32(0))
+	ape.WriteString("Cover\x00")
+	ape.Write(item)
+	footer := make([]byte, 32)
+	copy(footer, "APETAGEX")
+	binary.LittleEndian.PutUint32(footer[8:], 2000)
+	binary.LittleEndian.PutUint32(footer[12:], uint32(ape.Len()+32))
+	binary.LittleEndian.PutUint32(footer[16:], 1)
+	ape.Write(footer)
+
+	id3v1 := make([]byte, 128)
+	copy(id3v1, "TAG")
+	return append(ape.Bytes(), id3v1...)
+}
+
+func TestAdjustGain(t *testing.T) {
+	for _, l := range layouts {
+		t.Run(l.name, func(t *testing.T) {
+			gains := [][]int{{100, 120, 140, 160}, {90, 200, 60, 130}}
+			data := []byte("ID3\x04\x00\x00\x00\x00\x00\x00")
+			data = append(data, buildInfoFrame(l)...)
+			for _, frame := range gains {
+				data = append(data, buildFrame(l, frame)...)
+			}
+			trailing := trailingData(l)
+			data = append(data, trailing...)
+			path := filepath.Join(t.TempDir(), "test.mp3")
+			err := os.WriteFile(path, data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			granules := l.channels
+			if l.version == 3 {
+				granules *= 2
+			}
+			lowest, highest := 255, 0
+			for _, frame := range gains {
+				for _, gain := range frame[:granules] {
+					lowest, highest = min(lowest, gain), max(highest, gain)
+				}
+			}
+			gotLowest, gotHighest, err := GainRange(path)
+			if err != nil {
+				t.Fatalf("GainRange: %s", err)
+			}
+			if gotLowest != lowest || gotHighest != highest {
+				t.Errorf("GainRange = %d, %d, want %d, %d", gotLowest, gotHighest, lowest, highest)
+			}
+
+			err = AdjustGain(path, 60)
+			if err != nil {
+				t.Fatalf("AdjustGain: %s", err)
+			}
+			adjusted, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := data[:10+l.length]
+			for _, frame := range gains {
+				shifted := make([]int, len(frame))
+				for i, gain := range frame {
+					shifted[i] = min(gain+60, 255)
+				}
+				want = append(want, buildFrame(l, shifted)...)
+			}
+			want = append(want, trailing...)
+			if !bytes.Equal(adjusted, want) {
+				for i := range adjusted {
+					if adjusted[i] != want[i] {
+						t.Fatalf("Byte %d is %02x, want %02x", i, adjusted[i], want[i])
+					}
+				}
+				t.Fatalf("The file has %d bytes, want %d", len(adjusted), len(want))
+			}
+		})
+	}
+}
+
+func TestTrailingTags(t *testing.T) {
+	audio := buildFrame(layouts[0], []int{1, 2, 3, 4})
+	lyrics := append([]byte("LYRICSBEGININD0000210"), "000021LYRICS200"...)
+	tests := []struct {
+		name     string
+		trailing []byte
+	}{
+		{"none", nil},
+		{"ID3v1 and APEv2", trailingData(layouts[0])},
+		{"Lyrics3v2 and ID3v1", append(lyrics, trailingData(layouts[0])[len(trailingData(layouts[0]))-128:]...)},
+	}
+	for _, test := range tests {
+		data := append(bytes.Clone(audio), test.trailing...)
+		if got := trailingTags(data); got != len(audio) {
+			t.Errorf("%s: trailingTags = %d, want %d", test.name, got, len(audio))
+		}
+	}
+}
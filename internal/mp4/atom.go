@@ -0,0 +1,178 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Atoms whose payload consists of child atoms. meta additionally starts with version and flags.
+var containers = map[string]bool{
+	"moov": true,
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+	"udta": true,
+	"meta": true,
+	"ilst": true,
+	"edts": true,
+	"dinf": true,
+}
+
+type atom struct {
+	kind     string
+	prefix   []byte // version and flags of full boxes that hold children
+	data     []byte // payload of leaf atoms
+	children []*atom
+}
+
+// Location of a top-level atom in the file.
+type span struct {
+	kind   string
+	offset int64
+	size   int64
+}
+
+// List the top-level atoms without reading their payload.
+func scan(r io.ReaderAt, fileSize int64) ([]span, error) {
+	var spans []span
+	for offset := int64(0); offset < fileSize; {
+		header := make([]byte, 16)
+		n, err := r.ReadAt(header, offset)
+		if n < 8 {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("Truncated atom header at %d: %s", offset, err)
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		kind := string(header[4:8])
+		switch size {
+		case 0:
+			size = fileSize - offset
+		case 1:
+			if n < 16 {
+				return nil, fmt.Errorf("Truncated atom header at %d", offset)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+		}
+		if size < 8 || offset+size > fileSize {
+			return nil, fmt.Errorf("Invalid size of atom %q at %d", kind, offset)
+		}
+		spans = append(spans, span{kind: kind, offset: offset, size: size})
+		offset += size
+	}
+	return spans, nil
+}
+
+// Parse the payload of an atom into a tree of atoms.
+func parseAtom(kind string, payload []byte) (*atom, error) {
+	node := &atom{kind: kind}
+	if !containers[kind] {
+		node.data = payload
+		return node, nil
+	}
+	if kind == "meta" && !(len(payload) >= 8 && string(payload[4:8]) == "hdlr") {
+		// ISO style meta is a full box while QuickTime style meta starts directly with the children
+		if len(payload) < 4 {
+			return nil, fmt.Errorf("Truncated meta atom")
+		}
+		node.prefix = payload[:4]
+		payload = payload[4:]
+	}
+
+	children, err := parseChildren(payload)
+	if err != nil {
+		return nil, err
+	}
+	node.children = children
+	return node, nil
+}
+
+func parseChildren(payload []byte) ([]*atom, error) {
+	var children []*atom
+	for len(payload) > 0 {
+		if len(payload) < 8 {
+			// some writers leave a few zero bytes at the end of containers
+			break
+		}
+		size := int(binary.BigEndian.Uint32(payload))
+		kind := string(payload[4:8])
+		headerSize := 8
+		switch size {
+		case 0:
+			size = len(payload)
+		case 1:
+			if len(payload) < 16 {
+				return nil, fmt.Errorf("Truncated atom %q", kind)
+			}
+			size = int(binary.BigEndian.Uint64(payload[8:]))
+			headerSize = 16
+		}
+		if size < headerSize || size > len(payload) {
+			return nil, fmt.Errorf("Invalid size of atom %q", kind)
+		}
+		child, err := parseAtom(kind, payload[headerSize:size])
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		payload = payload[size:]
+	}
+	return children, nil
+}
+
+func (node *atom) bytes() []byte {
+	// the prefix of a parsed atom shares its array with the payload of the children, appending to it would overwrite
+	// them
+	payload := append([]byte{}, node.prefix...)
+	if node.children != nil || containers[node.kind] {
+		for _, child := range node.children {
+			payload = append(payload, child.bytes()...)
+		}
+	} else {
+		payload = append(payload, node.data...)
+	}
+
+	data := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	data = append(data, node.kind...)
+	return append(data, payload...)
+}
+
+// Return the first child of the kind.
+func (node *atom) child(kind string) *atom {
+	for _, child := range node.children {
+		if child.kind == kind {
+			return child
+		}
+	}
+	return nil
+}
+
+// Return the first child of the kind and create it if it is missing.
+func (node *atom) ensure(kind string) *atom {
+	child := node.child(kind)
+	if child == nil {
+		child = &atom{kind: kind}
+		node.children = append(node.children, child)
+	}
+	return child
+}
+
+// Call the handler for every atom of the kind in the tree.
+func (node *atom) walk(kind string, handler func(*atom) error) error {
+	if node.kind == kind {
+		err := handler(node)
+		if err != nil {
+			return err
+		}
+	}
+	for _, child := range node.children {
+		err := child.walk(kind, handler)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,473 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/lib"
+	"github.com/Chromfalke/audio-workbench/internal/picture"
+)
+
+// Well-known data types of iTunes metadata items.
+const (
+	TypeImplicit = 0
+	TypeUTF8     = 1
+	TypeJPEG     = 13
+	TypePNG      = 14
+	TypeInteger  = 21
+	TypeBMP      = 27
+)
+
+// Item names of the most common iTunes atoms.
+const (
+	Title       = "\xa9nam"
+	Artist      = "\xa9ART"
+	AlbumArtist = "aART"
+	Album       = "\xa9alb"
+	Genre       = "\xa9gen"
+	Date        = "\xa9day"
+	Comment     = "\xa9cmt"
+	Composer    = "\xa9wrt"
+	Lyrics      = "\xa9lyr"
+	Encoder     = "\xa9too"
+	Grouping    = "\xa9grp"
+	Copyright   = "cprt"
+	Track       = "trkn"
+	Disc        = "disk"
+	Compilation = "cpil"
+	Tempo       = "tmpo"
+	Cover       = "covr"
+
+	TitleSort       = "sonm"
+	ArtistSort      = "soar"
+	AlbumSort       = "soal"
+	AlbumArtistSort = "soaa"
+	ComposerSort    = "soco"
+)
+
+// Freeform items are named "----:<mean>:<name>", iTunes uses com.apple.iTunes as mean.
+const FreeformPrefix = "----:com.apple.iTunes:"
+
+// Items for the common Vorbis comment style keys. Other keys are stored as freeform items.
+var itemNames = map[string]string{
+	"TITLE":       Title,
+	"ARTIST":      Artist,
+	"ALBUMARTIST": AlbumArtist,
+	"ALBUM":       Album,
+	"GENRE":       Genre,
+	"DATE":        Date,
+	"COMMENT":     Comment,
+	"COMPOSER":    Composer,
+	"LYRICS":      Lyrics,
+	"ENCODER":     Encoder,
+}
+
+// Return the item name used for a Vorbis comment style key.
+func ItemName(key string) string {
+	name, ok := itemNames[strings.ToUpper(key)]
+	if ok {
+		return name
+	}
+	return FreeformPrefix + key
+}
+
+// Padding added after the movie atom when the file has to be rewritten.
+const defaultPadding = 2048
+
+type Data struct {
+	Type  uint32
+	Value []byte
+}
+
+type Item struct {
+	Name   string
+	Values []Data
+}
+
+type Metadata struct {
+	Items []Item
+
+	moov  *atom
+	spans []span
+	index int // position of the movie atom in spans
+}
+
+// Read the iTunes metadata of an MP4/M4A file.
+func Read(path string) (*Metadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	spans, err := scan(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	metadata := &Metadata{spans: spans, index: -1}
+	for i, s := range spans {
+		if s.kind == "moov" {
+			metadata.index = i
+		}
+	}
+	if metadata.index == -1 || len(spans) == 0 || spans[0].kind != "ftyp" {
+		return nil, fmt.Errorf("Not an MP4 file")
+	}
+
+	moovSpan := spans[metadata.index]
+	raw := make([]byte, moovSpan.size)
+	_, err = file.ReadAt(raw, moovSpan.offset)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := 8
+	if binary.BigEndian.Uint32(raw) == 1 {
+		headerSize = 16
+	}
+	metadata.moov, err = parseAtom("moov", raw[headerSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	ilst := metadata.ilst(false)
+	if ilst != nil {
+		for _, entry := range ilst.children {
+			item, err := parseItem(entry)
+			if err != nil {
+				return nil, err
+			}
+			metadata.Items = append(metadata.Items, item)
+		}
+	}
+	return metadata, nil
+}
+
+// Locate moov.udta.meta.ilst and create the path if requested.
+func (metadata *Metadata) ilst(create bool) *atom {
+	if !create {
+		udta := metadata.moov.child("udta")
+		if udta == nil {
+			return nil
+		}
+		meta := udta.child("meta")
+		if meta == nil {
+			return nil
+		}
+		return meta.child("ilst")
+	}
+
+	meta := metadata.moov.ensure("udta").ensure("meta")
+	if meta.child("hdlr") == nil {
+		meta.prefix = make([]byte, 4)
+		hdlr := &atom{kind: "hdlr", data: []byte("\x00\x00\x00\x00\x00\x00\x00\x00mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00")}
+		meta.children = append([]*atom{hdlr}, meta.children...)
+	}
+	return meta.ensure("ilst")
+}
+
+func parseItem(entry *atom) (Item, error) {
+	children, err := parseChildren(entry.data)
+	if err != nil {
+		return Item{}, fmt.Errorf("Invalid metadata item %q: %s", entry.kind, err)
+	}
+
+	item := Item{Name: entry.kind}
+	var mean, name string
+	for _, child := range children {
+		if len(child.data) < 4 {
+			continue
+		}
+		switch child.kind {
+		case "mean":
+			mean = string(child.data[4:])
+		case "name":
+			name = string(child.data[4:])
+		case "data":
+			if len(child.data) < 8 {
+				continue
+			}
+			item.Values = append(item.Values, Data{Type: binary.BigEndian.Uint32(child.data) & 0xffffff, Value: child.data[8:]})
+		}
+	}
+	if entry.kind == "----" {
+		item.Name = "----:" + mean + ":" + name
+	}
+	return item, nil
+}
+
+func (item Item) atom() *atom {
+	entry := &atom{kind: item.Name}
+	var payload []byte
+	if strings.HasPrefix(item.Name, "----:") {
+		entry.kind = "----"
+		parts := strings.SplitN(item.Name, ":", 3)
+		mean := &atom{kind: "mean", data: append(make([]byte, 4), parts[1]...)}
+		name := &atom{kind: "name", data: append(make([]byte, 4), parts[2]...)}
+		payload = append(mean.bytes(), name.bytes()...)
+	}
+	for _, value := range item.Values {
+		data := binary.BigEndian.AppendUint32(nil, value.Type)
+		data = append(data, 0, 0, 0, 0)
+		data = append(data, value.Value...)
+		payload = append(payload, (&atom{kind: "data", data: data}).bytes()...)
+	}
+	entry.data = payload
+	return entry
+}
+
+func (metadata *Metadata) item(name string) (Item, bool) {
+	for _, item := range metadata.Items {
+		if strings.EqualFold(item.Name, name) {
+			return item, true
+		}
+	}
+	return Item{}, false
+}
+
+// Replace an item. The existing item keeps its position. No values remove the item.
+func (metadata *Metadata) SetItem(name string, values ...Data) {
+	for i, item := range metadata.Items {
+		if strings.EqualFold(item.Name, name) {
+			if len(values) == 0 {
+				metadata.Items = append(metadata.Items[:i], metadata.Items[i+1:]...)
+			} else {
+				metadata.Items[i].Values = values
+			}
+			return
+		}
+	}
+	if len(values) > 0 {
+		metadata.Items = append(metadata.Items, Item{Name: name, Values: values})
+	}
+}
+
+func (metadata *Metadata) Remove(name string) {
+	metadata.SetItem(name)
+}
+
+// Return the text values of an item. Freeform items are matched case insensitively.
+func (metadata *Metadata) Text(name string) []string {
+	item, ok := metadata.item(name)
+	if !ok {
+		return nil
+	}
+	var values []string
+	for _, value := range item.Values {
+		if value.Type == TypeUTF8 || value.Type == TypeImplicit {
+			values = append(values, string(value.Value))
+		}
+	}
+	return values
+}
+
+func (metadata *Metadata) SetText(name string, values ...string) {
+	var data []Data
+	for _, value := range values {
+		data = append(data, Data{Type: TypeUTF8, Value: []byte(value)})
+	}
+	metadata.SetItem(name, data...)
+}
+
+// Return a number and total pair as stored in trkn and disk.
+func (metadata *Metadata) Pair(name string) (int, int, bool) {
+	item, ok := metadata.item(name)
+	if !ok || len(item.Values) == 0 || len(item.Values[0].Value) < 6 {
+		return 0, 0, false
+	}
+	value := item.Values[0].Value
+	return int(binary.BigEndian.Uint16(value[2:])), int(binary.BigEndian.Uint16(value[4:])), true
+}
+
+func (metadata *Metadata) SetPair(name string, number int, total int) {
+	value := make([]byte, 6)
+	if name == Track {
+		value = make([]byte, 8)
+	}
+	binary.BigEndian.PutUint16(value[2:], uint16(number))
+	binary.BigEndian.PutUint16(value[4:], uint16(total))
+	metadata.SetItem(name, Data{Type: TypeImplicit, Value: value})
+}
+
+// Return a boolean flag such as cpil.
+func (metadata *Metadata) Flag(name string) (bool, bool) {
+	item, ok := metadata.item(name)
+	if !ok || len(item.Values) == 0 || len(item.Values[0].Value) == 0 {
+		return false, false
+	}
+	return item.Values[0].Value[len(item.Values[0].Value)-1] != 0, true
+}
+
+func (metadata *Metadata) SetFlag(name string, value bool) {
+	var flag byte
+	if value {
+		flag = 1
+	}
+	metadata.SetItem(name, Data{Type: TypeInteger, Value: []byte{flag}})
+}
+
+// Return the cover images. MP4 has no picture types so every image counts as front cover.
+func (metadata *Metadata) Pictures() []picture.Picture {
+	item, ok := metadata.item(Cover)
+	if !ok {
+		return nil
+	}
+	var pictures []picture.Picture
+	for _, value := range item.Values {
+		pictures = append(pictures, picture.New(picture.FrontCover, value.Value))
+	}
+	return pictures
+}
+
+func (metadata *Metadata) SetPictures(pictures []picture.Picture) {
+	var values []Data
+	for _, pic := range pictures {
+		dataType := uint32(TypeJPEG)
+		switch pic.MIME {
+		case "image/png":
+			dataType = TypePNG
+		case "image/bmp":
+			dataType = TypeBMP
+		}
+		values = append(values, Data{Type: dataType, Value: pic.Data})
+	}
+	metadata.SetItem(Cover, values...)
+}
+
+// Write the metadata back. If the movie atom still fits into its old space including a directly following free atom
+// the file is changed in place. Otherwise the file is rewritten and the chunk offsets are moved accordingly.
+func (metadata *Metadata) Write(path string) error {
+	ilst := metadata.ilst(true)
+	ilst.children = nil
+	for _, item := range metadata.Items {
+		ilst.children = append(ilst.children, item.atom())
+	}
+
+	moovSpan := metadata.spans[metadata.index]
+	available := moovSpan.size
+	if metadata.index+1 < len(metadata.spans) && metadata.spans[metadata.index+1].kind == "free" {
+		available += metadata.spans[metadata.index+1].size
+	}
+
+	data := metadata.moov.bytes()
+	remaining := available - int64(len(data))
+	if remaining == 0 || remaining >= 8 {
+		if remaining >= 8 {
+			data = append(data, (&atom{kind: "free", data: make([]byte, remaining-8)}).bytes()...)
+		}
+		file, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = file.WriteAt(data, moovSpan.offset)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+
+	// everything behind the movie atom moves, which includes the media data of files optimized for streaming
+	padding := (&atom{kind: "free", data: make([]byte, defaultPadding-8)}).bytes()
+	oldEnd := moovSpan.offset + moovSpan.size
+	skip := int64(0)
+	if metadata.index+1 < len(metadata.spans) && metadata.spans[metadata.index+1].kind == "free" {
+		skip = metadata.spans[metadata.index+1].size
+	}
+	delta := int64(len(data)+len(padding)) - moovSpan.size - skip
+	err := metadata.moveChunks(oldEnd, delta)
+	if err != nil {
+		return err
+	}
+	data = append(metadata.moov.bytes(), padding...)
+
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	err = lib.ReplaceFile(path, func(w io.Writer) error {
+		for i, s := range metadata.spans {
+			switch {
+			case i == metadata.index:
+				_, err := w.Write(data)
+				if err != nil {
+					return err
+				}
+			case i == metadata.index+1 && skip > 0:
+				// replaced by the new padding
+			default:
+				_, err := io.Copy(w, io.NewSectionReader(source, s.offset, s.size))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the layout changed, read it again before the next write
+	updated, err := Read(path)
+	if err != nil {
+		return err
+	}
+	*metadata = *updated
+	return nil
+}
+
+// Shift all chunk offsets behind the given file position.
+func (metadata *Metadata) moveChunks(after int64, delta int64) error {
+	err := metadata.moov.walk("stco", func(node *atom) error {
+		if len(node.data) < 8 {
+			return fmt.Errorf("Truncated stco atom")
+		}
+		count := int(binary.BigEndian.Uint32(node.data[4:]))
+		if len(node.data) < 8+4*count {
+			return fmt.Errorf("Truncated stco atom")
+		}
+		for i := range count {
+			position := node.data[8+4*i:]
+			offset := int64(binary.BigEndian.Uint32(position))
+			if offset >= after {
+				offset += delta
+				if offset > math.MaxUint32 {
+					return fmt.Errorf("Chunk offset exceeds 32 bits")
+				}
+				binary.BigEndian.PutUint32(position, uint32(offset))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return metadata.moov.walk("co64", func(node *atom) error {
+		if len(node.data) < 8 {
+			return fmt.Errorf("Truncated co64 atom")
+		}
+		count := int(binary.BigEndian.Uint32(node.data[4:]))
+		if len(node.data) < 8+8*count {
+			return fmt.Errorf("Truncated co64 atom")
+		}
+		for i := range count {
+			position := node.data[8+8*i:]
+			offset := int64(binary.BigEndian.Uint64(position))
+			if offset >= after {
+				binary.BigEndian.PutUint64(position, uint64(offset+delta))
+			}
+		}
+		return nil
+	})
+}
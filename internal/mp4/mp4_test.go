@@ -0,0 +1,262 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Payload of an atom following ilst inside meta which has to survive changes of ilst.
+var extra = bytes.Repeat([]byte("extra"), 20)
+
+// Chunk markers in the media data, each chunk offset points to one of them.
+var chunks = [][]byte{[]byte("chunk-a"), []byte("chunk-b"), []byte("chunk-c")}
+
+func leaf(kind string, data []byte) *atom {
+	return &atom{kind: kind, data: data}
+}
+
+func container(kind string, children ...*atom) *atom {
+	return &atom{kind: kind, children: children}
+}
+
+func chunkTable(kind string, offsets []int64) *atom {
+	data := binary.BigEndian.AppendUint32(nil, 0)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(offsets)))
+	for _, offset := range offsets {
+		if kind == "stco" {
+			data = binary.BigEndian.AppendUint32(data, uint32(offset))
+		} else {
+			data = binary.BigEndian.AppendUint64(data, uint64(offset))
+		}
+	}
+	return leaf(kind, data)
+}
+
+func buildMoov(offsets []int64, title string, trailing *atom) *atom {
+	track := func(table string) *atom {
+		return container("trak", container("mdia", container("minf", container("stbl", chunkTable(table, offsets)))))
+	}
+	meta := container("meta",
+		leaf("hdlr", []byte("\x00\x00\x00\x00\x00\x00\x00\x00mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00")),
+		container("ilst", Item{Name: Title, Values: []Data{{Type: TypeUTF8, Value: []byte(title)}}}.atom()),
+	)
+	meta.prefix = make([]byte, 4)
+	if trailing != nil {
+		meta.children = append(meta.children, trailing)
+	}
+	return container("moov", leaf("mvhd", make([]byte, 100)), track("stco"), track("co64"), container("udta", meta))
+}
+
+// Write an M4A file with the movie atom in front of or behind the media data. A free atom of the given size follows
+// the movie atom if it is positive.
+func writeFixture(t *testing.T, moovFirst bool, free int) string {
+	t.Helper()
+	ftyp := leaf("ftyp", []byte("M4A \x00\x00\x00\x00M4A mp42isom")).bytes()
+	var media []byte
+	var positions []int64
+	for _, chunk := range chunks {
+		positions = append(positions, int64(len(media)))
+		media = append(media, chunk...)
+		media = append(media, bytes.Repeat([]byte{0}, 50)...)
+	}
+	mdat := leaf("mdat", media).bytes()
+	var padding []byte
+	if free > 0 {
+		padding = leaf("free", make([]byte, free-8)).bytes()
+	}
+
+	// the size of the movie atom doesn't depend on the offsets it holds
+	size := len(buildMoov(positions, "Title", leaf("xtra", extra)).bytes())
+	mdatStart := int64(len(ftyp))
+	if moovFirst {
+		mdatStart += int64(size + len(padding))
+	}
+	var offsets []int64
+	for _, position := range positions {
+		offsets = append(offsets, mdatStart+8+position)
+	}
+	moov := buildMoov(offsets, "Title", leaf("xtra", extra)).bytes()
+
+	data := ftyp
+	if moovFirst {
+		data = append(append(append(data, moov...), padding...), mdat...)
+	} else {
+		data = append(append(append(data, mdat...), moov...), padding...)
+	}
+	path := filepath.Join(t.TempDir(), "test.m4a")
+	err := os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Check that every stco and co64 entry still points to its chunk.
+func checkChunks(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	tables := 0
+	for _, kind := range []string{"stco", "co64"} {
+		err = metadata.moov.walk(kind, func(node *atom) error {
+			tables++
+			for i := range chunks {
+				var offset int64
+				if kind == "stco" {
+					offset = int64(binary.BigEndian.Uint32(node.data[8+4*i:]))
+				} else {
+					offset = int64(binary.BigEndian.Uint64(node.data[8+8*i:]))
+				}
+				end := offset + int64(len(chunks[i]))
+				if end > int64(len(data)) || !bytes.Equal(data[offset:end], chunks[i]) {
+					t.Errorf("%s entry %d points to %d which isn't the start of its chunk", kind, i, offset)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tables != 2 {
+		t.Errorf("Found %d chunk offset tables, want 2", tables)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		moovFirst bool
+		free      int
+		grow      int
+		inPlace   bool
+	}{
+		{"streaming layout grown", true, 0, 3000, false},
+		{"streaming layout grown by less than the atom following ilst", true, 256, 20, true},
+		{"streaming layout grown beyond the free atom", true, 64, 3000, false},
+		{"streaming layout within the free atom", true, 256, 100, true},
+		{"streaming layout filling the free atom", true, 256, 256, true},
+		{"media data first grown", false, 0, 3000, false},
+		{"media data first shrunk", false, 0, -3, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeFixture(t, test.moovFirst, test.free)
+			checkChunks(t, path)
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			metadata, err := Read(path)
+			if err != nil {
+				t.Fatalf("Read: %s", err)
+			}
+			title := "Title"
+			if test.grow >= 0 {
+				title += strings.Repeat("x", test.grow)
+			} else {
+				title = title[:len(title)+test.grow]
+			}
+			metadata.SetText(Title, title)
+			err = metadata.Write(path)
+			if err != nil {
+				t.Fatalf("Write: %s", err)
+			}
+
+			after, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.inPlace && after.Size() != before.Size() {
+				t.Errorf("The file grew from %d to %d bytes instead of being written in place", before.Size(), after.Size())
+			}
+			checkChunks(t, path)
+
+			reread, err := Read(path)
+			if err != nil {
+				t.Fatalf("Read: %s", err)
+			}
+			if got := reread.Text(Title); len(got) != 1 || got[0] != title {
+				t.Errorf("Title has %d values after writing", len(got))
+			}
+			meta := reread.moov.child("udta").child("meta")
+			if len(meta.prefix) != 4 || meta.child("xtra") == nil || !bytes.Equal(meta.child("xtra").data, extra) {
+				t.Errorf("The meta atom lost its version or the atom following ilst")
+			}
+		})
+	}
+}
+
+func TestMoveChunks(t *testing.T) {
+	metadata := &Metadata{moov: buildMoov([]int64{100, 1000, 5000}, "Title", nil)}
+	err := metadata.moveChunks(1000, 24)
+	if err != nil {
+		t.Fatalf("moveChunks: %s", err)
+	}
+	for _, kind := range []string{"stco", "co64"} {
+		metadata.moov.walk(kind, func(node *atom) error {
+			var offsets []int64
+			for i := range 3 {
+				if kind == "stco" {
+					offsets = append(offsets, int64(binary.BigEndian.Uint32(node.data[8+4*i:])))
+				} else {
+					offsets = append(offsets, int64(binary.BigEndian.Uint64(node.data[8+8*i:])))
+				}
+			}
+			if offsets[0] != 100 || offsets[1] != 1024 || offsets[2] != 5024 {
+				t.Errorf("%s offsets are %v, want [100 1024 5024]", kind, offsets)
+			}
+			return nil
+		})
+	}
+
+	// stco can't hold offsets beyond 4 GiB
+	err = metadata.moveChunks(0, 1<<32)
+	if err == nil {
+		t.Errorf("moveChunks beyond 32 bits succeeded")
+	}
+}
+
+func TestItems(t *testing.T) {
+	metadata := &Metadata{}
+	metadata.SetText(Title, "Title")
+	metadata.SetText(ItemName("MUSICBRAINZ_ALBUMID"), "0123", "4567")
+	metadata.SetPair(Track, 3, 12)
+	metadata.SetPair(Disc, 1, 2)
+	metadata.SetFlag(Compilation, true)
+
+	read := &Metadata{}
+	for _, item := range metadata.Items {
+		parsed, err := parseItem(item.atom())
+		if err != nil {
+			t.Fatalf("parseItem: %s", err)
+		}
+		read.Items = append(read.Items, parsed)
+	}
+	if got := read.Text(Title); len(got) != 1 || got[0] != "Title" {
+		t.Errorf("Title = %q", got)
+	}
+	if got := read.Text(FreeformPrefix + "musicbrainz_albumid"); len(got) != 2 || got[1] != "4567" {
+		t.Errorf("Freeform item = %q", got)
+	}
+	if number, total, ok := read.Pair(Track); !ok || number != 3 || total != 12 {
+		t.Errorf("Track = %d/%d, %v", number, total, ok)
+	}
+	if number, total, ok := read.Pair(Disc); !ok || number != 1 || total != 2 {
+		t.Errorf("Disc = %d/%d, %v", number, total, ok)
+	}
+	if flag, ok := read.Flag(Compilation); !ok || !flag {
+		t.Errorf("Compilation = %v, %v", flag, ok)
+	}
+}
@@ -0,0 +1,204 @@
+package ogg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Chromfalke/audio-workbench/internal/lib"
+	"github.com/Chromfalke/audio-workbench/internal/vorbiscomment"
+)
+
+// Codec specific layout of the header packets.
+type codec struct {
+	headers int    // number of header packets
+	prefix  string // magic in front of the comments
+	framing bool   // trailing framing bit after the comments
+}
+
+var (
+	opusCodec   = codec{headers: 2, prefix: "OpusTags"}
+	vorbisCodec = codec{headers: 3, prefix: "\x03vorbis", framing: true}
+)
+
+func detectCodec(identification []byte) (codec, error) {
+	switch {
+	case bytes.HasPrefix(identification, []byte("OpusHead")):
+		return opusCodec, nil
+	case bytes.HasPrefix(identification, []byte("\x01vorbis")):
+		return vorbisCodec, nil
+	}
+	return codec{}, fmt.Errorf("Only Opus and Vorbis streams are supported")
+}
+
+// Read the header packets of the first logical stream. Reading stops at the page that completes the last header.
+func readHeaders(r io.Reader) ([][]byte, codec, uint32, uint32, error) {
+	var packets [][]byte
+	var current []byte
+	var stream codec
+	var serial, sequence uint32
+	headers := 1
+	for first := true; len(packets) < headers; first = false {
+		page, err := ReadPage(r)
+		if err != nil {
+			return nil, codec{}, 0, 0, unexpected(err)
+		}
+		if first {
+			serial = page.SerialNumber
+		} else if page.SerialNumber != serial {
+			return nil, codec{}, 0, 0, fmt.Errorf("Multiplexed ogg streams are not supported")
+		}
+		sequence = page.SequenceNumber
+
+		offset := 0
+		for i, segment := range page.Segments {
+			current = append(current, page.Payload[offset:offset+int(segment)]...)
+			offset += int(segment)
+			if segment == 255 {
+				continue
+			}
+			packets = append(packets, current)
+			current = nil
+			if len(packets) == 1 {
+				stream, err = detectCodec(packets[0])
+				if err != nil {
+					return nil, codec{}, 0, 0, err
+				}
+				headers = stream.headers
+			}
+			if len(packets) == headers && i != len(page.Segments)-1 {
+				return nil, codec{}, 0, 0, fmt.Errorf("Audio data starts on a header page")
+			}
+		}
+	}
+
+	return packets, stream, serial, sequence, nil
+}
+
+// Split packets into pages. Every packet continues where the previous one ended and the last page is flushed.
+func paginate(packets [][]byte, serial uint32, sequence uint32) []Page {
+	var pages []Page
+	page := Page{SerialNumber: serial, SequenceNumber: sequence}
+	finished := false
+	flush := func(continued bool) {
+		if !finished {
+			// no packet ends on this page
+			page.GranulePosition = -1
+		}
+		pages = append(pages, page)
+		sequence++
+		page = Page{SerialNumber: serial, SequenceNumber: sequence}
+		if continued {
+			page.HeaderType = Continued
+		}
+		finished = false
+	}
+
+	for _, packet := range packets {
+		for {
+			if len(page.Segments) == 255 {
+				flush(true)
+			}
+			size := min(len(packet), 255)
+			page.Segments = append(page.Segments, byte(size))
+			page.Payload = append(page.Payload, packet[:size]...)
+			packet = packet[size:]
+			if size < 255 {
+				finished = true
+				break
+			}
+		}
+		if len(page.Segments) == 255 {
+			flush(false)
+		}
+	}
+	if len(page.Segments) > 0 {
+		flush(false)
+	}
+
+	return pages
+}
+
+// Read the comments of an Opus or Vorbis stream.
+func ReadComments(path string) (vorbiscomment.Comments, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return vorbiscomment.Comments{}, err
+	}
+	defer file.Close()
+
+	packets, stream, _, _, err := readHeaders(bufio.NewReader(file))
+	if err != nil {
+		return vorbiscomment.Comments{}, err
+	}
+	if !bytes.HasPrefix(packets[1], []byte(stream.prefix)) {
+		return vorbiscomment.Comments{}, fmt.Errorf("The second header is not a comment header")
+	}
+
+	return vorbiscomment.Parse(packets[1][len(stream.prefix):])
+}
+
+// Replace the comments of an Opus or Vorbis stream. The header packets are repaginated and all following pages of the
+// stream are renumbered with fresh checksums. The audio packets themselves are copied unchanged.
+func WriteComments(path string, comments vorbiscomment.Comments) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	packets, stream, serial, lastSequence, err := readHeaders(reader)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(packets[1], []byte(stream.prefix)) {
+		return fmt.Errorf("The second header is not a comment header")
+	}
+
+	packet := append([]byte(stream.prefix), comments.Bytes()...)
+	if stream.framing {
+		packet = append(packet, 0x01)
+	}
+	packets[1] = packet
+
+	// the identification header always has a page of its own
+	pages := paginate(packets[:1], serial, 0)
+	pages[0].HeaderType = BeginOfStream
+	pages[0].GranulePosition = 0
+	headerPages := paginate(packets[1:], serial, 1)
+	headerPages[len(headerPages)-1].GranulePosition = 0
+	pages = append(pages, headerPages...)
+	shift := uint32(len(pages)) - (lastSequence + 1)
+
+	return lib.ReplaceFile(path, func(w io.Writer) error {
+		writer := bufio.NewWriter(w)
+		for _, page := range pages {
+			_, err := writer.Write(page.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+
+		for {
+			page, err := ReadPage(reader)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if page.SerialNumber == serial {
+				page.SequenceNumber += shift
+			}
+			_, err = writer.Write(page.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+
+		return writer.Flush()
+	})
+}
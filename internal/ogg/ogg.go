@@ -0,0 +1,121 @@
+package ogg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Flags of the header type field.
+const (
+	Continued     = 0x01
+	BeginOfStream = 0x02
+	EndOfStream   = 0x04
+)
+
+const headerSize = 27
+
+type Page struct {
+	HeaderType      byte
+	GranulePosition int64
+	SerialNumber    uint32
+	SequenceNumber  uint32
+	Segments        []byte // lacing values
+	Payload         []byte
+}
+
+var crcTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// Calculate the page checksum. The checksum field of the page has to be zeroed.
+func Checksum(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// Read the next page and verify its checksum. Returns io.EOF once the stream ends.
+func ReadPage(r io.Reader) (Page, error) {
+	header := make([]byte, headerSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return Page{}, err
+	}
+	if string(header[:4]) != "OggS" {
+		return Page{}, fmt.Errorf("Missing ogg capture pattern")
+	}
+	if header[4] != 0 {
+		return Page{}, fmt.Errorf("Unsupported ogg version %d", header[4])
+	}
+
+	page := Page{
+		HeaderType:      header[5],
+		GranulePosition: int64(binary.LittleEndian.Uint64(header[6:14])),
+		SerialNumber:    binary.LittleEndian.Uint32(header[14:18]),
+		SequenceNumber:  binary.LittleEndian.Uint32(header[18:22]),
+		Segments:        make([]byte, header[26]),
+	}
+	checksum := binary.LittleEndian.Uint32(header[22:26])
+
+	_, err = io.ReadFull(r, page.Segments)
+	if err != nil {
+		return Page{}, unexpected(err)
+	}
+	size := 0
+	for _, segment := range page.Segments {
+		size += int(segment)
+	}
+	page.Payload = make([]byte, size)
+	_, err = io.ReadFull(r, page.Payload)
+	if err != nil {
+		return Page{}, unexpected(err)
+	}
+
+	if Checksum(page.bytesWithChecksum(0)) != checksum {
+		return Page{}, fmt.Errorf("Checksum mismatch in ogg page %d", page.SequenceNumber)
+	}
+
+	return page, nil
+}
+
+// Serialize the page with a freshly calculated checksum.
+func (page Page) Bytes() []byte {
+	data := page.bytesWithChecksum(0)
+	binary.LittleEndian.PutUint32(data[22:26], Checksum(data))
+	return data
+}
+
+func (page Page) bytesWithChecksum(checksum uint32) []byte {
+	data := make([]byte, headerSize, headerSize+len(page.Segments)+len(page.Payload))
+	copy(data, "OggS")
+	data[5] = page.HeaderType
+	binary.LittleEndian.PutUint64(data[6:14], uint64(page.GranulePosition))
+	binary.LittleEndian.PutUint32(data[14:18], page.SerialNumber)
+	binary.LittleEndian.PutUint32(data[18:22], page.SequenceNumber)
+	binary.LittleEndian.PutUint32(data[22:26], checksum)
+	data[26] = byte(len(page.Segments))
+	data = append(data, page.Segments...)
+	return append(data, page.Payload...)
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,260 @@
+package ogg
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Chromfalke/audio-workbench/internal/vorbiscomment"
+)
+
+// Bitwise reference of the ogg CRC (polynomial 0x04c11db7, no reflection, initial value and final xor zero).
+func referenceChecksum(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func TestChecksum(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("OggS"), []byte("123456789"), bytes.Repeat([]byte{0xff, 0x00, 0x5a}, 1000)} {
+		if got, want := Checksum(data), referenceChecksum(data); got != want {
+			t.Errorf("Checksum(%q) = %08x, want %08x", data, got, want)
+		}
+	}
+}
+
+func TestPageRoundTrip(t *testing.T) {
+	page := Page{
+		HeaderType:      Continued | EndOfStream,
+		GranulePosition: 123456789,
+		SerialNumber:    0xdeadbeef,
+		SequenceNumber:  7,
+		Segments:        []byte{255, 10},
+		Payload:         bytes.Repeat([]byte{0xab}, 265),
+	}
+	data := page.Bytes()
+
+	read, err := ReadPage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadPage: %s", err)
+	}
+	if read.HeaderType != page.HeaderType || read.GranulePosition != page.GranulePosition ||
+		read.SerialNumber != page.SerialNumber || read.SequenceNumber != page.SequenceNumber ||
+		!bytes.Equal(read.Segments, page.Segments) || !bytes.Equal(read.Payload, page.Payload) {
+		t.Errorf("ReadPage returned %+v, want %+v", read, page)
+	}
+	if !bytes.Equal(read.Bytes(), data) {
+		t.Errorf("Page is not serialized to the same bytes")
+	}
+
+	corrupted := bytes.Clone(data)
+	corrupted[len(corrupted)-1] ^= 0x01
+	_, err = ReadPage(bytes.NewReader(corrupted))
+	if err == nil || !strings.Contains(err.Error(), "Checksum mismatch") {
+		t.Errorf("ReadPage of a corrupted page returned %v, want a checksum mismatch", err)
+	}
+
+	_, err = ReadPage(bytes.NewReader(data[:len(data)-1]))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadPage of a truncated page returned %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	_, err = ReadPage(bytes.NewReader(nil))
+	if err != io.EOF {
+		t.Errorf("ReadPage at the end returned %v, want %v", err, io.EOF)
+	}
+}
+
+// Join the pages back into packets.
+func depaginate(t *testing.T, pages []Page) [][]byte {
+	t.Helper()
+	var packets [][]byte
+	var current []byte
+	open := false
+	for i, page := range pages {
+		if len(page.Segments) > 255 {
+			t.Fatalf("Page %d has %d segments", i, len(page.Segments))
+		}
+		if (page.HeaderType&Continued != 0) != open {
+			t.Errorf("Page %d has continued flag %v, want %v", i, page.HeaderType&Continued != 0, open)
+		}
+		offset := 0
+		finished := false
+		for _, segment := range page.Segments {
+			current = append(current, page.Payload[offset:offset+int(segment)]...)
+			offset += int(segment)
+			if segment < 255 {
+				packets = append(packets, current)
+				current = nil
+				finished = true
+			}
+		}
+		if offset != len(page.Payload) {
+			t.Errorf("Page %d has %d payload bytes but its segments add up to %d", i, len(page.Payload), offset)
+		}
+		if !finished && page.GranulePosition != -1 {
+			t.Errorf("Page %d finishes no packet but has granule position %d", i, page.GranulePosition)
+		}
+		open = page.Segments[len(page.Segments)-1] == 255
+	}
+	if open {
+		t.Errorf("The last packet is not finished")
+	}
+	return packets
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes []int
+	}{
+		{"empty packet", []int{0}},
+		{"small packets", []int{1, 100, 254}},
+		{"multiple of 255", []int{255, 510}},
+		{"full page", []int{255 * 254}},
+		{"spanning pages", []int{70000, 3, 255 * 255}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var packets [][]byte
+			for i, size := range test.sizes {
+				packets = append(packets, bytes.Repeat([]byte{byte(i + 1)}, size))
+			}
+
+			pages := paginate(packets, 42, 5)
+			for i, page := range pages {
+				if page.SerialNumber != 42 || page.SequenceNumber != uint32(5+i) {
+					t.Errorf("Page %d has serial %d and sequence %d", i, page.SerialNumber, page.SequenceNumber)
+				}
+			}
+			read := depaginate(t, pages)
+			if len(read) != len(packets) {
+				t.Fatalf("Got %d packets, want %d", len(read), len(packets))
+			}
+			for i := range packets {
+				if !bytes.Equal(read[i], packets[i]) {
+					t.Errorf("Packet %d has %d bytes after paginating, want %d", i, len(read[i]), len(packets[i]))
+				}
+			}
+		})
+	}
+}
+
+// Write an Opus stream with both header pages and a few audio pages, one of them belonging to another stream.
+func writeOpusStream(t *testing.T, comments vorbiscomment.Comments) (string, []Page) {
+	t.Helper()
+	head := append([]byte("OpusHead"), 1, 2, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0)
+	pages := []Page{
+		{HeaderType: BeginOfStream, SerialNumber: 1, SequenceNumber: 0, Segments: []byte{byte(len(head))}, Payload: head},
+	}
+	tags := append([]byte("OpusTags"), comments.Bytes()...)
+	pages = append(pages, paginate([][]byte{tags}, 1, 1)...)
+	sequence := uint32(len(pages))
+	for i := range 3 {
+		payload := bytes.Repeat([]byte{byte(0x10 + i)}, 300)
+		pages = append(pages, Page{SerialNumber: 1, SequenceNumber: sequence, GranulePosition: int64(960 * (i + 1)), Segments: []byte{255, 45}, Payload: payload})
+		sequence++
+	}
+	pages = append(pages, Page{HeaderType: BeginOfStream, SerialNumber: 2, SequenceNumber: 0, Segments: []byte{1}, Payload: []byte{0}})
+	pages[len(pages)-2].HeaderType = EndOfStream
+
+	var data []byte
+	for _, page := range pages {
+		data = append(data, page.Bytes()...)
+	}
+	path := filepath.Join(t.TempDir(), "test.opus")
+	err := os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, pages
+}
+
+func readPages(t *testing.T, path string) []Page {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var pages []Page
+	for {
+		page, err := ReadPage(file)
+		if err == io.EOF {
+			return pages
+		}
+		if err != nil {
+			t.Fatalf("Page %d: %s", len(pages), err)
+		}
+		pages = append(pages, page)
+	}
+}
+
+func TestWriteComments(t *testing.T) {
+	path, original := writeOpusStream(t, vorbiscomment.Comments{Vendor: "test", Fields: []vorbiscomment.Field{{Key: "TITLE", Value: "Old"}}})
+	audio := original[2:]
+
+	// large enough to need several pages
+	comments := vorbiscomment.Comments{
+		Vendor: "audio-workbench",
+		Fields: []vorbiscomment.Field{
+			{Key: "TITLE", Value: "New"},
+			{Key: "COMMENT", Value: strings.Repeat("x", 100000)},
+			{Key: "EMPTY", Value: ""},
+		},
+	}
+	err := WriteComments(path, comments)
+	if err != nil {
+		t.Fatalf("WriteComments: %s", err)
+	}
+
+	read, err := ReadComments(path)
+	if err != nil {
+		t.Fatalf("ReadComments: %s", err)
+	}
+	if read.Vendor != comments.Vendor || len(read.Fields) != len(comments.Fields) {
+		t.Fatalf("ReadComments returned vendor %q with %d fields", read.Vendor, len(read.Fields))
+	}
+	for i, field := range comments.Fields {
+		if read.Fields[i] != field {
+			t.Errorf("Field %d is %q=%d bytes, want %q=%d bytes", i, read.Fields[i].Key, len(read.Fields[i].Value), field.Key, len(field.Value))
+		}
+	}
+
+	pages := readPages(t, path)
+	headerPages := len(pages) - len(audio)
+	if headerPages <= 2 {
+		t.Fatalf("The comments fill %d pages, want more than one", headerPages-1)
+	}
+	for i, page := range pages[:headerPages] {
+		if page.SerialNumber != 1 || page.SequenceNumber != uint32(i) {
+			t.Errorf("Header page %d has serial %d and sequence %d", i, page.SerialNumber, page.SequenceNumber)
+		}
+	}
+	if pages[headerPages-1].GranulePosition != 0 {
+		t.Errorf("The last header page has granule position %d, want 0", pages[headerPages-1].GranulePosition)
+	}
+	for i, page := range pages[headerPages:] {
+		want := audio[i]
+		if want.SerialNumber == 1 {
+			want.SequenceNumber += uint32(headerPages - 2)
+		}
+		if page.SerialNumber != want.SerialNumber || page.SequenceNumber != want.SequenceNumber ||
+			page.GranulePosition != want.GranulePosition || page.HeaderType != want.HeaderType ||
+			!bytes.Equal(page.Payload, want.Payload) {
+			t.Errorf("Audio page %d is %+v, want %+v", i, page, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package opus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/Chromfalke/audio-workbench/internal/ogg"
+)
+
+// Read the identification header from the first page of the stream.
+func readHeadPage(file *os.File) (ogg.Page, error) {
+	page, err := ogg.ReadPage(file)
+	if err != nil {
+		return ogg.Page{}, err
+	}
+	if page.HeaderType&ogg.BeginOfStream == 0 || len(page.Payload) < 19 || string(page.Payload[:8]) != "OpusHead" {
+		return ogg.Page{}, fmt.Errorf("The first ogg page is not an OpusHead header")
+	}
+	return page, nil
+}
+
+// Read the output gain in dB that decoders apply to the whole stream.
+func ReadOutputGain(path string) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	page, err := readHeadPage(file)
+	if err != nil {
+		return 0, err
+	}
+	gain := int16(binary.LittleEndian.Uint16(page.Payload[16:18]))
+	return float64(gain) / 256, nil
+}
+
+// Replace the output gain in the OpusHead header. Only the first page is rewritten so the audio packets, the tags and
+// the cover stay untouched. The gain is stored as Q7.8 fixed point number and is rounded accordingly.
+func WriteOutputGain(path string, gain float64) error {
+	fixed := math.Round(gain * 256)
+	if fixed > math.MaxInt16 || fixed < math.MinInt16 {
+		return fmt.Errorf("The output gain %.2f dB is out of range", gain)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	page, err := readHeadPage(file)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint16(page.Payload[16:18], uint16(int16(fixed)))
+
+	// the page keeps its size so it can be overwritten in place
+	_, err = file.WriteAt(page.Bytes(), 0)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,88 @@
+package opus
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Chromfalke/audio-workbench/internal/ogg"
+)
+
+// Write a stream with an OpusHead page, a tags page and an audio page.
+func writeStream(t *testing.T, gain int16) (string, []byte) {
+	t.Helper()
+	head := append([]byte("OpusHead"), 1, 2, 0x38, 0x01, 0x80, 0xbb, 0, 0, byte(gain), byte(uint16(gain)>>8), 0)
+	tags := append([]byte("OpusTags"), 4, 0, 0, 0, 't', 'e', 's', 't', 0, 0, 0, 0)
+	pages := []ogg.Page{
+		{HeaderType: ogg.BeginOfStream, SerialNumber: 1, SequenceNumber: 0, Segments: []byte{byte(len(head))}, Payload: head},
+		{SerialNumber: 1, SequenceNumber: 1, Segments: []byte{byte(len(tags))}, Payload: tags},
+		{HeaderType: ogg.EndOfStream, SerialNumber: 1, SequenceNumber: 2, GranulePosition: 960, Segments: []byte{3}, Payload: []byte{1, 2, 3}},
+	}
+	var data []byte
+	for _, page := range pages {
+		data = append(data, page.Bytes()...)
+	}
+	path := filepath.Join(t.TempDir(), "test.opus")
+	err := os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestReadOutputGain(t *testing.T) {
+	for _, test := range []struct {
+		fixed int16
+		gain  float64
+	}{{0, 0}, {256, 1}, {-1536, -6}, {128, 0.5}, {math.MinInt16, -128}} {
+		path, _ := writeStream(t, test.fixed)
+		gain, err := ReadOutputGain(path)
+		if err != nil {
+			t.Fatalf("ReadOutputGain: %s", err)
+		}
+		if gain != test.gain {
+			t.Errorf("ReadOutputGain of %d = %v, want %v", test.fixed, gain, test.gain)
+		}
+	}
+}
+
+func TestWriteOutputGain(t *testing.T) {
+	path, original := writeStream(t, 0)
+	headSize := len(ogg.Page{Segments: []byte{19}, Payload: make([]byte, 19)}.Bytes())
+
+	err := WriteOutputGain(path, -3.2)
+	if err != nil {
+		t.Fatalf("WriteOutputGain: %s", err)
+	}
+	gain, err := ReadOutputGain(path)
+	if err != nil {
+		t.Fatalf("ReadOutputGain: %s", err)
+	}
+	// Q7.8 rounds -3.2 dB to -819/256
+	if want := -819.0 / 256; gain != want {
+		t.Errorf("ReadOutputGain = %v, want %v", gain, want)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != len(original) {
+		t.Fatalf("The file has %d bytes, want %d", len(data), len(original))
+	}
+	// the header page has a valid checksum and everything after it is untouched
+	_, err = ogg.ReadPage(bytes.NewReader(data))
+	if err != nil {
+		t.Errorf("ReadPage of the rewritten header: %s", err)
+	}
+	if !bytes.Equal(data[headSize:], original[headSize:]) {
+		t.Errorf("Pages after the header changed")
+	}
+
+	err = WriteOutputGain(path, 200)
+	if err == nil {
+		t.Errorf("WriteOutputGain of 200 dB succeeded")
+	}
+}
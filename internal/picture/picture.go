@@ -0,0 +1,195 @@
+package picture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Picture types shared by FLAC, Vorbis comments and ID3v2.
+const (
+	Other             = 0
+	FileIcon          = 1
+	OtherFileIcon     = 2
+	FrontCover        = 3
+	BackCover         = 4
+	LeafletPage       = 5
+	Media             = 6
+	LeadArtist        = 7
+	Artist            = 8
+	Conductor         = 9
+	Band              = 10
+	Composer          = 11
+	Lyricist          = 12
+	RecordingLocation = 13
+	DuringRecording   = 14
+	DuringPerformance = 15
+	ScreenCapture     = 16
+	BrightFish        = 17
+	Illustration      = 18
+	BandLogo          = 19
+	PublisherLogo     = 20
+)
+
+var TypeNames = []string{
+	"other",
+	"file-icon",
+	"other-file-icon",
+	"front-cover",
+	"back-cover",
+	"leaflet-page",
+	"media",
+	"lead-artist",
+	"artist",
+	"conductor",
+	"band",
+	"composer",
+	"lyricist",
+	"recording-location",
+	"during-recording",
+	"during-performance",
+	"screen-capture",
+	"bright-fish",
+	"illustration",
+	"band-logo",
+	"publisher-logo",
+}
+
+type Picture struct {
+	Type        uint32
+	MIME        string
+	Description string
+	Width       uint32
+	Height      uint32
+	Depth       uint32 // bits per pixel
+	Colors      uint32 // number of colors for indexed images, 0 otherwise
+	Data        []byte
+}
+
+// Create a picture from raw image data. The MIME type and the dimensions are taken from the data itself.
+func New(pictureType uint32, data []byte) Picture {
+	pic := Picture{
+		Type: pictureType,
+		MIME: http.DetectContentType(data),
+		Data: data,
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil {
+		pic.Width = uint32(config.Width)
+		pic.Height = uint32(config.Height)
+		pic.Depth = 24
+	}
+	return pic
+}
+
+// Name of the picture type as used in file names and on the command line.
+func (pic Picture) TypeName() string {
+	if int(pic.Type) < len(TypeNames) {
+		return TypeNames[pic.Type]
+	}
+	return fmt.Sprintf("type-%d", pic.Type)
+}
+
+// Parse a picture type given by its name or number.
+func ParseType(name string) (uint32, error) {
+	for i, typeName := range TypeNames {
+		if typeName == name || strconv.Itoa(i) == name {
+			return uint32(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid picture type %s", name)
+}
+
+// Check whether a file may only hold one picture of the type, which is the case for the front cover and file icons.
+func Unique(pictureType uint32) bool {
+	return pictureType == FrontCover || pictureType == FileIcon || pictureType == OtherFileIcon
+}
+
+// Parse a picture in the format of the FLAC PICTURE block which is also used by METADATA_BLOCK_PICTURE.
+func Parse(data []byte) (Picture, error) {
+	reader := bytes.NewReader(data)
+	var pic Picture
+
+	readString := func() (string, error) {
+		var length uint32
+		err := binary.Read(reader, binary.BigEndian, &length)
+		if err != nil {
+			return "", err
+		}
+		if int64(length) > int64(reader.Len()) {
+			return "", fmt.Errorf("Picture field exceeds the block")
+		}
+		text := make([]byte, length)
+		_, err = io.ReadFull(reader, text)
+		return string(text), err
+	}
+
+	err := binary.Read(reader, binary.BigEndian, &pic.Type)
+	if err != nil {
+		return Picture{}, fmt.Errorf("Truncated picture block")
+	}
+	pic.MIME, err = readString()
+	if err != nil {
+		return Picture{}, fmt.Errorf("Truncated picture block")
+	}
+	pic.Description, err = readString()
+	if err != nil {
+		return Picture{}, fmt.Errorf("Truncated picture block")
+	}
+	for _, field := range []*uint32{&pic.Width, &pic.Height, &pic.Depth, &pic.Colors} {
+		err = binary.Read(reader, binary.BigEndian, field)
+		if err != nil {
+			return Picture{}, fmt.Errorf("Truncated picture block")
+		}
+	}
+	content, err := readString()
+	if err != nil {
+		return Picture{}, fmt.Errorf("Truncated picture block")
+	}
+	pic.Data = []byte(content)
+
+	return pic, nil
+}
+
+// Serialize the picture in the format of the FLAC PICTURE block.
+func (pic Picture) Bytes() []byte {
+	var buffer bytes.Buffer
+	writeString := func(text []byte) {
+		binary.Write(&buffer, binary.BigEndian, uint32(len(text)))
+		buffer.Write(text)
+	}
+
+	binary.Write(&buffer, binary.BigEndian, pic.Type)
+	writeString([]byte(pic.MIME))
+	writeString([]byte(pic.Description))
+	for _, field := range []uint32{pic.Width, pic.Height, pic.Depth, pic.Colors} {
+		binary.Write(&buffer, binary.BigEndian, field)
+	}
+	writeString(pic.Data)
+
+	return buffer.Bytes()
+}
+
+// File extension matching the encoding of the picture.
+func (pic Picture) Extension() string {
+	switch pic.MIME {
+	case "image/jpeg", "image/jpg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/bmp":
+		return ".bmp"
+	}
+	return ".bin"
+}
@@ -0,0 +1,33 @@
+package picture
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []Picture{
+		{Type: FrontCover, MIME: "image/png", Description: "Cover", Width: 1, Height: 2, Depth: 24, Data: []byte{1, 2, 3}},
+		// empty fields at the end of the block
+		{Type: BackCover, MIME: "image/jpeg"},
+		{Type: Other},
+	}
+	for _, pic := range tests {
+		read, err := Parse(pic.Bytes())
+		if err != nil {
+			t.Errorf("Parse of %+v: %s", pic, err)
+			continue
+		}
+		if read.Type != pic.Type || read.MIME != pic.MIME || read.Description != pic.Description ||
+			read.Width != pic.Width || read.Height != pic.Height || read.Depth != pic.Depth ||
+			read.Colors != pic.Colors || !bytes.Equal(read.Data, pic.Data) {
+			t.Errorf("Parse returned %+v, want %+v", read, pic)
+		}
+	}
+
+	data := tests[0].Bytes()
+	_, err := Parse(data[:len(data)-1])
+	if err == nil {
+		t.Errorf("Parse of a truncated block succeeded")
+	}
+}
@@ -0,0 +1,353 @@
+package picture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// Options for preparing an image before it is embedded. Zero values leave the image as it is.
+type Options struct {
+	MaxSize      int  // largest width and height in pixels, larger images are scaled down
+	Quality      int  // re-encode as baseline JPEG with this quality from 1 to 100
+	MaxBytes     int  // size budget, the quality and then the size are lowered until the image fits
+	StripHeaders bool // remove EXIF, XMP, IPTC and text chunks, re-encoded images never keep them
+}
+
+// Quality used when an image has to be re-encoded as JPEG without a quality given.
+const defaultQuality = 90
+
+// Lowest quality the size budget reduces to before scaling the image down.
+const minimumQuality = 50
+
+// Check the options for values out of range.
+func (options Options) Validate() error {
+	if options.MaxSize < 0 {
+		return fmt.Errorf("Invalid maximum size %d", options.MaxSize)
+	}
+	if options.Quality < 0 || options.Quality > 100 {
+		return fmt.Errorf("Invalid JPEG quality %d, it has to be between 1 and 100", options.Quality)
+	}
+	if options.MaxBytes < 0 {
+		return fmt.Errorf("Invalid size budget %d", options.MaxBytes)
+	}
+	return nil
+}
+
+// Decode the image to check it and apply the options. Returns the image data and its format, jpeg or png.
+func Prepare(data []byte, options Options) ([]byte, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("Not a valid image: %s", err)
+	}
+	if format != "jpeg" && format != "png" {
+		return nil, "", fmt.Errorf("Images in the %s format can't be embedded, only JPEG and PNG are supported", format)
+	}
+
+	// re-encoded images lose the EXIF orientation, so it is applied to the pixels
+	orientation := jpegOrientation(data)
+	if orientation > 1 {
+		img = orient(img, orientation)
+	}
+
+	bounds := img.Bounds()
+	reencode := options.Quality > 0 || (options.StripHeaders && orientation > 1)
+	if options.MaxSize > 0 && max(bounds.Dx(), bounds.Dy()) > options.MaxSize {
+		img = fit(img, options.MaxSize)
+		reencode = true
+	}
+
+	quality := options.Quality
+	if quality == 0 {
+		quality = defaultQuality
+	}
+	if options.Quality > 0 {
+		format = "jpeg"
+	}
+	output := data
+	if reencode {
+		output, err = encode(img, format, quality)
+		if err != nil {
+			return nil, "", err
+		}
+	} else if options.StripHeaders {
+		output, err = stripHeaders(data, format)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	for options.MaxBytes > 0 && len(output) > options.MaxBytes {
+		switch {
+		case format == "png":
+			format = "jpeg"
+		case quality > minimumQuality:
+			quality = max(quality-10, minimumQuality)
+		default:
+			size := max(img.Bounds().Dx(), img.Bounds().Dy()) * 3 / 4
+			if size < 64 {
+				return nil, "", fmt.Errorf("The image doesn't fit into %d bytes", options.MaxBytes)
+			}
+			img = fit(img, size)
+		}
+		output, err = encode(img, format, quality)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return output, format, nil
+}
+
+func encode(img image.Image, format string, quality int) ([]byte, error) {
+	var buffer bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buffer, img)
+	} else {
+		// JPEG has no alpha channel, transparent areas become white instead of black
+		flattened := image.NewRGBA(img.Bounds())
+		draw.Draw(flattened, flattened.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(flattened, flattened.Bounds(), img, img.Bounds().Min, draw.Over)
+		err = jpeg.Encode(&buffer, flattened, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode the image: %s", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// Scale the image down so that neither side exceeds the size, keeping the aspect ratio.
+func fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	scale := float64(size) / float64(max(bounds.Dx(), bounds.Dy()))
+	width := max(int(math.Round(float64(bounds.Dx())*scale)), 1)
+	height := max(int(math.Round(float64(bounds.Dy())*scale)), 1)
+	return resize(img, width, height)
+}
+
+// Resize by averaging the source pixels each target pixel covers, which avoids the aliasing of point sampling when
+// scaling down. Source rows are scaled horizontally one at a time and added to the target rows they cover, so only the
+// target is held in memory. Colors stay premultiplied.
+func resize(img image.Image, width int, height int) *image.RGBA {
+	bounds := img.Bounds()
+	columns := spans(bounds.Dx(), width)
+
+	// target rows each source row contributes to
+	rows := make([][]contribution, bounds.Dy())
+	for y, span := range spans(bounds.Dy(), height) {
+		for _, source := range span {
+			rows[source.index] = append(rows[source.index], contribution{y, source.weight})
+		}
+	}
+
+	sums := make([]float64, width*height*4)
+	row := make([]float64, width*4)
+	for y := 0; y < bounds.Dy(); y++ {
+		clear(row)
+		for x, span := range columns {
+			for _, source := range span {
+				r, g, b, a := img.At(bounds.Min.X+source.index, bounds.Min.Y+y).RGBA()
+				row[x*4] += float64(r) * source.weight
+				row[x*4+1] += float64(g) * source.weight
+				row[x*4+2] += float64(b) * source.weight
+				row[x*4+3] += float64(a) * source.weight
+			}
+		}
+		for _, target := range rows[y] {
+			for i, value := range row {
+				sums[target.index*width*4+i] += value * target.weight
+			}
+		}
+	}
+
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, sum := range sums {
+		result.Pix[i] = uint8(math.Round(min(sum/257, 255)))
+	}
+	return result
+}
+
+type contribution struct {
+	index  int
+	weight float64
+}
+
+// Source pixels covered by each target pixel and the share of each, the shares of a target pixel add up to one.
+func spans(sourceSize int, targetSize int) [][]contribution {
+	scale := float64(sourceSize) / float64(targetSize)
+	result := make([][]contribution, targetSize)
+	for i := range result {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		for index := int(start); index < sourceSize && float64(index) < end; index++ {
+			overlap := math.Min(end, float64(index+1)) - math.Max(start, float64(index))
+			if overlap > 0 {
+				result[i] = append(result[i], contribution{index, overlap / scale})
+			}
+		}
+	}
+	return result
+}
+
+// Remove the metadata segments of a JPEG (EXIF and XMP in APP1, IPTC in APP13 and comments) or the text and EXIF chunks
+// of a PNG without re-encoding the image. The JFIF header in APP0, the ICC profile in APP2 and the Adobe segment in
+// APP14 which tells how CMYK colors are stored are kept.
+func stripHeaders(data []byte, format string) ([]byte, error) {
+	if format == "png" {
+		return stripPNG(data)
+	}
+	return stripJPEG(data)
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, fmt.Errorf("Not a JPEG image")
+	}
+	output := []byte{0xff, 0xd8}
+	position := 2
+	for position+4 <= len(data) {
+		if data[position] != 0xff {
+			return nil, fmt.Errorf("Invalid JPEG segment")
+		}
+		marker := data[position+1]
+		if marker == 0xda {
+			// the compressed image data follows the start of scan
+			return append(output, data[position:]...), nil
+		}
+		end := position + 2 + int(binary.BigEndian.Uint16(data[position+2:]))
+		if end > len(data) {
+			return nil, fmt.Errorf("Truncated JPEG segment")
+		}
+		if marker != 0xe1 && marker != 0xed && marker != 0xfe {
+			output = append(output, data[position:end]...)
+		}
+		position = end
+	}
+	return nil, fmt.Errorf("Truncated JPEG image")
+}
+
+// Return the EXIF orientation of a JPEG from 1 to 8, 1 if the image has none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	position := 2
+	for position+4 <= len(data) && data[position] == 0xff && data[position+1] != 0xda {
+		end := position + 2 + int(binary.BigEndian.Uint16(data[position+2:]))
+		if end > len(data) {
+			return 1
+		}
+		if data[position+1] == 0xe1 && bytes.HasPrefix(data[position+4:end], []byte("Exif\x00\x00")) {
+			return exifOrientation(data[position+10 : end])
+		}
+		position = end
+	}
+	return 1
+}
+
+// Read the orientation tag from the first IFD of the TIFF structure in an EXIF segment.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := range count {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8:]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+		}
+	}
+	return 1
+}
+
+// Rotate and mirror the image as described by an EXIF orientation so it is shown upright without the tag.
+func orient(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if orientation >= 5 {
+		// the orientations from 5 on swap the sides
+		width, height = height, width
+	}
+	// source position of each target pixel
+	source := func(x int, y int) (int, int) {
+		w, h := bounds.Dx(), bounds.Dy()
+		switch orientation {
+		case 2:
+			return w - 1 - x, y
+		case 3:
+			return w - 1 - x, h - 1 - y
+		case 4:
+			return x, h - 1 - y
+		case 5:
+			return y, x
+		case 6:
+			return y, h - 1 - x
+		case 7:
+			return w - 1 - y, h - 1 - x
+		case 8:
+			return w - 1 - y, x
+		}
+		return x, y
+	}
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			sx, sy := source(x, y)
+			result.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return result
+}
+
+// PNG chunks which only carry metadata.
+var pngMetadataChunks = []string{"eXIf", "tEXt", "zTXt", "iTXt", "tIME"}
+
+func stripPNG(data []byte) ([]byte, error) {
+	signature := []byte("\x89PNG\r\n\x1a\n")
+	if !bytes.HasPrefix(data, signature) {
+		return nil, fmt.Errorf("Not a PNG image")
+	}
+	output := append([]byte{}, signature...)
+	position := len(signature)
+	for position+12 <= len(data) {
+		end := position + 12 + int(binary.BigEndian.Uint32(data[position:]))
+		if end > len(data) {
+			return nil, fmt.Errorf("Truncated PNG chunk")
+		}
+		kind := string(data[position+4 : position+8])
+		keep := true
+		for _, metadata := range pngMetadataChunks {
+			if kind == metadata {
+				keep = false
+			}
+		}
+		if keep {
+			output = append(output, data[position:end]...)
+		}
+		position = end
+	}
+	return output, nil
+}
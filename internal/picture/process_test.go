@@ -0,0 +1,343 @@
+package picture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+)
+
+// JPEG segment with a marker and its payload.
+func segment(marker byte, payload string) []byte {
+	header := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+	return append(header, payload...)
+}
+
+// APP1 payload with an EXIF orientation in the given byte order.
+func exif(orientation uint16, order interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}) string {
+	tiff := []byte("MM\x00*")
+	if order == binary.LittleEndian {
+		tiff = []byte("II*\x00")
+	}
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 1)
+	// tag, type SHORT, count and the value padded to four bytes
+	tiff = order.AppendUint16(tiff, 0x0112)
+	tiff = order.AppendUint16(tiff, 3)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = order.AppendUint16(tiff, 0)
+	tiff = order.AppendUint32(tiff, 0)
+	return "Exif\x00\x00" + string(tiff)
+}
+
+// Encode the image as JPEG and insert the segments after the start of image.
+func jpegWith(t *testing.T, img image.Image, segments ...[]byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	output := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		output = append(output, segment...)
+	}
+	return append(output, data[2:]...)
+}
+
+func gradient(width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8((x ^ y) & 0xff), 0xff})
+		}
+	}
+	return img
+}
+
+// Image with pseudo random pixels which compresses badly.
+func noise(width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	state := uint32(1)
+	for i := range img.Pix {
+		state = state*1664525 + 1013904223
+		img.Pix[i] = uint8(state >> 24)
+	}
+	return img
+}
+
+func TestSpans(t *testing.T) {
+	tests := [][2]int{{4, 2}, {3, 2}, {5, 3}, {1000, 7}, {2, 4}, {7, 7}}
+	for _, test := range tests {
+		result := spans(test[0], test[1])
+		if len(result) != test[1] {
+			t.Errorf("spans(%d, %d) has %d target pixels", test[0], test[1], len(result))
+			continue
+		}
+		for i, span := range result {
+			sum := 0.0
+			for _, source := range span {
+				if source.index < 0 || source.index >= test[0] {
+					t.Errorf("spans(%d, %d): pixel %d covers the source pixel %d", test[0], test[1], i, source.index)
+				}
+				sum += source.weight
+			}
+			if math.Abs(sum-1) > 1e-9 {
+				t.Errorf("spans(%d, %d): the weights of pixel %d add up to %f", test[0], test[1], i, sum)
+			}
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	checker := image.NewGray(image.Rect(0, 0, 2, 2))
+	checker.Pix = []uint8{0, 255, 255, 0}
+	if got := resize(checker, 1, 1).Pix; !bytes.Equal(got, []uint8{128, 128, 128, 255}) {
+		t.Errorf("Resizing a checkerboard returned %v, want gray", got)
+	}
+
+	stripes := image.NewGray(image.Rect(0, 0, 4, 1))
+	stripes.Pix = []uint8{0, 0, 255, 255}
+	if got := resize(stripes, 2, 1).Pix; !bytes.Equal(got, []uint8{0, 0, 0, 255, 255, 255, 255, 255}) {
+		t.Errorf("Resizing stripes returned %v, want black and white", got)
+	}
+
+	solid := image.NewUniform(color.RGBA{200, 100, 50, 255})
+	filled := image.NewRGBA(image.Rect(0, 0, 10, 7))
+	for y := range 7 {
+		for x := range 10 {
+			filled.Set(x, y, solid.C)
+		}
+	}
+	result := resize(filled, 3, 2)
+	if result.Bounds() != image.Rect(0, 0, 3, 2) {
+		t.Errorf("Resizing to 3x2 returned %v", result.Bounds())
+	}
+	for y := range 2 {
+		for x := range 3 {
+			if got := result.RGBAAt(x, y); got != solid.C {
+				t.Errorf("Resizing a solid image returned %v at %d,%d, want %v", got, x, y, solid.C)
+			}
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		width, height, size int
+		want                image.Rectangle
+	}{
+		{400, 200, 100, image.Rect(0, 0, 100, 50)},
+		{200, 400, 100, image.Rect(0, 0, 50, 100)},
+		{1, 300, 100, image.Rect(0, 0, 1, 100)},
+		{333, 333, 100, image.Rect(0, 0, 100, 100)},
+	}
+	for _, test := range tests {
+		if got := fit(image.NewGray(image.Rect(0, 0, test.width, test.height)), test.size).Bounds(); got != test.want {
+			t.Errorf("fit(%dx%d, %d) = %v, want %v", test.width, test.height, test.size, got, test.want)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// a b
+	// c d
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+	img.Pix = []uint8{'a', 'b', 'c', 'd'}
+	tests := map[int]string{
+		1: "abcd",
+		2: "badc",
+		3: "dcba",
+		4: "cdab",
+		5: "acbd",
+		6: "cadb",
+		7: "dbca",
+		8: "bdac",
+	}
+	for orientation, want := range tests {
+		result := orient(img, orientation)
+		got := ""
+		for y := range 2 {
+			for x := range 2 {
+				got += string(color.GrayModel.Convert(result.At(x, y)).(color.Gray).Y)
+			}
+		}
+		if got != want {
+			t.Errorf("orient(%d) = %s, want %s", orientation, got, want)
+		}
+	}
+
+	if got := orient(image.NewGray(image.Rect(0, 0, 3, 1)), 6).Bounds(); got != image.Rect(0, 0, 1, 3) {
+		t.Errorf("Rotating a 3x1 image returned %v", got)
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	img := gradient(4, 2)
+	tests := []struct {
+		data []byte
+		want int
+	}{
+		{jpegWith(t, img), 1},
+		{jpegWith(t, img, segment(0xe1, exif(6, binary.BigEndian))), 6},
+		{jpegWith(t, img, segment(0xe0, "JFIF\x00\x01\x01"), segment(0xe1, exif(8, binary.LittleEndian))), 8},
+		{jpegWith(t, img, segment(0xe1, exif(9, binary.BigEndian))), 1},
+		{jpegWith(t, img, segment(0xe1, "http://ns.adobe.com/xap/1.0/\x00")), 1},
+		{[]byte("\x89PNG\r\n\x1a\n"), 1},
+	}
+	for i, test := range tests {
+		if got := jpegOrientation(test.data); got != test.want {
+			t.Errorf("Test %d: jpegOrientation = %d, want %d", i, got, test.want)
+		}
+	}
+}
+
+func TestStripJPEG(t *testing.T) {
+	kept := [][]byte{
+		segment(0xe0, "JFIF\x00\x01\x01"),
+		segment(0xe2, "ICC_PROFILE\x00\x01\x01"),
+		segment(0xee, "Adobe\x00\x64\x00\x00\x00\x00\x02"),
+	}
+	removed := [][]byte{
+		segment(0xe1, exif(1, binary.BigEndian)),
+		segment(0xe1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"),
+		segment(0xed, "Photoshop 3.0\x00"),
+		segment(0xfe, "A comment"),
+	}
+	data := jpegWith(t, gradient(8, 8), kept[0], removed[0], kept[1], removed[1], removed[2], kept[2], removed[3])
+
+	output, err := stripJPEG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, segment := range kept {
+		if !bytes.Contains(output, segment) {
+			t.Errorf("The segment %q was removed", segment)
+		}
+	}
+	for _, segment := range removed {
+		if bytes.Contains(output, segment) {
+			t.Errorf("The segment %q was kept", segment)
+		}
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(output)); err != nil {
+		t.Errorf("The stripped image can't be decoded: %s", err)
+	}
+
+	if _, err := stripJPEG(data[:10]); err == nil {
+		t.Errorf("Stripping a truncated segment succeeded")
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, gradient(8, 8)); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	chunk := func(kind string, payload string) []byte {
+		result := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+		result = append(result, kind+payload...)
+		return binary.BigEndian.AppendUint32(result, crc32.ChecksumIEEE([]byte(kind+payload)))
+	}
+	text := chunk("tEXt", "Comment\x00Some text")
+	gamma := chunk("gAMA", "\x00\x00\xb1\x8f")
+	// the IHDR chunk ends after 8 + 25 bytes
+	var input []byte
+	input = append(input, data[:33]...)
+	input = append(input, text...)
+	input = append(input, gamma...)
+	input = append(input, data[33:]...)
+
+	output, err := stripPNG(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(output, text) || !bytes.Contains(output, gamma) {
+		t.Errorf("Stripping has to remove tEXt and keep gAMA")
+	}
+	if _, err := png.Decode(bytes.NewReader(output)); err != nil {
+		t.Errorf("The stripped image can't be decoded: %s", err)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, noise(300, 150)); err != nil {
+		t.Fatal(err)
+	}
+	pngData := buffer.Bytes()
+
+	output, format, err := Prepare(pngData, Options{MaxSize: 100})
+	if err != nil || format != "png" {
+		t.Fatalf("Prepare returned %s, %v", format, err)
+	}
+	if img, err := png.Decode(bytes.NewReader(output)); err != nil || img.Bounds() != image.Rect(0, 0, 100, 50) {
+		t.Errorf("Scaling down returned %v, %v", img.Bounds(), err)
+	}
+
+	// the budget converts to JPEG, lowers the quality and then the size
+	for _, budget := range []int{20000, 4000, 1500} {
+		output, format, err := Prepare(pngData, Options{MaxBytes: budget})
+		if err != nil || format != "jpeg" || len(output) > budget {
+			t.Errorf("Prepare with a budget of %d returned %d bytes of %s, %v", budget, len(output), format, err)
+		}
+	}
+	if _, _, err := Prepare(pngData, Options{MaxBytes: 100}); err == nil {
+		t.Errorf("Prepare with a budget of 100 bytes succeeded")
+	}
+
+	rotated := jpegWith(t, gradient(4, 2), segment(0xe1, exif(6, binary.BigEndian)), segment(0xfe, "A comment"))
+	output, _, err = Prepare(rotated, Options{StripHeaders: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img, err := jpeg.Decode(bytes.NewReader(output)); err != nil || img.Bounds() != image.Rect(0, 0, 2, 4) {
+		t.Errorf("Stripping a rotated image returned %v, %v, want the rotation applied", img.Bounds(), err)
+	}
+	output, _, err = Prepare(rotated, Options{})
+	if err != nil || !bytes.Equal(output, rotated) {
+		t.Errorf("Prepare without options changed the image, %v", err)
+	}
+
+	upright := jpegWith(t, gradient(4, 2), segment(0xe1, exif(1, binary.BigEndian)), segment(0xfe, "A comment"))
+	stripped, _ := stripJPEG(upright)
+	output, _, err = Prepare(upright, Options{StripHeaders: true})
+	if err != nil || !bytes.Equal(output, stripped) {
+		t.Errorf("Stripping an upright image re-encoded it, %v", err)
+	}
+
+	if _, _, err := Prepare([]byte("not an image"), Options{}); err == nil {
+		t.Errorf("Prepare of invalid data succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		options Options
+		valid   bool
+	}{
+		{Options{}, true},
+		{Options{MaxSize: 1000, Quality: 85, MaxBytes: 500 * 1024, StripHeaders: true}, true},
+		{Options{Quality: 100}, true},
+		{Options{MaxSize: -1}, false},
+		{Options{Quality: 101}, false},
+		{Options{Quality: -5}, false},
+		{Options{MaxBytes: -1}, false},
+	}
+	for _, test := range tests {
+		if err := test.options.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v", test.options, err)
+		}
+	}
+}
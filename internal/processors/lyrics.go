@@ -0,0 +1,111 @@
+package processors
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/commands"
+	"github.com/Chromfalke/audio-workbench/internal/lib"
+	"github.com/Chromfalke/audio-workbench/internal/lrc"
+)
+
+// Extensions of lyrics files next to the audio files, in the order they are looked for.
+var LyricsExtensions = []string{".lrc", ".txt"}
+
+// Processor to embed the lyrics file with the same base name as the audio file
+type LyricsEmbedder struct{}
+
+func (embedder LyricsEmbedder) Run(file lib.Mediafile, outpath string) error {
+	if !file.IsAudio() {
+		return nil
+	}
+	if skipReadOnly(file) {
+		return nil
+	}
+
+	base := strings.TrimSuffix(file.Path, filepath.Ext(file.Path))
+	var sidecar string
+	for _, extension := range LyricsExtensions {
+		_, err := os.Stat(base + extension)
+		if err == nil {
+			sidecar = base + extension
+			break
+		}
+	}
+	if sidecar == "" {
+		fmt.Printf("Skipped %s since there is no lyrics file for it.\n", file.Path)
+		return nil
+	}
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %s", sidecar, err)
+	}
+	// text files are stored as they are, lines such as [Chorus: Artist] aren't LRC tags there
+	text := strings.TrimRight(strings.ReplaceAll(strings.TrimPrefix(string(data), "\ufeff"), "\r\n", "\n"), "\n")
+	kind := "plain"
+	if filepath.Ext(sidecar) == ".lrc" {
+		lyrics := lrc.Parse(text)
+		if len(lyrics.Lines) == 0 {
+			text = ""
+		} else {
+			text = strings.TrimSuffix(lyrics.String(), "\n")
+		}
+		if lyrics.Synced {
+			kind = "synchronised"
+		}
+	}
+	if strings.TrimSpace(text) == "" {
+		fmt.Printf("Skipped %s since %s is empty.\n", file.Path, sidecar)
+		return nil
+	}
+
+	target, err := inplaceTarget(file, outpath)
+	if err != nil {
+		return err
+	}
+	tags, err := commands.ReadMetadata(target)
+	if err != nil {
+		return fmt.Errorf("Failed to read the tags of %s: %s", target.Path, err)
+	}
+	// the tag model stores LRC lyrics as synchronised lyrics where the format has them
+	tags.Set("LYRICS", text)
+	err = writeMetadata(target, tags)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Embedded the %s lyrics of %s into %s.\n", kind, sidecar, target.Path)
+	return nil
+}
+
+// Processor to write the embedded lyrics to an LRC file
+type LyricsExtractor struct{}
+
+func (extractor LyricsExtractor) Run(file lib.Mediafile, outpath string) error {
+	if !file.IsAudio() {
+		return nil
+	}
+
+	tags, err := commands.ReadMetadata(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read the tags of %s: %s", file.Path, err)
+	}
+	text := tags.Get("LYRICS")
+	if text == "" {
+		fmt.Printf("No lyrics are embedded in %s.\n", file.Path)
+		return nil
+	}
+
+	lyricsPath := strings.TrimSuffix(outpath, filepath.Ext(outpath)) + ".lrc"
+	if lib.IsTempPath(file, outpath) {
+		lyricsPath = strings.TrimSuffix(file.Path, filepath.Ext(file.Path)) + ".lrc"
+	}
+	err = os.WriteFile(lyricsPath, []byte(lrc.Parse(text).String()), 0664)
+	if err != nil {
+		return fmt.Errorf("Failed to write %s: %s", lyricsPath, err)
+	}
+	fmt.Printf("Extracted the lyrics to %s.\n", lyricsPath)
+	return nil
+}
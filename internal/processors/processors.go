@@ -2,12 +2,20 @@ package processors
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/Chromfalke/audio-workbench/internal/commands"
+	"github.com/Chromfalke/audio-workbench/internal/formats"
 	"github.com/Chromfalke/audio-workbench/internal/lib"
+	"github.com/Chromfalke/audio-workbench/internal/mp3"
+	"github.com/Chromfalke/audio-workbench/internal/opus"
+	"github.com/Chromfalke/audio-workbench/internal/picture"
+	"github.com/Chromfalke/audio-workbench/internal/sweep"
+	"github.com/Chromfalke/audio-workbench/internal/tagmodel"
 )
 
 type Processor interface {
@@ -16,130 +24,652 @@ type Processor interface {
 
 // Processor to normalize the loudness of an audio file
 type Normalizer struct {
+	Mode           string // loudnorm, peak or rms
 	TargetLoudness float64
+	TargetLevel    float64 // peak or RMS level in dBFS for the peak and rms modes
+	TruePeak       bool
+	Lossless       bool
+
+	SilencePolicy    string  // skip, copy or fail
+	SilenceThreshold float64 // sample peak in dBFS below which a file counts as silent
+	MinDuration      float64 // in seconds
+	MaxGain          float64 // in dB
+}
+
+// Measure the file and calculate the gain needed to reach the target of the selected mode.
+func (normalizer Normalizer) measureGain(file lib.Mediafile) (float64, commands.LoudnessMeasurement, error) {
+	measurement, err := commands.MeasureLoudness(file.Path)
+	if err != nil {
+		return 0, commands.LoudnessMeasurement{}, fmt.Errorf("Failed to measure the loudness of %s: %s", file.Path, err)
+	}
+
+	var gain float64
+	switch normalizer.Mode {
+	case "peak":
+		if normalizer.TruePeak {
+			gain = normalizer.TargetLevel - measurement.TruePeak
+		} else {
+			gain = normalizer.TargetLevel - measurement.SamplePeak
+		}
+	case "rms":
+		rms, err := commands.MeasureRMS(file.Path)
+		if err != nil {
+			return 0, commands.LoudnessMeasurement{}, fmt.Errorf("Failed to measure the RMS level of %s: %s", file.Path, err)
+		}
+		gain = normalizer.TargetLevel - rms
+	default:
+		gain = normalizer.TargetLoudness - measurement.Integrated
+	}
+
+	return gain, measurement, nil
+}
+
+// Check the measured gain and peak before anything is written. Returns false if the file must not be normalized.
+func (normalizer Normalizer) screen(file lib.Mediafile, outpath string, gain float64, peak float64) (bool, error) {
+	if peak <= normalizer.SilenceThreshold || math.IsInf(gain, 0) || math.IsNaN(gain) {
+		return false, normalizer.skip(file, outpath, "it is silent")
+	}
+	if gain > normalizer.MaxGain {
+		return false, fmt.Errorf("Refusing to apply a gain of %+.2f dB to %s since the maximum is %+.2f dB", gain, file.Path, normalizer.MaxGain)
+	}
+	return true, nil
+}
+
+// Handle a file that can't be normalized according to the silence policy.
+func (normalizer Normalizer) skip(file lib.Mediafile, outpath string, reason string) error {
+	switch normalizer.SilencePolicy {
+	case "fail":
+		return fmt.Errorf("Unable to normalize %s since %s", file.Path, reason)
+	case "copy":
+		if !lib.IsTempPath(file, outpath) {
+			err := lib.CopyFile(file.Path, outpath)
+			if err != nil {
+				return fmt.Errorf("Failed to copy %s to %s: %s", file.Path, outpath, err)
+			}
+		}
+		fmt.Printf("Copied %s unchanged since %s.\n", file.Path, reason)
+	default:
+		fmt.Printf("Skipped %s since %s.\n", file.Path, reason)
+	}
+	return nil
 }
 
 func (normalizer Normalizer) Run(file lib.Mediafile, outpath string) error {
-	var hasCover bool
-	var err error
-	if file.IsOpus {
-		hasCover, err = commands.ExtractCover(file, "cover.jpg", "")
+	if !file.IsAudio() && !file.IsVideo {
+		return nil
+	}
+	if skipReadOnly(file) {
+		return nil
+	}
+
+	duration, err := commands.ExtractDuration(file.Path)
+	if err != nil {
+		return fmt.Errorf("Failed to extract the duration of %s: %s", file.Path, err)
+	}
+	// containers without a known duration are measured regardless
+	if !math.IsNaN(duration) && duration < normalizer.MinDuration {
+		return normalizer.skip(file, outpath, fmt.Sprintf("it is shorter than %.2f seconds", normalizer.MinDuration))
+	}
+
+	if normalizer.Lossless {
+		if file.IsOpus() {
+			return normalizer.adjustOpusGain(file, outpath)
+		}
+		if file.Format.Name == "mp3" {
+			return normalizer.adjustMP3Gain(file, outpath)
+		}
+		if !file.Format.Lossless {
+			return fmt.Errorf("Lossless normalization is not available for %s", file.Path)
+		}
+		// lossless formats go through the regular path
+	}
+
+	var gain, peak float64
+	var loudnessInfo commands.LoudnessInfo
+	if normalizer.Mode == "peak" || normalizer.Mode == "rms" {
+		var measurement commands.LoudnessMeasurement
+		gain, measurement, err = normalizer.measureGain(file)
 		if err != nil {
-			return fmt.Errorf("Failed to extract the cover from %s: %s\n", file.Path, err)
+			return err
 		}
+		peak = measurement.SamplePeak
+	} else {
+		loudnessInfo, err = commands.ExtractLoudnessInfo(file.Path)
+		if err != nil {
+			return fmt.Errorf("Failed to extract the loudness from %s: %s", file.Path, err)
+		}
+		gain = normalizer.TargetLoudness - loudnessInfo.I
+		peak = loudnessInfo.SamplePeak
+	}
+	proceed, err := normalizer.screen(file, outpath, gain, peak)
+	if !proceed {
+		return err
+	}
+	// the volume filter doesn't limit, raising the RMS level can push the peaks beyond full scale
+	if normalizer.Mode == "rms" && gain+peak > 0 {
+		return fmt.Errorf("Refusing to apply a gain of %+.2f dB to %s since its sample peak of %.2f dBFS would clip", gain, file.Path, peak)
+	}
+
+	pictures, err := commands.ReadPictures(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read the pictures of %s: %s", file.Path, err)
 	}
 
 	sampleRate, err := commands.ExtractSampleRate(file.Path)
 	if err != nil {
 		return fmt.Errorf("Failed to extract the sample rate from %s: %s\n", file.Path, err)
 	}
+	sampleRate, encoder, err := encoderArgs(file, formats.Format{}, formats.AutoQuality, sampleRate, formats.Snap)
+	if err != nil {
+		return err
+	}
+	metadata, err := readMetadata(file)
+	if err != nil {
+		return err
+	}
+
+	if normalizer.Mode == "peak" || normalizer.Mode == "rms" {
+		err = commands.ApplyGain(file, outpath, gain, sampleRate, encoder)
+		if err != nil {
+			return fmt.Errorf("Failed to apply a gain of %.2f dB to %s: %s\n", gain, file.Path, err)
+		}
+	} else {
+		err = commands.NormalizeLoudness(file, outpath, normalizer.TargetLoudness, loudnessInfo, sampleRate, encoder)
+		if err != nil {
+			return fmt.Errorf("Failed to normalize the loudness of %s: %s\n", file.Path, err)
+		}
+	}
+	result := lib.NewMediafile(resultPath(file, outpath))
+	err = writeMetadata(result, metadata)
+	if err != nil {
+		return err
+	}
+	err = commands.WritePictures(result, pictures)
+	if err != nil {
+		return fmt.Errorf("Failed to carry the pictures of %s over to %s: %s", file.Path, result.Path, err)
+	}
+
+	return nil
+}
+
+// Normalize an opus file by changing the output gain in its header instead of re-encoding it.
+func (normalizer Normalizer) adjustOpusGain(file lib.Mediafile, outpath string) error {
+	// the decoder applies the current output gain so the measurement already includes it
+	change, measurement, err := normalizer.measureGain(file)
+	if err != nil {
+		return err
+	}
+	proceed, err := normalizer.screen(file, outpath, change, measurement.SamplePeak)
+	if !proceed {
+		return err
+	}
+	currentGain, err := opus.ReadOutputGain(file.Path)
+	if err != nil {
+		return fmt.Errorf("Failed to read the output gain of %s: %s", file.Path, err)
+	}
+
+	target, err := inplaceTarget(file, outpath)
+	if err != nil {
+		return err
+	}
+
+	gain := currentGain + change
+	err = opus.WriteOutputGain(target.Path, gain)
+	if err != nil {
+		return fmt.Errorf("Failed to set the output gain of %s: %s", target.Path, err)
+	}
+
+	// R128 gain tags are relative to the output gain and would be off now
+	err = commands.RemoveTags(target, []string{"R128_TRACK_GAIN", "R128_ALBUM_GAIN"})
+	if err != nil {
+		return fmt.Errorf("Failed to remove stale gain tags from %s: %s", target.Path, err)
+	}
+	fmt.Printf("Changed the output gain of %s from %+.2f dB to %+.2f dB.\n", target.Path, currentGain, gain)
+
+	return nil
+}
+
+// Tag recording the global gain steps that undo all changes made by the normalizer. It is our own tag, mp3gain keeps its
+// undo information in an APEv2 tag instead.
+const GainUndoKey = "GLOBAL_GAIN_UNDO"
+
+// Normalize an mp3 file by shifting the global gain of its frames in 1.5 dB steps like mp3gain does. The applied change
+// is recorded in the GainUndoKey tag.
+func (normalizer Normalizer) adjustMP3Gain(file lib.Mediafile, outpath string) error {
+	gain, measurement, err := normalizer.measureGain(file)
+	if err != nil {
+		return err
+	}
+	proceed, err := normalizer.screen(file, outpath, gain, measurement.SamplePeak)
+	if !proceed {
+		return err
+	}
+
+	steps := int(math.Round(gain / mp3.GainStep))
+	for steps > 0 && measurement.TruePeak+float64(steps)*mp3.GainStep > 0 {
+		// keep the true peak below full scale
+		steps--
+	}
+	lowest, highest, err := mp3.GainRange(file.Path)
+	if err != nil {
+		return fmt.Errorf("Failed to read the frames of %s: %s", file.Path, err)
+	}
+	steps = max(min(steps, 255-highest), -lowest)
+
+	target, err := inplaceTarget(file, outpath)
+	if err != nil {
+		return err
+	}
+	if steps == 0 {
+		fmt.Printf("%s is already at the target loudness.\n", file.Path)
+		return nil
+	}
+
+	err = mp3.AdjustGain(target.Path, steps)
+	if err != nil {
+		return fmt.Errorf("Failed to adjust the gain of %s: %s", target.Path, err)
+	}
+
+	tags, err := commands.ReadTags(target)
+	if err != nil {
+		return fmt.Errorf("Failed to read the tags of %s: %s", target.Path, err)
+	}
+	undo := -steps
+	previous, err := strconv.Atoi(tags[GainUndoKey])
+	if err == nil {
+		undo += previous
+	}
+	err = commands.SetTags(target, map[string]string{GainUndoKey: fmt.Sprintf("%+d", undo)})
+	if err != nil {
+		return fmt.Errorf("Failed to write the undo tag to %s: %s", target.Path, err)
+	}
+	// ReplayGain tags are relative to the old gain
+	err = commands.RemoveTags(target, ReplayGainKeys)
+	if err != nil {
+		return fmt.Errorf("Failed to remove stale gain tags from %s: %s", target.Path, err)
+	}
+	fmt.Printf("Changed the gain of %s by %+.1f dB.\n", target.Path, float64(steps)*mp3.GainStep)
+
+	return nil
+}
+
+// Resolve the format of the audio stream in videos and of extensions shared by formats by probing the codec, an .m4a
+// file may hold AAC or ALAC.
+func sourceFormat(file lib.Mediafile) (formats.Format, error) {
+	if file.Format.Name != "aac" && !file.IsVideo {
+		return file.Format, nil
+	}
+	codec, err := commands.ExtractCodec(file.Path)
+	if err != nil {
+		return formats.Format{}, err
+	}
+	format, _ := formats.ForCodec(codec)
+	return format, nil
+}
+
+// Sample rate and encoder arguments for encoding the file to the target format. The settings use the defaults for the
+// source and target pairing with the overrides applied and are checked against the constraints of the target. An empty
+// target re-encodes to the format of the source, for videos it leaves the choice to ffmpeg.
+func encoderArgs(file lib.Mediafile, target formats.Format, overrides formats.Quality, sampleRate int, policy string) (int, []string, error) {
+	source, err := sourceFormat(file)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Failed to extract the codec of %s: %s", file.Path, err)
+	}
+	if target.Name == "" && !file.IsVideo {
+		target = source
+	}
 	bitrate, err := commands.ExtractBitrate(file)
 	if err != nil {
-		return fmt.Errorf("Failed to extract the bitrate from %s: %s", file.Path, err)
+		return 0, nil, fmt.Errorf("Failed to extract the bitrate from %s: %s", file.Path, err)
 	}
-	loudnessInfo, err := commands.ExtractLoudnessInfo(file.Path)
+	sampleFormat, err := commands.ExtractSampleFormat(file.Path)
 	if err != nil {
-		return fmt.Errorf("Failed to extract the loudness from %s: %s", file.Path, err)
+		return 0, nil, fmt.Errorf("Failed to extract the sample format from %s: %s", file.Path, err)
+	}
+	channels, err := commands.ExtractChannels(file.Path)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Failed to extract the channels from %s: %s", file.Path, err)
 	}
 
-	err = commands.NormalizeLoudness(file, outpath, normalizer.TargetLoudness, loudnessInfo, sampleRate, bitrate)
+	quality := formats.QualityFor(source, bitrate, sampleFormat, target).Override(overrides)
+	if target.Lossless && quality.SampleFormat != "" && !target.SupportsSampleFormat(quality.SampleFormat) {
+		return 0, nil, fmt.Errorf("%s can't store %s samples", target.Name, quality.SampleFormat)
+	}
+	if source.Lossless && target.Lossless && formats.Reduces(sampleFormat, quality.SampleFormat) {
+		if quality.Dither == "" || quality.Dither == "none" {
+			fmt.Printf("Truncating the %s samples of %s to %s without dither.\n", sampleFormat, file.Path, quality.SampleFormat)
+		} else {
+			fmt.Printf("Reducing the %s samples of %s to %s with %s dither.\n", sampleFormat, file.Path, quality.SampleFormat, quality.Dither)
+		}
+	}
+
+	params := formats.Parameters{SampleRate: sampleRate, Channels: channels, Bitrate: quality.Bitrate}
+	adjusted, adjustments, err := target.Constrain(params, policy)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Refused to encode %s: %s", file.Path, err)
+	}
+	if len(adjustments) > 0 {
+		fmt.Printf("Adjusted %s for %s: %s.\n", file.Path, target.Name, strings.Join(adjustments, ", "))
+	}
+
+	quality.Bitrate = adjusted.Bitrate
+	args := target.EncoderArgs(quality)
+	if adjusted.Channels != channels {
+		args = append(args, "-ac", strconv.Itoa(adjusted.Channels))
+	}
+	return adjusted.SampleRate, args, nil
+}
+
+var GuardPolicies = []string{"warn", "refuse", "tag"}
+
+// Tag marking files whose format or sample rate suggests a higher quality than their source had.
+const TranscodedTag = "TRANSCODED_FROM"
+
+// Detects lossy to lossless conversions and upsampling, which produce files that look like a higher quality than
+// they have. Depending on the policy they are reported, refused or tagged as transcoded.
+type QualityGuard struct {
+	Policy       string
+	AllowUpscale bool
+}
+
+// Check the encoding of the file to the target format and sample rate. The returned tags have to be written to the
+// output.
+func (guard QualityGuard) check(file lib.Mediafile, target formats.Format, targetSampleRate int) (map[string]string, error) {
+	if guard.AllowUpscale {
+		return nil, nil
+	}
+	source, err := sourceFormat(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to extract the codec of %s: %s", file.Path, err)
+	}
+	sampleRate, err := commands.ExtractSampleRate(file.Path)
 	if err != nil {
-		return fmt.Errorf("Failed to normalize the loudness of %s: %s\n", file.Path, err)
+		return nil, fmt.Errorf("Failed to extract the sample rate from %s: %s", file.Path, err)
 	}
 
-	if file.IsOpus && hasCover {
-		err := commands.SetCover(file, "cover.jpg")
+	var reasons, origin []string
+	if source.Name != "" {
+		origin = append(origin, source.Name)
+	}
+	if source.Name != "" && !source.Lossless && target.Lossless {
+		bitrate, err := commands.ExtractBitrate(file)
 		if err != nil {
-			return fmt.Errorf("Failed to set cover for %s: %s\n", file.Path, err)
+			return nil, fmt.Errorf("Failed to extract the bitrate from %s: %s", file.Path, err)
 		}
-		err = os.Remove("cover.jpg")
-		if err != nil {
-			return fmt.Errorf("Unable to remove temporary cover.jpg file: %s", err)
+		reasons = append(reasons, fmt.Sprintf("the lossy %s source can't become lossless as %s", source.Name, target.Name))
+		if bitrate != 0 {
+			origin = append(origin, fmt.Sprintf("%d kbit/s", bitrate/1000))
 		}
 	}
+	if targetSampleRate > sampleRate {
+		reasons = append(reasons, fmt.Sprintf("upsampling from %d Hz to %d Hz adds no content", sampleRate, targetSampleRate))
+	}
+	if len(reasons) == 0 {
+		return nil, nil
+	}
+	origin = append(origin, fmt.Sprintf("%d Hz", sampleRate))
+
+	switch guard.Policy {
+	case "refuse":
+		return nil, fmt.Errorf("Refused to encode %s since %s, use --allow-upscale to force it", file.Path, strings.Join(reasons, " and "))
+	case "tag":
+		fmt.Printf("Tagging the output of %s as transcoded since %s.\n", file.Path, strings.Join(reasons, " and "))
+		return map[string]string{TranscodedTag: strings.Join(origin, ", ")}, nil
+	}
+	fmt.Printf("Warning: %s since %s.\n", file.Path, strings.Join(reasons, " and "))
+	return nil, nil
+}
+
+// Path of the file an operation wrote, inplace operations are renamed onto the original file.
+func resultPath(file lib.Mediafile, outpath string) string {
+	if filepath.Base(outpath) == "temp"+filepath.Ext(outpath) {
+		return file.Path
+	}
+	return outpath
+}
 
+// Write the tags of the quality guard to the output of an operation.
+func tagOutput(file lib.Mediafile, outpath string, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := resultPath(file, outpath)
+	err := commands.SetTags(lib.NewMediafile(result), tags)
+	if err != nil {
+		return fmt.Errorf("Failed to tag %s as transcoded: %s", result, err)
+	}
+	return nil
+}
+
+// Report and skip files which can only be read since the result couldn't be written in their format.
+func skipReadOnly(file lib.Mediafile) bool {
+	if !file.IsReadOnly() {
+		return false
+	}
+	fmt.Printf("Skipped %s since %s files can only be read, convert it to another format instead.\n", file.Path, file.Format.Name)
+	return true
+}
+
+// Read the tags of a file into the common model before an operation replaces it. Videos keep the tags ffmpeg maps.
+func readMetadata(file lib.Mediafile) (tagmodel.Tags, error) {
+	if !file.IsAudio() {
+		return nil, nil
+	}
+	tags, err := commands.ReadMetadata(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the tags of %s: %s", file.Path, err)
+	}
+	return tags, nil
+}
+
+// Write the tags read before an operation to its output and report the fields its tag system can't represent.
+func writeMetadata(result lib.Mediafile, tags tagmodel.Tags) error {
+	if tags == nil {
+		return nil
+	}
+	lost, err := commands.WriteMetadata(result, tags)
+	if err != nil {
+		return fmt.Errorf("Failed to write the tags of %s: %s", result.Path, err)
+	}
+	if len(lost) > 0 {
+		fmt.Printf("Could not represent %s in %s.\n", strings.Join(lost, ", "), result.Path)
+	}
 	return nil
 }
 
 // Processor to convert the audio file to a different format
 type Converter struct {
-	Format string
+	Format  formats.Format
+	Quality formats.Quality // overrides for the encoder defaults
+	Policy  string          // how parameters the format can't store are handled
+	Guard   QualityGuard
 }
 
 func (converter Converter) Run(file lib.Mediafile, outpath string) error {
-	var hasCover bool
-	var err error
-	if file.IsOpus {
-		hasCover, err = commands.ExtractCover(file, "cover.jpg", "")
-		if err != nil {
-			return fmt.Errorf("Failed to extract the cover from %s: %s\n", file.Path, err)
-		}
+	if !file.IsAudio() && !file.IsVideo {
+		return nil
 	}
 
-	ext := filepath.Ext(outpath)
-	outpath = strings.TrimRight(outpath, ext) + "." + converter.Format
+	pictures, err := commands.ReadPictures(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read the pictures of %s: %s", file.Path, err)
+	}
+	metadata, err := readMetadata(file)
+	if err != nil {
+		return err
+	}
+
+	// converting inplace to another extension writes next to the original and removes it afterwards
+	replace := lib.IsTempPath(file, outpath) && filepath.Ext(file.Path) != converter.Format.Extension()
+	if replace {
+		outpath = strings.TrimSuffix(file.Path, filepath.Ext(file.Path)) + converter.Format.Extension()
+	} else {
+		outpath = strings.TrimSuffix(outpath, filepath.Ext(outpath)) + converter.Format.Extension()
+	}
 
 	sampleRate, err := commands.ExtractSampleRate(file.Path)
 	if err != nil {
 		return fmt.Errorf("Failed to extract the sample rate from %s: %s\n", file.Path, err)
 	}
-	bitrate, err := commands.ExtractBitrate(file)
+	tags, err := converter.Guard.check(file, converter.Format, sampleRate)
+	if err != nil {
+		return err
+	}
+	sampleRate, encoder, err := encoderArgs(file, converter.Format, converter.Quality, sampleRate, converter.Policy)
 	if err != nil {
-		return fmt.Errorf("Failed to extract the bitrate from %s: %s", file.Path, err)
+		return err
 	}
-	err = commands.Convert(file, outpath, sampleRate, bitrate)
+	err = commands.Convert(file, outpath, sampleRate, encoder)
 	if err != nil {
-		return fmt.Errorf("Failed to convert %s to %s: %s", file.Path, converter.Format, err)
+		return fmt.Errorf("Failed to convert %s to %s: %s", file.Path, converter.Format.Name, err)
 	}
 
-	if file.IsOpus && hasCover {
-		err := commands.SetCover(file, "cover.jpg")
-		if err != nil {
-			return fmt.Errorf("Failed to set cover for %s: %s\n", file.Path, err)
-		}
-		err = os.Remove("cover.jpg")
+	result := lib.NewMediafile(resultPath(file, outpath))
+	result.Format = converter.Format
+	err = writeMetadata(result, metadata)
+	if err != nil {
+		return err
+	}
+	err = commands.WritePictures(result, pictures)
+	if err != nil {
+		return fmt.Errorf("Failed to carry the pictures of %s over to %s: %s", file.Path, result.Path, err)
+	}
+	err = tagOutput(file, outpath, tags)
+	if err != nil {
+		return err
+	}
+
+	if replace {
+		err = os.Remove(file.Path)
 		if err != nil {
-			return fmt.Errorf("Unable to remove temporary cover.jpg file: %s", err)
+			return fmt.Errorf("Failed to remove %s after converting it: %s", file.Path, err)
 		}
 	}
 
 	return nil
 }
 
+// Resample a generated sweep with the options and report the passband ripple and the aliasing.
+func VerifyResampler(sampleRate int, targetSampleRate int, options commands.ResamplerOptions) error {
+	generated := sweep.New(sampleRate, 8)
+	resampled, err := commands.ResampleSamples(generated.Samples(), sampleRate, targetSampleRate, options)
+	if err != nil {
+		return fmt.Errorf("Failed to resample the sweep: %s", err)
+	}
+
+	report := generated.Analyze(resampled, targetSampleRate)
+	fmt.Printf("Resampling a sweep from %d Hz to %d Hz:\n", sampleRate, targetSampleRate)
+	fmt.Printf("  Passband ripple from %.0f Hz to %.0f Hz: %.3f dB (%+.3f dB to %+.3f dB)\n", report.PassbandStart, report.PassbandEdge, report.Ripple(), report.MinGain, report.MaxGain)
+	if report.StopbandEdge == 0 {
+		fmt.Println("  Aliasing: none possible when upsampling")
+	} else {
+		fmt.Printf("  Aliasing of input above %.0f Hz: %.1f dB\n", report.StopbandEdge, report.Aliasing)
+	}
+	return nil
+}
+
+// Processor to change the bit depth of lossless audio files
+type BitDepthConverter struct {
+	SampleFormat string
+	Dither       string
+}
+
+func (converter BitDepthConverter) Run(file lib.Mediafile, outpath string) error {
+	if !file.IsAudio() {
+		return nil
+	}
+	// .m4a files may hold ALAC
+	source, err := sourceFormat(file)
+	if err != nil {
+		return fmt.Errorf("Failed to extract the codec of %s: %s", file.Path, err)
+	}
+	if !source.Lossless {
+		fmt.Printf("Skipped %s since lossy formats have no bit depth.\n", file.Path)
+		return nil
+	}
+	if source.Encoder == "" {
+		fmt.Printf("Skipped %s since %s files can only be read, convert it to another lossless format instead.\n", file.Path, source.Name)
+		return nil
+	}
+
+	sampleRate, err := commands.ExtractSampleRate(file.Path)
+	if err != nil {
+		return fmt.Errorf("Failed to extract the sample rate from %s: %s\n", file.Path, err)
+	}
+	metadata, err := readMetadata(file)
+	if err != nil {
+		return err
+	}
+	quality := formats.AutoQuality
+	quality.SampleFormat = converter.SampleFormat
+	quality.Dither = converter.Dither
+	sampleRate, encoder, err := encoderArgs(file, formats.Format{}, quality, sampleRate, formats.Snap)
+	if err != nil {
+		return err
+	}
+	err = commands.Convert(file, outpath, sampleRate, encoder)
+	if err != nil {
+		return fmt.Errorf("Failed to change the bit depth of %s: %s", file.Path, err)
+	}
+	err = writeMetadata(lib.NewMediafile(resultPath(file, outpath)), metadata)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Processor to resample an audio file
 type Resampler struct {
 	SampleRate int
+	Options    commands.ResamplerOptions
+	Quality    formats.Quality // overrides for the encoder defaults
+	Policy     string          // how parameters the format can't store are handled
+	Guard      QualityGuard
 }
 
 func (resampler Resampler) Run(file lib.Mediafile, outpath string) error {
-	var hasCover bool
-	var err error
-	if file.IsOpus {
-		hasCover, err = commands.ExtractCover(file, "cover.jpg", "")
-		if err != nil {
-			return fmt.Errorf("Failed to extract the cover from %s: %s\n", file.Path, err)
-		}
+	if !file.IsAudio() && !file.IsVideo {
+		return nil
+	}
+	if skipReadOnly(file) {
+		return nil
 	}
 
-	bitrate, err := commands.ExtractBitrate(file)
+	pictures, err := commands.ReadPictures(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read the pictures of %s: %s", file.Path, err)
+	}
+
+	metadata, err := readMetadata(file)
+	if err != nil {
+		return err
+	}
+	tags, err := resampler.Guard.check(file, formats.Format{}, resampler.SampleRate)
+	if err != nil {
+		return err
+	}
+	sampleRate, encoder, err := encoderArgs(file, formats.Format{}, resampler.Quality, resampler.SampleRate, resampler.Policy)
 	if err != nil {
-		return fmt.Errorf("Failed to extract the bitrate from %s: %s", file.Path, err)
+		return err
 	}
-	err = commands.Resample(file, outpath, resampler.SampleRate, bitrate)
+	err = commands.Resample(file, outpath, sampleRate, resampler.Options, encoder)
 	if err != nil {
 		fmt.Println(err)
 		return fmt.Errorf("Failed to resample the %s: %s", file.Path, err)
 	}
-
-	if file.IsOpus && hasCover {
-		err := commands.SetCover(file, "cover.jpg")
-		if err != nil {
-			return fmt.Errorf("Failed to set cover for %s: %s\n", file.Path, err)
-		}
-		err = os.Remove("cover.jpg")
-		if err != nil {
-			return fmt.Errorf("Unable to remove temporary cover.jpg file: %s", err)
-		}
+	result := lib.NewMediafile(resultPath(file, outpath))
+	err = writeMetadata(result, metadata)
+	if err != nil {
+		return err
+	}
+	err = commands.WritePictures(result, pictures)
+	if err != nil {
+		return fmt.Errorf("Failed to carry the pictures of %s over to %s: %s", file.Path, result.Path, err)
+	}
+	err = tagOutput(file, outpath, tags)
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -148,11 +678,12 @@ func (resampler Resampler) Run(file lib.Mediafile, outpath string) error {
 // Processor to extract the cover image
 type CoverImageExtractor struct {
 	ImageFormat string
+	All         bool // extract every picture in its original encoding, named after its type
 }
 
 func (extractor CoverImageExtractor) Run(file lib.Mediafile, outpath string) error {
-	if filepath.Ext(file.Path) == ".wav" {
-		// skip .wav files since they don't have a cover
+	if !file.IsVideo && !file.Format.Covers {
+		// skip formats which can't carry a cover
 		return nil
 	}
 
@@ -162,6 +693,9 @@ func (extractor CoverImageExtractor) Run(file lib.Mediafile, outpath string) err
 	} else {
 		imagePath = strings.ReplaceAll(outpath, filepath.Ext(outpath), extractor.ImageFormat)
 	}
+	if extractor.All && !file.IsVideo {
+		return extractor.extractAll(file, strings.TrimSuffix(imagePath, extractor.ImageFormat))
+	}
 
 	hasCover, err := commands.ExtractCover(file, imagePath, "")
 	if err != nil {
@@ -176,18 +710,60 @@ func (extractor CoverImageExtractor) Run(file lib.Mediafile, outpath string) err
 	return nil
 }
 
+// Write every picture to <base>.<type>.<ext>, further pictures of the same type get a number.
+func (extractor CoverImageExtractor) extractAll(file lib.Mediafile, base string) error {
+	pictures, err := commands.ReadPictures(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read the pictures of %s: %s", file.Path, err)
+	}
+	if len(pictures) == 0 && !file.Format.NativeTags() {
+		// pictures of these formats are only available through ffmpeg, which only extracts the cover
+		imagePath := base + "." + picture.TypeNames[picture.FrontCover] + extractor.ImageFormat
+		hasCover, err := commands.ExtractCover(file, imagePath, "")
+		if err != nil {
+			return fmt.Errorf("Failed to extract the cover from %s: %s", file.Path, err)
+		}
+		if hasCover {
+			fmt.Printf("Extracted the cover to %s, other pictures of %s can't be read.\n", imagePath, file.Path)
+			return nil
+		}
+	}
+	if len(pictures) == 0 {
+		fmt.Println("No cover could be extracted from ", file.Path)
+		return nil
+	}
+
+	count := make(map[string]int)
+	for _, pic := range pictures {
+		name := pic.TypeName()
+		count[name]++
+		if count[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, count[name])
+		}
+		imagePath := base + "." + name + pic.Extension()
+		err = os.WriteFile(imagePath, pic.Data, 0664)
+		if err != nil {
+			return fmt.Errorf("Failed to write %s: %s", imagePath, err)
+		}
+		fmt.Printf("Extracted the %s to %s.\n", pic.TypeName(), imagePath)
+	}
+
+	return nil
+}
+
 // Processor to set the cover image
 type CoverImageSetter struct {
-	CoverImage string
+	CoverImage  string
+	PictureType uint32 // see the picture package
 }
 
 func (setter CoverImageSetter) Run(file lib.Mediafile, outpath string) error {
-	if filepath.Ext(file.Path) == ".wav" {
-		// skip .wav files since they don't have a cover
+	if !file.IsVideo && !file.Format.Covers {
+		// skip formats which can't carry a cover
 		return nil
 	}
 
-	err := commands.SetCover(file, setter.CoverImage)
+	err := commands.SetPicture(file, setter.CoverImage, setter.PictureType)
 	if err != nil {
 		return fmt.Errorf("Failed to set %s as cover for %s: %s", setter.CoverImage, file.Path, err)
 	}
@@ -197,7 +773,10 @@ func (setter CoverImageSetter) Run(file lib.Mediafile, outpath string) error {
 
 // Processor to extract the audio from a video
 type AudioExtractor struct {
-	AudioFormat    string
+	Format         formats.Format
+	Quality        formats.Quality // overrides for the encoder defaults
+	Policy         string          // how parameters the format can't store are handled
+	Guard          QualityGuard
 	CopyCover      bool
 	VideoTimestamp string
 }
@@ -209,15 +788,31 @@ func (extractor AudioExtractor) Run(file lib.Mediafile, outpath string) error {
 
 	var audioPath string
 	if outpath == fmt.Sprintf("temp%s", filepath.Ext(file.Path)) {
-		audioPath = strings.ReplaceAll(file.Path, filepath.Ext(file.Path), extractor.AudioFormat)
+		audioPath = strings.ReplaceAll(file.Path, filepath.Ext(file.Path), extractor.Format.Extension())
 	} else {
-		audioPath = strings.ReplaceAll(outpath, filepath.Ext(outpath), extractor.AudioFormat)
+		audioPath = strings.ReplaceAll(outpath, filepath.Ext(outpath), extractor.Format.Extension())
 	}
 
-	err := commands.ExtractAudio(file, audioPath)
+	sampleRate, err := commands.ExtractSampleRate(file.Path)
+	if err != nil {
+		return fmt.Errorf("Failed to extract the sample rate from %s: %s", file.Path, err)
+	}
+	tags, err := extractor.Guard.check(file, extractor.Format, sampleRate)
+	if err != nil {
+		return err
+	}
+	sampleRate, encoder, err := encoderArgs(file, extractor.Format, extractor.Quality, sampleRate, extractor.Policy)
+	if err != nil {
+		return err
+	}
+	err = commands.ExtractAudio(file, audioPath, sampleRate, encoder)
 	if err != nil {
 		return fmt.Errorf("Failed to extract the audio from %s: %s", file.Path, err)
 	}
+	err = tagOutput(file, audioPath, tags)
+	if err != nil {
+		return err
+	}
 
 	if extractor.CopyCover {
 		hasCover, err := commands.ExtractCover(file, "cover.jpg", extractor.VideoTimestamp)
@@ -226,12 +821,7 @@ func (extractor AudioExtractor) Run(file lib.Mediafile, outpath string) error {
 		}
 
 		if hasCover {
-			audioFile := lib.Mediafile{
-				Path:    audioPath,
-				IsOpus:  extractor.AudioFormat == ".opus",
-				IsVideo: false,
-			}
-			err := commands.SetCover(audioFile, "cover.jpg")
+			err := commands.SetCover(lib.NewMediafile(audioPath), "cover.jpg")
 			if err != nil {
 				return fmt.Errorf("Failed to set cover for %s: %s\n", file.Path, err)
 			}
@@ -246,3 +836,110 @@ func (extractor AudioExtractor) Run(file lib.Mediafile, outpath string) error {
 
 	return nil
 }
+
+// Return the file that inplace operations should work on. Unless the file is changed in place it is copied to the
+// output path first.
+func inplaceTarget(file lib.Mediafile, outpath string) (lib.Mediafile, error) {
+	if lib.IsTempPath(file, outpath) {
+		return file, nil
+	}
+
+	err := lib.CopyFile(file.Path, outpath)
+	if err != nil {
+		return lib.Mediafile{}, fmt.Errorf("Failed to copy %s to %s: %s", file.Path, outpath, err)
+	}
+	target := file
+	target.Path = outpath
+	return target, nil
+}
+
+// Processor to write ReplayGain tags without touching the audio stream
+type ReplayGainTagger struct {
+	Album            *commands.LoudnessMeasurement
+	Remove           bool
+	SilenceThreshold float64 // sample peak in dBFS below which a file counts as silent
+}
+
+// Loudness references of ReplayGain 2.0 and of the R128 gain tags used for opus.
+const (
+	replayGainReference = -18.0
+	r128Reference       = -23.0
+)
+
+var ReplayGainKeys = []string{
+	"REPLAYGAIN_TRACK_GAIN",
+	"REPLAYGAIN_TRACK_PEAK",
+	"REPLAYGAIN_ALBUM_GAIN",
+	"REPLAYGAIN_ALBUM_PEAK",
+	"REPLAYGAIN_REFERENCE_LOUDNESS",
+	"R128_TRACK_GAIN",
+	"R128_ALBUM_GAIN",
+}
+
+// Check whether ReplayGain tags can be written to the file.
+func SupportsReplayGain(file lib.Mediafile) bool {
+	return file.Format.NativeTags()
+}
+
+func (tagger ReplayGainTagger) Run(file lib.Mediafile, outpath string) error {
+	if !SupportsReplayGain(file) {
+		return nil
+	}
+
+	target, err := inplaceTarget(file, outpath)
+	if err != nil {
+		return err
+	}
+
+	if tagger.Remove {
+		err = commands.RemoveTags(target, ReplayGainKeys)
+		if err != nil {
+			return fmt.Errorf("Failed to remove the gain tags from %s: %s", target.Path, err)
+		}
+		return nil
+	}
+
+	track, err := commands.MeasureLoudness(file.Path)
+	if err != nil {
+		return fmt.Errorf("Failed to measure the loudness of %s: %s", file.Path, err)
+	}
+	if tagger.Silent(track) {
+		return fmt.Errorf("Unable to calculate a gain for %s since it is silent", file.Path)
+	}
+
+	tags := make(map[string]string)
+	if file.IsOpus() {
+		tags["R128_TRACK_GAIN"] = r128Gain(track)
+		if tagger.Album != nil {
+			tags["R128_ALBUM_GAIN"] = r128Gain(*tagger.Album)
+		}
+	} else {
+		tags["REPLAYGAIN_TRACK_GAIN"] = fmt.Sprintf("%+.2f dB", replayGainReference-track.Integrated)
+		tags["REPLAYGAIN_TRACK_PEAK"] = fmt.Sprintf("%.6f", math.Pow(10, track.SamplePeak/20))
+		if tagger.Album != nil {
+			tags["REPLAYGAIN_ALBUM_GAIN"] = fmt.Sprintf("%+.2f dB", replayGainReference-tagger.Album.Integrated)
+			tags["REPLAYGAIN_ALBUM_PEAK"] = fmt.Sprintf("%.6f", math.Pow(10, tagger.Album.SamplePeak/20))
+		}
+	}
+
+	err = commands.SetTags(target, tags)
+	if err != nil {
+		return fmt.Errorf("Failed to write the gain tags to %s: %s", target.Path, err)
+	}
+	fmt.Printf("Track gain for %s: %+.2f dB\n", file.Path, replayGainReference-track.Integrated)
+
+	return nil
+}
+
+// Check whether a measurement is silent. ebur128 reports digital silence with an integrated loudness of -70 LUFS, only
+// the peak is -inf.
+func (tagger ReplayGainTagger) Silent(measurement commands.LoudnessMeasurement) bool {
+	return math.IsInf(measurement.SamplePeak, -1) || measurement.SamplePeak <= tagger.SilenceThreshold
+}
+
+// The R128 gain tags store the gain relative to -23 LUFS as a Q7.8 fixed point number.
+func r128Gain(measurement commands.LoudnessMeasurement) string {
+	gain := math.Round((r128Reference - measurement.Integrated) * 256)
+	gain = max(min(gain, math.MaxInt16), math.MinInt16)
+	return fmt.Sprintf("%d", int(gain))
+}
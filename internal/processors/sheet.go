@@ -0,0 +1,248 @@
+package processors
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/commands"
+	"github.com/Chromfalke/audio-workbench/internal/lib"
+	"github.com/Chromfalke/audio-workbench/internal/tagmodel"
+)
+
+var SheetFormats = []string{".csv", ".json"}
+
+// Further values of a key get their own CSV columns named KEY#2, KEY#3 and so on.
+const sheetColumnSeparator = "#"
+
+// Tags of a file in a sheet. Paths are relative to the directory of the sheet.
+type SheetRow struct {
+	Path string        `json:"path"`
+	Tags tagmodel.Tags `json:"tags"`
+}
+
+// Write the tags of the files to a CSV or JSON sheet with one row per file.
+func ExportTags(files []lib.Mediafile, sheet string) error {
+	var rows []SheetRow
+	for _, file := range files {
+		if !file.IsAudio() {
+			continue
+		}
+		tags, err := commands.ReadMetadata(file)
+		if err != nil {
+			return fmt.Errorf("Failed to read the tags of %s: %s", file.Path, err)
+		}
+		path, err := sheetPath(file.Path, sheet)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, SheetRow{Path: path, Tags: tags})
+	}
+
+	output, err := os.Create(sheet)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s: %s", sheet, err)
+	}
+	defer output.Close()
+
+	if filepath.Ext(sheet) == ".json" {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(rows)
+	} else {
+		err = writeCSV(output, rows)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write %s: %s", sheet, err)
+	}
+	fmt.Printf("Exported the tags of %d files to %s.\n", len(rows), sheet)
+	return output.Close()
+}
+
+func sheetPath(path string, sheet string) (string, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	directory, err := filepath.Abs(filepath.Dir(sheet))
+	if err != nil {
+		return "", err
+	}
+	relative, err := filepath.Rel(directory, absolute)
+	if err != nil {
+		return absolute, nil
+	}
+	return filepath.ToSlash(relative), nil
+}
+
+// Write a header with a column per key and value followed by the rows. Values are written as they are so any text
+// survives a round trip.
+func writeCSV(output io.Writer, rows []SheetRow) error {
+	all := tagmodel.Tags{}
+	columns := make(map[string]int)
+	for _, row := range rows {
+		for key, values := range row.Tags {
+			all[key] = nil
+			columns[key] = max(columns[key], len(values))
+		}
+	}
+	keys := all.Keys()
+
+	header := []string{"path"}
+	for _, key := range keys {
+		header = append(header, key)
+		for i := 2; i <= columns[key]; i++ {
+			header = append(header, fmt.Sprintf("%s%s%d", key, sheetColumnSeparator, i))
+		}
+	}
+	writer := csv.NewWriter(output)
+	err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{row.Path}
+		for _, key := range keys {
+			values := make([]string, columns[key])
+			copy(values, row.Tags[key])
+			record = append(record, values...)
+		}
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func readSheet(sheet string) ([]SheetRow, error) {
+	input, err := os.Open(sheet)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	var rows []SheetRow
+	if filepath.Ext(sheet) == ".json" {
+		err = json.NewDecoder(input).Decode(&rows)
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			tags := tagmodel.Tags{}
+			for key, values := range row.Tags {
+				tags.Set(key, values...)
+			}
+			rows[i].Tags = tags
+		}
+		return rows, nil
+	}
+
+	records, err := csv.NewReader(input).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || !strings.EqualFold(records[0][0], "path") {
+		return nil, fmt.Errorf("The first column has to be the path")
+	}
+	for _, record := range records[1:] {
+		values := tagmodel.Tags{}
+		for i, column := range records[0][1:] {
+			key, _, _ := strings.Cut(column, sheetColumnSeparator)
+			values[tagmodel.Canonical(key)] = append(values[tagmodel.Canonical(key)], record[i+1])
+		}
+		row := SheetRow{Path: record[0], Tags: tagmodel.Tags{}}
+		for key, cells := range values {
+			row.Tags.Set(key, cells...)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Apply a sheet to the files of its rows. Each row replaces all tags of its file. The changes are printed first and
+// only written once confirm agrees.
+func ImportTags(sheet string, confirm func() bool) error {
+	rows, err := readSheet(sheet)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %s", sheet, err)
+	}
+
+	var files []lib.Mediafile
+	var changes []tagmodel.Tags
+	for _, row := range rows {
+		path := filepath.FromSlash(row.Path)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(sheet), path)
+		}
+		file := lib.NewMediafile(path)
+		if !file.IsAudio() {
+			fmt.Printf("Skipped %s since it is not an audio file.\n", path)
+			continue
+		}
+		if skipReadOnly(file) {
+			continue
+		}
+		current, err := commands.ReadMetadata(file)
+		if err != nil {
+			return fmt.Errorf("Failed to read the tags of %s: %s", path, err)
+		}
+		diff := diffTags(current, row.Tags)
+		if len(diff) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", path)
+		for _, line := range diff {
+			fmt.Printf("  %s\n", line)
+		}
+		files = append(files, file)
+		changes = append(changes, row.Tags)
+	}
+
+	if len(files) == 0 {
+		fmt.Println("The tags already match the sheet.")
+		return nil
+	}
+	if !confirm() {
+		return nil
+	}
+	for i, file := range files {
+		err = writeMetadata(file, changes[i])
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Updated the tags of %d files.\n", len(files))
+	return nil
+}
+
+// Describe the differences between two tag sets as removed (-) and added (+) values.
+func diffTags(before tagmodel.Tags, after tagmodel.Tags) []string {
+	all := tagmodel.Tags{}
+	for key := range before {
+		all[key] = nil
+	}
+	for key := range after {
+		all[key] = nil
+	}
+
+	var lines []string
+	for _, key := range all.Keys() {
+		if slices.Equal(before[key], after[key]) {
+			continue
+		}
+		for _, value := range before[key] {
+			lines = append(lines, fmt.Sprintf("- %s=%s", key, value))
+		}
+		for _, value := range after[key] {
+			lines = append(lines, fmt.Sprintf("+ %s=%s", key, value))
+		}
+	}
+	return lines
+}
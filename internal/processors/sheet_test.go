@@ -0,0 +1,75 @@
+package processors
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Chromfalke/audio-workbench/internal/tagmodel"
+)
+
+func TestSheetRoundTrip(t *testing.T) {
+	rows := []SheetRow{
+		{Path: "a.flac", Tags: tagmodel.Tags{"TITLE": {"Act I; Scene 2"}, "ARTIST": {"One", "Two", "Three"}, "COMMENT": {"Line one\nline two, with \"quotes\""}}},
+		{Path: "dir/b.mp3", Tags: tagmodel.Tags{"TITLE": {"Other"}, "GENRE": {"Jazz"}}},
+		{Path: "c.opus", Tags: tagmodel.Tags{}},
+	}
+	var output bytes.Buffer
+	err := writeCSV(&output, rows)
+	if err != nil {
+		t.Fatalf("writeCSV: %s", err)
+	}
+	header, _, _ := strings.Cut(output.String(), "\n")
+	if header != "path,TITLE,ARTIST,ARTIST#2,ARTIST#3,GENRE,COMMENT" {
+		t.Errorf("Header is %q", header)
+	}
+
+	sheet := filepath.Join(t.TempDir(), "sheet.csv")
+	err = os.WriteFile(sheet, output.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := readSheet(sheet)
+	if err != nil {
+		t.Fatalf("readSheet: %s", err)
+	}
+	if !reflect.DeepEqual(read, rows) {
+		t.Errorf("readSheet = %q, want %q", read, rows)
+	}
+	for i := range rows {
+		if diff := diffTags(rows[i].Tags, read[i].Tags); len(diff) > 0 {
+			t.Errorf("%s changed after the round trip: %q", rows[i].Path, diff)
+		}
+	}
+}
+
+func TestReadSheetColumns(t *testing.T) {
+	sheet := filepath.Join(t.TempDir(), "sheet.csv")
+	// hand written sheets may use any case and leave out columns
+	err := os.WriteFile(sheet, []byte("Path,artist,ARTIST#3,title\na.flac,One,Three,Title\nb.flac,,,\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := readSheet(sheet)
+	if err != nil {
+		t.Fatalf("readSheet: %s", err)
+	}
+	want := []SheetRow{
+		{Path: "a.flac", Tags: tagmodel.Tags{"ARTIST": {"One", "Three"}, "TITLE": {"Title"}}},
+		{Path: "b.flac", Tags: tagmodel.Tags{}},
+	}
+	if !reflect.DeepEqual(read, want) {
+		t.Errorf("readSheet = %q, want %q", read, want)
+	}
+
+	err = os.WriteFile(sheet, []byte("title,path\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSheet(sheet); err == nil {
+		t.Errorf("readSheet succeeded without the path in the first column")
+	}
+}
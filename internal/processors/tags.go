@@ -0,0 +1,317 @@
+package processors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/commands"
+	"github.com/Chromfalke/audio-workbench/internal/lib"
+	"github.com/Chromfalke/audio-workbench/internal/picture"
+	"github.com/Chromfalke/audio-workbench/internal/tagmodel"
+)
+
+// Processor to print the tags of a file
+type TagPrinter struct {
+	JSON bool // print one JSON object per file
+}
+
+func (printer TagPrinter) Run(file lib.Mediafile, outpath string) error {
+	if !file.IsAudio() {
+		return nil
+	}
+
+	tags, err := commands.ReadMetadata(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read the tags of %s: %s", file.Path, err)
+	}
+
+	if printer.JSON {
+		output, err := json.Marshal(SheetRow{Path: file.Path, Tags: tags})
+		if err != nil {
+			return fmt.Errorf("Failed to encode the tags of %s: %s", file.Path, err)
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	if len(tags) == 0 {
+		fmt.Printf("%s has no tags.\n", file.Path)
+		return nil
+	}
+	fmt.Printf("%s:\n", file.Path)
+	for _, key := range tags.Keys() {
+		for _, value := range tags[key] {
+			fmt.Printf("  %s=%s\n", key, strings.ReplaceAll(value, "\n", "\n    "))
+		}
+	}
+	return nil
+}
+
+// Processor to change the tags of a file without re-encoding the audio
+type TagEditor struct {
+	Clear  bool          // remove all tags before setting new ones
+	Remove []string      // keys to remove
+	Set    tagmodel.Tags // replaces the values of its keys
+}
+
+func (editor TagEditor) Run(file lib.Mediafile, outpath string) error {
+	if !file.IsAudio() {
+		return nil
+	}
+	if skipReadOnly(file) {
+		return nil
+	}
+
+	target, err := inplaceTarget(file, outpath)
+	if err != nil {
+		return err
+	}
+
+	tags := tagmodel.Tags{}
+	if !editor.Clear {
+		tags, err = commands.ReadMetadata(target)
+		if err != nil {
+			return fmt.Errorf("Failed to read the tags of %s: %s", target.Path, err)
+		}
+	}
+	for _, key := range editor.Remove {
+		tags.Set(key)
+	}
+	for key, values := range editor.Set {
+		tags.Set(key, values...)
+	}
+
+	return writeMetadata(target, tags)
+}
+
+// Processor to write tags parsed from the file name
+type NameTagger struct {
+	Pattern tagmodel.Pattern
+	Preview bool // only print the parsed tags
+}
+
+func (tagger NameTagger) Run(file lib.Mediafile, outpath string) error {
+	if !file.IsAudio() {
+		return nil
+	}
+
+	parsed, ok := tagger.Pattern.Match(file.Path)
+	if !ok {
+		fmt.Printf("Skipped %s since its name doesn't match the pattern.\n", file.Path)
+		return nil
+	}
+	fmt.Printf("%s:\n", file.Path)
+	for _, key := range parsed.Keys() {
+		fmt.Printf("  %s=%s\n", key, strings.Join(parsed[key], "; "))
+	}
+	if tagger.Preview {
+		return nil
+	}
+
+	return TagEditor{Set: parsed}.Run(file, outpath)
+}
+
+// Processor to rename a file after its tags
+type Renamer struct {
+	Template tagmodel.Pattern
+	Preview  bool // only print the new names
+}
+
+func (renamer Renamer) Run(file lib.Mediafile, outpath string) error {
+	if !file.IsAudio() {
+		return nil
+	}
+
+	tags, err := commands.ReadMetadata(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read the tags of %s: %s", file.Path, err)
+	}
+	name, err := renamer.Template.Format(tags)
+	if err != nil {
+		fmt.Printf("Skipped %s: %s.\n", file.Path, err)
+		return nil
+	}
+	target := filepath.Join(filepath.Dir(file.Path), name+filepath.Ext(file.Path))
+	if target == file.Path {
+		return nil
+	}
+	fmt.Printf("%s -> %s\n", file.Path, target)
+	if renamer.Preview {
+		return nil
+	}
+
+	_, err = os.Stat(target)
+	if err == nil {
+		return fmt.Errorf("Failed to rename %s since %s already exists", file.Path, target)
+	}
+	err = os.MkdirAll(filepath.Dir(target), 0775)
+	if err != nil {
+		return fmt.Errorf("Failed to create the directory for %s: %s", target, err)
+	}
+	err = os.Rename(file.Path, target)
+	if err != nil {
+		return fmt.Errorf("Failed to rename %s to %s: %s", file.Path, target, err)
+	}
+	return nil
+}
+
+// Tags ffprobe reports for the structure of MP4 containers.
+var containerTags = []string{"MAJOR_BRAND", "MINOR_VERSION", "COMPATIBLE_BRANDS"}
+
+// Processor to remove metadata from a file and check that nothing unwanted remains
+type Stripper struct {
+	Keep         []string // patterns of keys to keep, everything else is removed
+	Remove       []string // patterns of keys to remove, everything else is kept
+	KeepPictures bool
+}
+
+// Check whether a key is kept. Patterns may contain wildcards such as MUSICBRAINZ_*.
+func (stripper Stripper) keeps(key string) bool {
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			ok, _ := path.Match(tagmodel.Canonical(pattern), key)
+			if ok {
+				return true
+			}
+		}
+		return false
+	}
+	if len(stripper.Remove) > 0 {
+		return !matches(stripper.Remove)
+	}
+	return matches(stripper.Keep)
+}
+
+func (stripper Stripper) Run(file lib.Mediafile, outpath string) error {
+	if !file.IsAudio() {
+		return nil
+	}
+	if skipReadOnly(file) {
+		return nil
+	}
+
+	target, err := inplaceTarget(file, outpath)
+	if err != nil {
+		return err
+	}
+
+	tags, err := commands.ReadMetadata(target)
+	if err != nil {
+		return fmt.Errorf("Failed to read the tags of %s: %s", target.Path, err)
+	}
+	kept := tagmodel.Tags{}
+	for key, values := range tags {
+		if stripper.keeps(key) {
+			kept[key] = values
+		}
+	}
+	var pictures []picture.Picture
+	if stripper.KeepPictures {
+		pictures, err = commands.ReadPictures(target)
+		if err != nil {
+			return fmt.Errorf("Failed to read the pictures of %s: %s", target.Path, err)
+		}
+	}
+
+	err = commands.StripMetadata(target, stripper.KeepPictures)
+	if err != nil {
+		return fmt.Errorf("Failed to strip the metadata of %s: %s", target.Path, err)
+	}
+	if len(kept) > 0 {
+		err = writeMetadata(target, kept)
+		if err != nil {
+			return err
+		}
+	}
+	err = commands.WritePictures(target, pictures)
+	if err != nil {
+		return fmt.Errorf("Failed to restore the pictures of %s: %s", target.Path, err)
+	}
+
+	return stripper.verify(target)
+}
+
+// Read the file back natively and through ffprobe and fail if anything which should be removed remains.
+func (stripper Stripper) verify(file lib.Mediafile) error {
+	remaining, err := commands.ReadMetadata(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read the tags of %s: %s", file.Path, err)
+	}
+	probed, err := commands.ReadTags(file)
+	if err != nil {
+		return fmt.Errorf("Failed to probe the tags of %s: %s", file.Path, err)
+	}
+	for key, values := range tagmodel.FromFFmpeg(probed) {
+		remaining.Add(key, values...)
+	}
+
+	var left []string
+	var kept int
+	for _, key := range remaining.Keys() {
+		switch {
+		case slices.Contains(containerTags, key):
+		case stripper.keeps(key):
+			kept++
+		default:
+			left = append(left, key)
+		}
+	}
+	if !stripper.KeepPictures {
+		pictures, err := commands.ReadPictures(file)
+		if err != nil {
+			return fmt.Errorf("Failed to read the pictures of %s: %s", file.Path, err)
+		}
+		if len(pictures) > 0 {
+			left = append(left, "pictures")
+		}
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("Failed to strip %s, it still contains %s", file.Path, strings.Join(left, ", "))
+	}
+
+	fmt.Printf("Stripped %s, kept %d tags.\n", file.Path, kept)
+	return nil
+}
+
+// Processor to clean up tags with a rule set
+type TagNormalizer struct {
+	Rules  tagmodel.Rules
+	DryRun bool // only print the changes
+}
+
+func (normalizer TagNormalizer) Run(file lib.Mediafile, outpath string) error {
+	if !file.IsAudio() {
+		return nil
+	}
+	if skipReadOnly(file) {
+		return nil
+	}
+
+	target, err := inplaceTarget(file, outpath)
+	if err != nil {
+		return err
+	}
+	tags, err := commands.ReadMetadata(target)
+	if err != nil {
+		return fmt.Errorf("Failed to read the tags of %s: %s", target.Path, err)
+	}
+	normalized := normalizer.Rules.Apply(tags)
+	diff := diffTags(tags, normalized)
+	if len(diff) == 0 {
+		return nil
+	}
+	fmt.Printf("%s:\n", target.Path)
+	for _, line := range diff {
+		fmt.Printf("  %s\n", line)
+	}
+	if normalizer.DryRun {
+		return nil
+	}
+
+	return writeMetadata(target, normalized)
+}
@@ -0,0 +1,98 @@
+package sweep
+
+import (
+	"math"
+)
+
+// Amplitude of the generated sweep, 6 dB below full scale to leave headroom for the ringing of the resampler.
+const Amplitude = 0.5
+
+// Levels below this are reported as this in dB, silence would be -inf otherwise.
+const Floor = -200.0
+
+// Linear sine sweep, the frequency rises at a constant rate so the frequency of each sample follows from its time.
+type Sweep struct {
+	SampleRate int
+	Start      float64 // frequency in Hz
+	End        float64
+	Duration   float64 // seconds
+}
+
+// Result of sending a sweep through a resampler.
+type Report struct {
+	PassbandStart float64 // frequency range in Hz in which the gain is measured
+	PassbandEdge  float64
+	MinGain       float64 // lowest and highest gain in dB within the passband
+	MaxGain       float64
+	StopbandEdge  float64 // frequency in Hz above which the input can't be represented, 0 when upsampling
+	Aliasing      float64 // highest level in dB relative to the sweep for input above the stopband edge
+}
+
+// Sweep from 20 Hz to just below the Nyquist frequency of the sample rate.
+func New(sampleRate int, duration float64) Sweep {
+	return Sweep{
+		SampleRate: sampleRate,
+		Start:      20,
+		End:        0.99 * float64(sampleRate) / 2,
+		Duration:   duration,
+	}
+}
+
+// Instantaneous frequency at a time in seconds.
+func (sweep Sweep) Frequency(t float64) float64 {
+	return sweep.Start + (sweep.End-sweep.Start)*t/sweep.Duration
+}
+
+func (sweep Sweep) Samples() []float32 {
+	samples := make([]float32, int(sweep.Duration*float64(sweep.SampleRate)))
+	for i := range samples {
+		t := float64(i) / float64(sweep.SampleRate)
+		phase := 2 * math.Pi * (sweep.Start*t + (sweep.End-sweep.Start)*t*t/(2*sweep.Duration))
+		samples[i] = float32(Amplitude * math.Sin(phase))
+	}
+	return samples
+}
+
+// Measure the resampled sweep in windows of 50 ms. A window in the passband should keep the level of the sweep and a
+// window whose input lies above the Nyquist frequency of the new sample rate should be silent, anything left there has
+// been folded back as aliasing. The first and last window are skipped since they contain the ramp of the filter, the
+// passband starts at 1 kHz since windows with few periods don't have a stable level.
+func (sweep Sweep) Analyze(resampled []float32, sampleRate int) Report {
+	report := Report{
+		PassbandStart: 1000,
+		PassbandEdge:  0.9 * float64(min(sweep.SampleRate, sampleRate)) / 2,
+		MinGain:       math.Inf(1),
+		MaxGain:       math.Inf(-1),
+		Aliasing:      Floor,
+	}
+	if sampleRate < sweep.SampleRate {
+		report.StopbandEdge = float64(sampleRate) / 2
+	}
+
+	reference := Amplitude / math.Sqrt2
+	window := sampleRate / 20
+	for start := window; start+2*window <= len(resampled); start += window {
+		low := sweep.Frequency(float64(start) / float64(sampleRate))
+		high := sweep.Frequency(float64(start+window) / float64(sampleRate))
+
+		var sum float64
+		for _, sample := range resampled[start : start+window] {
+			sum += float64(sample) * float64(sample)
+		}
+		level := 20 * math.Log10(max(math.Sqrt(sum/float64(window))/reference, math.Pow(10, Floor/20)))
+
+		if low >= report.PassbandStart && high <= report.PassbandEdge {
+			report.MinGain = min(report.MinGain, level)
+			report.MaxGain = max(report.MaxGain, level)
+		}
+		if report.StopbandEdge != 0 && low >= report.StopbandEdge {
+			report.Aliasing = max(report.Aliasing, level)
+		}
+	}
+	return report
+}
+
+// Difference between the highest and lowest gain in the passband in dB.
+func (report Report) Ripple() float64 {
+	return report.MaxGain - report.MinGain
+}
@@ -0,0 +1,141 @@
+package tagmodel
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/formats"
+)
+
+// Generic ffmpeg metadata keys, used by the muxers without native tag support.
+var ffmpegKeys = map[string]string{
+	"TITLE":           "title",
+	"ARTIST":          "artist",
+	"ALBUM":           "album",
+	"ALBUMARTIST":     "album_artist",
+	"COMPOSER":        "composer",
+	"GENRE":           "genre",
+	"DATE":            "date",
+	"COMMENT":         "comment",
+	"COPYRIGHT":       "copyright",
+	"TRACKNUMBER":     "track",
+	"DISCNUMBER":      "disc",
+	"ENCODER":         "encoder",
+	"ENCODEDBY":       "encoded_by",
+	"LABEL":           "publisher",
+	"LANGUAGE":        "language",
+	"LYRICS":          "lyrics",
+	"GROUPING":        "grouping",
+	"COMPILATION":     "compilation",
+	"TITLESORT":       "title-sort",
+	"ARTISTSORT":      "artist-sort",
+	"ALBUMSORT":       "album-sort",
+	"ALBUMARTISTSORT": "album_artist-sort",
+	"COMPOSERSORT":    "composer-sort",
+}
+
+// Keys the RIFF INFO chunk of WAV files can hold.
+var riffKeys = []string{"TITLE", "ARTIST", "ALBUM", "GENRE", "DATE", "COMMENT", "COPYRIGHT", "TRACKNUMBER", "ENCODER", "ENCODEDBY", "LANGUAGE"}
+
+// APEv2 item names which differ from the key.
+var apeKeys = map[string]string{
+	"TITLE":       "Title",
+	"ARTIST":      "Artist",
+	"ALBUM":       "Album",
+	"ALBUMARTIST": "Album Artist",
+	"COMPOSER":    "Composer",
+	"GENRE":       "Genre",
+	"DATE":        "Year",
+	"COMMENT":     "Comment",
+	"COPYRIGHT":   "Copyright",
+	"TRACKNUMBER": "Track",
+	"DISCNUMBER":  "Disc",
+	"LYRICS":      "Lyrics",
+	"LABEL":       "Publisher",
+}
+
+// Build the model from the tags reported by ffprobe. Generic ffmpeg keys and ID3 frame IDs which ffmpeg passes through
+// are mapped to their keys, everything else is taken as it is.
+func FromFFmpeg(probed map[string]string) Tags {
+	tags := Tags{}
+	for key, value := range probed {
+		model := fromFFmpegKey(key)
+		if model == key {
+			remember(key)
+		}
+		tags.Add(model, value)
+	}
+	return tags
+}
+
+func fromFFmpegKey(key string) string {
+	for model, generic := range ffmpegKeys {
+		if strings.EqualFold(generic, key) {
+			return model
+		}
+	}
+	for model, ape := range apeKeys {
+		if strings.EqualFold(ape, key) {
+			return model
+		}
+	}
+	for _, mapping := range Mappings {
+		if strings.EqualFold(mapping.ID3, key) || strings.EqualFold(strings.TrimPrefix(mapping.ID3, "TXXX:"), key) {
+			return mapping.Key
+		}
+	}
+	return key
+}
+
+// Build the -metadata entries for the tag system of a format without native tag support. Multiple values are joined
+// since ffmpeg only keeps one value per key. Returns the entries and the keys which can't be stored.
+func ToFFmpeg(tags Tags, system string) ([]string, []string) {
+	var entries, lost []string
+	for _, key := range tags.Keys() {
+		value := strings.Join(tags[key], "; ")
+		if key == "TRACKNUMBER" || key == "DISCNUMBER" {
+			value = tags.pair(strings.TrimSuffix(key, "NUMBER"))
+		}
+
+		name := ""
+		switch system {
+		case formats.RIFFInfo:
+			if key == "TRACKTOTAL" || !slices.Contains(riffKeys, key) {
+				lost = append(lost, key)
+				continue
+			}
+			if key == "TRACKNUMBER" {
+				value = tags.Get(key)
+			}
+			name = ffmpegKeys[key]
+		case formats.ID3v2Chunk:
+			name = ffmpegKeys[key]
+			mapping, ok := mappingFor(key)
+			if name == "" && ok && strings.HasPrefix(mapping.ID3, "T") && !strings.HasPrefix(mapping.ID3, "TXXX:") {
+				// the id3v2 muxer writes known text frames given by their ID
+				name = mapping.ID3
+			}
+			if name == "" && ok && strings.HasPrefix(mapping.ID3, "TXXX:") {
+				name = strings.TrimPrefix(mapping.ID3, "TXXX:")
+			}
+		case formats.APEv2:
+			name = apeKeys[key]
+		default:
+			lost = append(lost, key)
+			continue
+		}
+
+		if key == "TRACKTOTAL" || key == "DISCTOTAL" {
+			// stored with the number
+			if tags.Get(strings.TrimSuffix(key, "TOTAL")+"NUMBER") == "" {
+				lost = append(lost, key)
+			}
+			continue
+		}
+		if name == "" {
+			name = spelling(key)
+		}
+		entries = append(entries, name+"="+value)
+	}
+	return entries, lost
+}
@@ -0,0 +1,37 @@
+package tagmodel
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/Chromfalke/audio-workbench/internal/formats"
+)
+
+func TestFromFFmpeg(t *testing.T) {
+	probed := map[string]string{"title": "Title", "album_artist": "Artist", "Year": "2020", "track": "3/12", "TPE3": "Conductor", "MusicBrainz Album Id": "4567"}
+	want := Tags{"TITLE": {"Title"}, "ALBUMARTIST": {"Artist"}, "DATE": {"2020"}, "TRACKNUMBER": {"3"}, "TRACKTOTAL": {"12"}, "CONDUCTOR": {"Conductor"}, "MUSICBRAINZ_ALBUMID": {"4567"}}
+	if got := FromFFmpeg(probed); !reflect.DeepEqual(got, want) {
+		t.Errorf("FromFFmpeg = %q, want %q", got, want)
+	}
+}
+
+func TestToFFmpeg(t *testing.T) {
+	tags := Tags{"TITLE": {"Title"}, "ARTIST": {"One", "Two"}, "TRACKNUMBER": {"3"}, "TRACKTOTAL": {"12"}, "CONDUCTOR": {"Conductor"}, "MUSICBRAINZ_ALBUMID": {"4567"}}
+	tests := []struct {
+		system  string
+		entries []string
+		lost    []string
+	}{
+		{formats.RIFFInfo, []string{"title=Title", "artist=One; Two", "track=3"}, []string{"CONDUCTOR", "TRACKTOTAL", "MUSICBRAINZ_ALBUMID"}},
+		{formats.ID3v2Chunk, []string{"title=Title", "artist=One; Two", "TPE3=Conductor", "track=3/12", "MusicBrainz Album Id=4567"}, nil},
+		{formats.APEv2, []string{"Title=Title", "Artist=One; Two", "CONDUCTOR=Conductor", "Track=3/12", "MUSICBRAINZ_ALBUMID=4567"}, nil},
+		{formats.NoTags, nil, tags.Keys()},
+	}
+	for _, test := range tests {
+		entries, lost := ToFFmpeg(tags, test.system)
+		if !slices.Equal(entries, test.entries) || !slices.Equal(lost, test.lost) {
+			t.Errorf("ToFFmpeg(%q) = %q, %q, want %q, %q", test.system, entries, lost, test.entries, test.lost)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package tagmodel
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/Chromfalke/audio-workbench/internal/id3"
+	"github.com/Chromfalke/audio-workbench/internal/lrc"
+)
+
+// Return the frame ID of a mapping for the tag version. ID3v2.3 has no timestamp frames, only year frames.
+func id3Frame(mapping Mapping, version byte) string {
+	if version == 3 {
+		switch mapping.ID3 {
+		case "TDRC":
+			return "TYER"
+		case "TDOR":
+			return "TORY"
+		}
+	}
+	return mapping.ID3
+}
+
+// Check whether a TXXX description belongs to a key of the mapping table.
+func mappedUserText(description string) bool {
+	for _, mapping := range Mappings {
+		if strings.EqualFold(mapping.ID3, "TXXX:"+description) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMusicBrainzID(frame id3.Frame) bool {
+	return frame.ID == "UFID" && bytes.HasPrefix(frame.Data, []byte(musicBrainzOwner+"\x00"))
+}
+
+// Check whether FromID3 reads the frame into the model. Comments and lyrics with a description aren't read and are
+// handled separately.
+func ownedFrame(frame id3.Frame, version byte) bool {
+	switch frame.ID {
+	case "TXXX", "SYLT":
+		return true
+	case "UFID":
+		return isMusicBrainzID(frame)
+	}
+	for _, mapping := range Mappings {
+		if id3Frame(mapping, version) == frame.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the comments or lyrics with a description, the model only holds those without one.
+func described(comments []id3.Comment) []id3.Comment {
+	var kept []id3.Comment
+	for _, comment := range comments {
+		if comment.Description != "" {
+			kept = append(kept, comment)
+		}
+	}
+	return kept
+}
+
+func FromID3(tag *id3.Tag) Tags {
+	tags := Tags{}
+	for _, mapping := range Mappings {
+		id := id3Frame(mapping, tag.Version)
+		switch {
+		case mapping.Key == "TRACKTOTAL" || mapping.Key == "DISCTOTAL":
+			// split off the number
+		case id == "COMM":
+			for _, comment := range tag.Comments() {
+				if comment.Description == "" {
+					tags.Add(mapping.Key, comment.Text)
+				}
+			}
+		case id == "USLT" && len(tag.SyncedLyrics()) > 0:
+			synced := lrc.Lyrics{Synced: true}
+			for _, line := range tag.SyncedLyrics()[0].Lines {
+				synced.Lines = append(synced.Lines, lrc.Line{Time: time.Duration(line.Time) * time.Millisecond, Text: line.Text})
+			}
+			tags.Add(mapping.Key, strings.TrimSuffix(synced.String(), "\n"))
+		case id == "USLT":
+			for _, lyrics := range tag.Lyrics() {
+				if lyrics.Description == "" {
+					tags.Add(mapping.Key, lyrics.Text)
+				}
+			}
+		case id == "UFID":
+			for _, frame := range tag.Frames {
+				if isMusicBrainzID(frame) {
+					tags.Add(mapping.Key, string(frame.Data[len(musicBrainzOwner)+1:]))
+				}
+			}
+		case strings.HasPrefix(id, "TXXX:"):
+			tags.Add(mapping.Key, tag.UserText(strings.TrimPrefix(id, "TXXX:"))...)
+		default:
+			tags.Add(mapping.Key, tag.Text(id)...)
+		}
+	}
+	for _, text := range tag.UserTexts() {
+		if !mappedUserText(text.Description) {
+			remember(text.Description)
+			tags.Add(text.Description, text.Values...)
+		}
+	}
+	return tags
+}
+
+// Replace the frames read by FromID3: the mapped text frames, all TXXX frames, comments and lyrics without a
+// description, synchronised lyrics and the MusicBrainz recording ID. Other frames such as pictures, chapters, unmapped
+// text frames and comments with a description are kept. Returns the keys which could only be stored partially.
+func ToID3(tags Tags, tag *id3.Tag) []string {
+	comments := described(tag.Comments())
+	lyrics := described(tag.Lyrics())
+	var frames []id3.Frame
+	for _, frame := range tag.Frames {
+		if ownedFrame(frame, tag.Version) || frame.ID == "COMM" || frame.ID == "USLT" {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	tag.Frames = frames
+
+	var lost []string
+	for _, key := range tags.Keys() {
+		values := tags[key]
+		mapping, ok := mappingFor(key)
+		if !ok {
+			tag.SetUserText(spelling(key), values...)
+			continue
+		}
+
+		id := id3Frame(mapping, tag.Version)
+		switch {
+		case key == "TRACKTOTAL" || key == "DISCTOTAL":
+			if tags.Get(strings.TrimSuffix(key, "TOTAL")+"NUMBER") == "" {
+				lost = append(lost, key)
+			}
+		case key == "TRACKNUMBER" || key == "DISCNUMBER":
+			tag.SetText(id, tags.pair(strings.TrimSuffix(key, "NUMBER")))
+		case id == "COMM":
+			// a language and description may only be used once
+			comments = append([]id3.Comment{{Language: "eng", Text: strings.Join(values, "\n")}}, comments...)
+		case id == "USLT":
+			parsed := lrc.Parse(values[0])
+			if !parsed.Synced {
+				lyrics = append([]id3.Comment{{Language: "eng", Text: strings.Join(values, "\n")}}, lyrics...)
+				continue
+			}
+			synced := id3.SyncedLyrics{Language: "eng"}
+			for _, line := range parsed.Lines {
+				synced.Lines = append(synced.Lines, id3.SyncedLine{Time: uint32(line.Time.Milliseconds()), Text: line.Text})
+			}
+			tag.SetSyncedLyrics([]id3.SyncedLyrics{synced})
+			lyrics = append([]id3.Comment{{Language: "eng", Text: parsed.Plain()}}, lyrics...)
+		case id == "UFID":
+			tag.Frames = append(tag.Frames, id3.Frame{ID: id, Data: []byte(musicBrainzOwner + "\x00" + values[0])})
+		case strings.HasPrefix(id, "TXXX:"):
+			tag.SetUserText(strings.TrimPrefix(id, "TXXX:"), values...)
+		case id == "TYER" || id == "TORY":
+			if len(values[0]) > 4 {
+				lost = append(lost, key+" (only the year)")
+			}
+			tag.SetText(id, values[0][:min(len(values[0]), 4)])
+		default:
+			tag.SetText(id, values...)
+		}
+	}
+	tag.SetComments(comments)
+	tag.SetLyrics(lyrics)
+	return lost
+}
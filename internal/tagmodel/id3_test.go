@@ -0,0 +1,140 @@
+package tagmodel
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/Chromfalke/audio-workbench/internal/id3"
+)
+
+// Frames the model doesn't map, they have to survive every write.
+var unmappedFrames = []string{"TIPL", "TMCL", "TLEN", "TDRL", "TOLY", "TOWN", "TDEN", "TDAT", "TIME"}
+
+func TestID3RoundTrip(t *testing.T) {
+	tags := Tags{
+		"TITLE":               {"Title"},
+		"ARTIST":              {"One", "Two"},
+		"ALBUM":               {"Album"},
+		"TRACKNUMBER":         {"3"},
+		"TRACKTOTAL":          {"12"},
+		"DISCNUMBER":          {"1"},
+		"DATE":                {"2020"},
+		"GENRE":               {"Jazz"},
+		"COMMENT":             {"A comment"},
+		"LYRICS":              {"First line\nSecond line"},
+		"MUSICBRAINZ_TRACKID": {"0123"},
+		"MUSICBRAINZ_ALBUMID": {"4567"},
+		"CUSTOM":              {"Value"},
+	}
+	for _, version := range []byte{3, 4} {
+		tag := &id3.Tag{Version: version}
+		lost := ToID3(tags, tag)
+		if len(lost) > 0 {
+			t.Errorf("ID3v2.%d: lost %q", version, lost)
+		}
+		want := tags
+		if version == 3 {
+			// ID3v2.3 joins multiple values
+			want = Tags{}
+			for key, values := range tags {
+				want[key] = values
+			}
+			want["ARTIST"] = []string{"One/Two"}
+		}
+		if got := FromID3(tag); !reflect.DeepEqual(got, want) {
+			t.Errorf("ID3v2.%d: read back %q, want %q", version, got, want)
+		}
+	}
+}
+
+func TestID3SyncedLyrics(t *testing.T) {
+	lyrics := "[00:01.00]First\n[00:02.50]Second"
+	tag := &id3.Tag{Version: 4}
+	ToID3(Tags{"LYRICS": {lyrics}}, tag)
+	if got := tag.Lyrics(); len(got) != 1 || got[0].Text != "First\nSecond" {
+		t.Errorf("USLT = %+v, want the plain text", got)
+	}
+	if got := FromID3(tag).Get("LYRICS"); got != lyrics {
+		t.Errorf("LYRICS = %q, want %q", got, lyrics)
+	}
+}
+
+func TestID3DateVersion3(t *testing.T) {
+	tag := &id3.Tag{Version: 3}
+	lost := ToID3(Tags{"DATE": {"2020-05-01"}}, tag)
+	if len(lost) != 1 || tag.Text("TYER")[0] != "2020" {
+		t.Errorf("TYER = %q, lost %q", tag.Text("TYER"), lost)
+	}
+}
+
+func TestID3KeepsUnownedFrames(t *testing.T) {
+	for _, version := range []byte{3, 4} {
+		tag := &id3.Tag{Version: version}
+		for _, id := range unmappedFrames {
+			tag.SetText(id, id+" value")
+		}
+		picture := id3.Frame{ID: "APIC", Data: []byte("\x00image/png\x00\x03\x00png")}
+		tag.Frames = append(tag.Frames, picture)
+		tag.SetText("TIT2", "Title")
+		tag.SetUserText("Acoustid Id", "abcd")
+		tag.SetComments([]id3.Comment{
+			{Language: "eng", Text: "Plain comment"},
+			{Language: "eng", Description: "iTunNORM", Text: "00000A2C"},
+			{Language: "eng", Description: "iTunSMPB", Text: "00000000 00000210"},
+		})
+		tag.SetLyrics([]id3.Comment{{Language: "deu", Description: "Übersetzung", Text: "Zeile"}})
+
+		tags := FromID3(tag)
+		tags.Set("TITLE", "New title")
+		tags.Set("COMMENT", "New comment")
+		lost := ToID3(tags, tag)
+		if len(lost) > 0 {
+			t.Errorf("ID3v2.%d: lost %q", version, lost)
+		}
+
+		for _, id := range unmappedFrames {
+			if got := tag.Text(id); len(got) != 1 || got[0] != id+" value" {
+				t.Errorf("ID3v2.%d: %s = %q after writing", version, id, got)
+			}
+		}
+		found := false
+		for _, frame := range tag.Frames {
+			found = found || (frame.ID == "APIC" && bytes.Equal(frame.Data, picture.Data))
+		}
+		if !found {
+			t.Errorf("ID3v2.%d: the picture was removed", version)
+		}
+		if got := tag.Text("TIT2"); len(got) != 1 || got[0] != "New title" {
+			t.Errorf("ID3v2.%d: TIT2 = %q", version, got)
+		}
+		wantComments := []id3.Comment{
+			{Language: "eng", Text: "New comment"},
+			{Language: "eng", Description: "iTunNORM", Text: "00000A2C"},
+			{Language: "eng", Description: "iTunSMPB", Text: "00000000 00000210"},
+		}
+		if got := tag.Comments(); !reflect.DeepEqual(got, wantComments) {
+			t.Errorf("ID3v2.%d: COMM = %+v, want %+v", version, got, wantComments)
+		}
+		if got := tag.Lyrics(); len(got) != 1 || got[0].Description != "Übersetzung" {
+			t.Errorf("ID3v2.%d: USLT = %+v", version, got)
+		}
+		texts := tag.UserTexts()
+		if len(texts) != 1 || texts[0].Description != "Acoustid Id" || texts[0].Values[0] != "abcd" {
+			t.Errorf("ID3v2.%d: TXXX = %+v, want the original description", version, texts)
+		}
+	}
+}
+
+func TestID3RemovesOwnedFrames(t *testing.T) {
+	tag := &id3.Tag{Version: 4}
+	ToID3(Tags{"TITLE": {"Title"}, "COMMENT": {"Comment"}, "LYRICS": {"[00:01.00]Line"}, "MUSICBRAINZ_TRACKID": {"0123"}, "CUSTOM": {"Value"}}, tag)
+	ToID3(Tags{"ARTIST": {"Artist"}}, tag)
+	if len(tag.Frames) != 1 || tag.Frames[0].ID != "TPE1" {
+		var ids []string
+		for _, frame := range tag.Frames {
+			ids = append(ids, frame.ID)
+		}
+		t.Errorf("Frames left after replacing the tags: %q, want only TPE1", ids)
+	}
+}
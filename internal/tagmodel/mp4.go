@@ -0,0 +1,142 @@
+package tagmodel
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/mp4"
+)
+
+// Number keys stored as integer pairs.
+var pairs = []struct {
+	prefix string
+	item   string
+}{
+	{"TRACK", mp4.Track},
+	{"DISC", mp4.Disc},
+}
+
+// Return the item name of a mapping, keys without an atom are stored as freeform item.
+func mp4Item(mapping Mapping) string {
+	if mapping.MP4 == "" {
+		return mp4.FreeformPrefix + mapping.Key
+	}
+	return mapping.MP4
+}
+
+// Check whether an item belongs to a key of the mapping table.
+func mappedItem(name string) bool {
+	for _, mapping := range Mappings {
+		if strings.EqualFold(mp4Item(mapping), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFreeform(name string) bool {
+	return len(name) >= len(mp4.FreeformPrefix) && strings.EqualFold(name[:len(mp4.FreeformPrefix)], mp4.FreeformPrefix)
+}
+
+func FromMP4(metadata *mp4.Metadata) Tags {
+	tags := Tags{}
+	for _, pair := range pairs {
+		number, total, ok := metadata.Pair(pair.item)
+		if ok && number > 0 {
+			tags.Add(pair.prefix+"NUMBER", strconv.Itoa(number))
+		}
+		if ok && total > 0 {
+			tags.Add(pair.prefix+"TOTAL", strconv.Itoa(total))
+		}
+	}
+	for _, mapping := range Mappings {
+		switch mapping.MP4 {
+		case mp4.Track, mp4.Disc:
+			// read as pairs
+		case mp4.Compilation:
+			compilation, ok := metadata.Flag(mapping.MP4)
+			if ok && compilation {
+				tags.Add(mapping.Key, "1")
+			}
+		case mp4.Tempo:
+			for _, item := range metadata.Items {
+				if item.Name == mp4.Tempo && len(item.Values) > 0 && len(item.Values[0].Value) == 2 {
+					tags.Add(mapping.Key, strconv.Itoa(int(binary.BigEndian.Uint16(item.Values[0].Value))))
+				}
+			}
+		default:
+			tags.Add(mapping.Key, metadata.Text(mp4Item(mapping))...)
+		}
+	}
+	for _, item := range metadata.Items {
+		if isFreeform(item.Name) && !mappedItem(item.Name) {
+			remember(item.Name[len(mp4.FreeformPrefix):])
+			tags.Add(item.Name[len(mp4.FreeformPrefix):], metadata.Text(item.Name)...)
+		}
+	}
+	return tags
+}
+
+// Replace the items of the mapping table and all iTunes freeform items. Other items such as the cover are kept.
+// Returns the keys which couldn't be stored.
+func ToMP4(tags Tags, metadata *mp4.Metadata) []string {
+	for _, mapping := range Mappings {
+		metadata.Remove(mp4Item(mapping))
+	}
+	var items []mp4.Item
+	for _, item := range metadata.Items {
+		if !isFreeform(item.Name) {
+			items = append(items, item)
+		}
+	}
+	metadata.Items = items
+
+	var lost []string
+	for _, pair := range pairs {
+		numberKey, totalKey := pair.prefix+"NUMBER", pair.prefix+"TOTAL"
+		if tags.Get(numberKey) == "" && tags.Get(totalKey) == "" {
+			continue
+		}
+		number, numberErr := strconv.Atoi(strings.TrimSpace(tags.Get(numberKey)))
+		total, totalErr := strconv.Atoi(strings.TrimSpace(tags.Get(totalKey)))
+		if numberErr != nil && tags.Get(numberKey) != "" {
+			lost = append(lost, numberKey)
+		}
+		if totalErr != nil && tags.Get(totalKey) != "" {
+			lost = append(lost, totalKey)
+		}
+		if number > 0 || total > 0 {
+			metadata.SetPair(pair.item, number, total)
+		}
+	}
+
+	for _, key := range tags.Keys() {
+		values := tags[key]
+		mapping, ok := mappingFor(key)
+		if !ok {
+			metadata.SetText(mp4.FreeformPrefix+spelling(key), values...)
+			continue
+		}
+
+		switch mapping.MP4 {
+		case mp4.Track, mp4.Disc:
+			// stored as pairs
+		case mp4.Compilation:
+			metadata.SetFlag(mapping.MP4, values[0] != "0")
+		case mp4.Tempo:
+			bpm, err := strconv.ParseFloat(strings.TrimSpace(values[0]), 64)
+			if err != nil || bpm < 0 || bpm > math.MaxUint16 {
+				lost = append(lost, key)
+				continue
+			}
+			value := make([]byte, 2)
+			binary.BigEndian.PutUint16(value, uint16(math.Round(bpm)))
+			metadata.SetItem(mapping.MP4, mp4.Data{Type: mp4.TypeInteger, Value: value})
+		default:
+			metadata.SetText(mp4Item(mapping), values...)
+		}
+	}
+	return lost
+}
@@ -0,0 +1,73 @@
+package tagmodel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Chromfalke/audio-workbench/internal/mp4"
+)
+
+func TestMP4RoundTrip(t *testing.T) {
+	tags := Tags{
+		"TITLE":               {"Title"},
+		"ARTIST":              {"Artist"},
+		"TRACKNUMBER":         {"3"},
+		"TRACKTOTAL":          {"12"},
+		"DISCNUMBER":          {"1"},
+		"COMPILATION":         {"1"},
+		"BPM":                 {"120"},
+		"SUBTITLE":            {"Subtitle"},
+		"MUSICBRAINZ_ALBUMID": {"4567"},
+		"CUSTOM":              {"One", "Two"},
+	}
+	metadata := &mp4.Metadata{}
+	lost := ToMP4(tags, metadata)
+	if len(lost) > 0 {
+		t.Errorf("Lost %q", lost)
+	}
+	if got := FromMP4(metadata); !reflect.DeepEqual(got, tags) {
+		t.Errorf("Read back %q, want %q", got, tags)
+	}
+}
+
+func TestMP4Lost(t *testing.T) {
+	metadata := &mp4.Metadata{}
+	lost := ToMP4(Tags{"TRACKNUMBER": {"A1"}, "BPM": {"fast"}}, metadata)
+	if !reflect.DeepEqual(lost, []string{"TRACKNUMBER", "BPM"}) {
+		t.Errorf("Lost %q, want TRACKNUMBER and BPM", lost)
+	}
+}
+
+func TestMP4FreeformSpelling(t *testing.T) {
+	gapless := " 00000000 00000840 000001C0 0000000000A1B2C3"
+	metadata := &mp4.Metadata{}
+	metadata.SetText(mp4.Title, "Title")
+	metadata.SetText(mp4.FreeformPrefix+"iTunSMPB", gapless)
+	metadata.SetText(mp4.FreeformPrefix+"Acoustid Id", "abcd")
+	metadata.SetItem(mp4.Cover, mp4.Data{Type: mp4.TypePNG, Value: []byte("png")})
+	metadata.SetText("----:org.example:Other", "kept")
+
+	tags := FromMP4(metadata)
+	tags.Set("TITLE", "New title")
+	ToMP4(tags, metadata)
+
+	var names []string
+	for _, item := range metadata.Items {
+		names = append(names, item.Name)
+	}
+	for _, name := range []string{mp4.FreeformPrefix + "iTunSMPB", mp4.FreeformPrefix + "Acoustid Id", mp4.Cover, "----:org.example:Other"} {
+		found := false
+		for _, item := range metadata.Items {
+			found = found || item.Name == name
+		}
+		if !found {
+			t.Errorf("Item %q is missing after writing, items are %q", name, names)
+		}
+	}
+	if got := metadata.Text(mp4.FreeformPrefix + "iTunSMPB"); len(got) != 1 || got[0] != gapless {
+		t.Errorf("iTunSMPB = %q", got)
+	}
+	if got := metadata.Text(mp4.Title); len(got) != 1 || got[0] != "New title" {
+		t.Errorf("Title = %q", got)
+	}
+}
@@ -0,0 +1,113 @@
+package tagmodel
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Field names of patterns which differ from their key.
+var patternFields = map[string]string{
+	"TRACK": "TRACKNUMBER",
+	"DISC":  "DISCNUMBER",
+}
+
+// Characters which can't be used in file names on all systems.
+var unsafeCharacters = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+
+var fieldPattern = regexp.MustCompile(`%([^%]+)%`)
+
+// Pattern of file names with fields such as "%track% - %artist% - %title%". A field is a key of the model or one of
+// track, disc and year, %ignore% matches text which isn't stored. Slashes match the parent directories.
+type Pattern struct {
+	template string
+	fields   []string // keys in the order of the fields
+	regexp   *regexp.Regexp
+}
+
+func ParsePattern(template string) (Pattern, error) {
+	pattern := Pattern{template: template}
+	expression := `(?:^|/)`
+	last := 0
+	for _, match := range fieldPattern.FindAllStringSubmatchIndex(template, -1) {
+		expression += regexp.QuoteMeta(template[last:match[0]]) + `([^/]+?)`
+		pattern.fields = append(pattern.fields, fieldKey(template[match[2]:match[3]]))
+		last = match[1]
+	}
+	if len(pattern.fields) == 0 {
+		return Pattern{}, fmt.Errorf("The pattern %s has no fields", template)
+	}
+	expression += regexp.QuoteMeta(template[last:]) + `$`
+
+	var err error
+	pattern.regexp, err = regexp.Compile(expression)
+	if err != nil {
+		return Pattern{}, fmt.Errorf("Invalid pattern %s: %s", template, err)
+	}
+	return pattern, nil
+}
+
+func fieldKey(field string) string {
+	key := Canonical(field)
+	alias, ok := patternFields[key]
+	if ok {
+		return alias
+	}
+	return key
+}
+
+// Parse the fields from a path. The extension is ignored.
+func (pattern Pattern) Match(path string) (Tags, bool) {
+	path = filepath.ToSlash(strings.TrimSuffix(path, filepath.Ext(path)))
+	match := pattern.regexp.FindStringSubmatch(path)
+	if match == nil {
+		return nil, false
+	}
+
+	tags := Tags{}
+	for i, key := range pattern.fields {
+		value := strings.TrimSpace(match[i+1])
+		if key == "IGNORE" || value == "" {
+			continue
+		}
+		if key == "TRACKNUMBER" || key == "DISCNUMBER" {
+			number, err := strconv.Atoi(value)
+			if err == nil {
+				value = strconv.Itoa(number)
+			}
+		}
+		tags.Add(key, value)
+	}
+	return tags, true
+}
+
+// Fill the fields of the pattern with the tags. Track numbers are padded to two digits, %year% only takes the year of
+// the date and characters which aren't allowed in file names are replaced. Returns an error naming the first field without a value.
+func (pattern Pattern) Format(tags Tags) (string, error) {
+	var missing string
+	name := fieldPattern.ReplaceAllStringFunc(pattern.template, func(field string) string {
+		name := strings.Trim(field, "%")
+		key := fieldKey(name)
+		value := tags.Get(key)
+		if value == "" && missing == "" {
+			missing = name
+		}
+		if strings.EqualFold(name, "year") && len(value) > 4 {
+			// year is an alias of the full date
+			value = value[:4]
+		}
+		if key == "TRACKNUMBER" {
+			number, err := strconv.Atoi(value)
+			if err == nil {
+				value = fmt.Sprintf("%02d", number)
+			}
+		}
+		return unsafeCharacters.Replace(value)
+	})
+	if missing != "" {
+		return "", fmt.Errorf("No value for %%%s%%", missing)
+	}
+	return filepath.FromSlash(name), nil
+}
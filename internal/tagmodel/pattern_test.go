@@ -0,0 +1,65 @@
+package tagmodel
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		want     Tags
+	}{
+		{"%track% - %artist% - %title%", "music/03 - Artist - A Title.flac", Tags{"TRACKNUMBER": {"3"}, "ARTIST": {"Artist"}, "TITLE": {"A Title"}}},
+		{"%artist%/%year% - %album%/%disc%-%track% %title%", "Artist/2020 - Album/1-07 Title.mp3", Tags{"ARTIST": {"Artist"}, "DATE": {"2020"}, "ALBUM": {"Album"}, "DISCNUMBER": {"1"}, "TRACKNUMBER": {"7"}, "TITLE": {"Title"}}},
+		{"%ignore% - %title%", "Something - Title.opus", Tags{"TITLE": {"Title"}}},
+	}
+	for _, test := range tests {
+		pattern, err := ParsePattern(test.template)
+		if err != nil {
+			t.Fatalf("ParsePattern(%s): %s", test.template, err)
+		}
+		got, ok := pattern.Match(filepath.FromSlash(test.path))
+		if !ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Match(%s, %s) = %q, %v, want %q", test.template, test.path, got, ok, test.want)
+		}
+	}
+
+	pattern, _ := ParsePattern("%track% - %title%")
+	if _, ok := pattern.Match("Title only.mp3"); ok {
+		t.Errorf("Match succeeded for a name without the separator")
+	}
+	if _, err := ParsePattern("no fields"); err == nil {
+		t.Errorf("ParsePattern succeeded for a template without fields")
+	}
+}
+
+func TestPatternFormat(t *testing.T) {
+	tags := Tags{"TRACKNUMBER": {"3"}, "ARTIST": {"AC/DC"}, "TITLE": {"What?"}, "DATE": {"2020-05-01"}, "ALBUM": {"Album"}}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"%track% - %artist% - %title%", "03 - AC_DC - What_"},
+		{"%year% - %album%", "2020 - Album"},
+		{"%date% - %album%", "2020-05-01 - Album"},
+		{"%artist%/%album%/%track%", filepath.FromSlash("AC_DC/Album/03")},
+	}
+	for _, test := range tests {
+		pattern, err := ParsePattern(test.template)
+		if err != nil {
+			t.Fatalf("ParsePattern(%s): %s", test.template, err)
+		}
+		got, err := pattern.Format(tags)
+		if err != nil || got != test.want {
+			t.Errorf("Format(%s) = %q, %v, want %q", test.template, got, err, test.want)
+		}
+	}
+
+	pattern, _ := ParsePattern("%track% - %composer%")
+	if _, err := pattern.Format(tags); err == nil {
+		t.Errorf("Format succeeded without a composer")
+	}
+}
@@ -0,0 +1,174 @@
+package tagmodel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var CaseRules = []string{"upper", "lower", "title", "sentence"}
+var NumberRules = []string{"pad", "plain"}
+
+// Cleanup rules for tags, read from a JSON file. The rules are applied in the order of the fields. Numbers given as
+// "3/12" are always split into number and total.
+type Rules struct {
+	Trim    bool              `json:"trim"`    // remove surrounding whitespace and collapse runs of spaces, line breaks are kept
+	Replace []Replacement     `json:"replace"` // regular expression replacements
+	Case    map[string]string `json:"case"`    // case rule per key, see CaseRules
+	Genres  map[string]string `json:"genres"`  // genre spellings and their replacement, matched case insensitively
+	Numbers string            `json:"numbers"` // track and disc numbers: pad to the digits of the total (at least two) or plain
+}
+
+type Replacement struct {
+	Keys    []string `json:"keys"` // keys the replacement applies to, all keys if empty
+	Pattern string   `json:"pattern"`
+	With    string   `json:"with"` // may refer to groups as $1
+
+	regexp *regexp.Regexp
+}
+
+// Read and validate rules from a JSON file.
+func LoadRules(path string) (Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Rules{}, err
+	}
+	var rules Rules
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return Rules{}, fmt.Errorf("Invalid rules in %s: %s", path, err)
+	}
+
+	for i, replacement := range rules.Replace {
+		rules.Replace[i].regexp, err = regexp.Compile(replacement.Pattern)
+		if err != nil {
+			return Rules{}, fmt.Errorf("Invalid pattern %s: %s", replacement.Pattern, err)
+		}
+	}
+	for key, rule := range rules.Case {
+		if !slices.Contains(CaseRules, rule) {
+			return Rules{}, fmt.Errorf("Invalid case rule %s for %s", rule, key)
+		}
+	}
+	if rules.Numbers != "" && !slices.Contains(NumberRules, rules.Numbers) {
+		return Rules{}, fmt.Errorf("Invalid number rule %s", rules.Numbers)
+	}
+	return rules, nil
+}
+
+// Return a copy of the tags with the rules applied.
+func (rules Rules) Apply(tags Tags) Tags {
+	// split numbers given as "3/12" first, a separate total takes precedence over the one in the pair
+	split := Tags{}
+	for _, key := range tags.Keys() {
+		split[key] = slices.Clone(tags[key])
+	}
+	for _, prefix := range []string{"TRACK", "DISC"} {
+		for i, value := range split[prefix+"NUMBER"] {
+			number, total, ok := strings.Cut(value, "/")
+			if !ok {
+				continue
+			}
+			split[prefix+"NUMBER"][i] = number
+			if len(split[prefix+"TOTAL"]) == 0 {
+				split.Set(prefix+"TOTAL", total)
+			}
+		}
+	}
+
+	result := Tags{}
+	for _, key := range split.Keys() {
+		var cleaned []string
+		for _, value := range split[key] {
+			cleaned = append(cleaned, rules.applyValue(key, value))
+		}
+		result.Set(key, cleaned...)
+	}
+
+	for _, prefix := range []string{"TRACK", "DISC"} {
+		number, err := strconv.Atoi(result.Get(prefix + "NUMBER"))
+		if err != nil {
+			continue
+		}
+		switch rules.Numbers {
+		case "pad":
+			digits := max(len(strconv.Itoa(number)), len(result.Get(prefix+"TOTAL")), 2)
+			result.Set(prefix+"NUMBER", fmt.Sprintf("%0*d", digits, number))
+		case "plain":
+			result.Set(prefix+"NUMBER", strconv.Itoa(number))
+			total, err := strconv.Atoi(result.Get(prefix + "TOTAL"))
+			if err == nil {
+				result.Set(prefix+"TOTAL", strconv.Itoa(total))
+			}
+		}
+	}
+	return result
+}
+
+func (rules Rules) applyValue(key string, value string) string {
+	if rules.Trim {
+		value = trim(value)
+	}
+	for _, replacement := range rules.Replace {
+		if len(replacement.Keys) == 0 || slices.ContainsFunc(replacement.Keys, func(candidate string) bool { return Canonical(candidate) == key }) {
+			value = replacement.regexp.ReplaceAllString(value, replacement.With)
+		}
+	}
+	for candidate, rule := range rules.Case {
+		if Canonical(candidate) == key {
+			value = applyCase(value, rule)
+		}
+	}
+	if key == "GENRE" {
+		for spelling, genre := range rules.Genres {
+			if strings.EqualFold(strings.TrimSpace(spelling), strings.TrimSpace(value)) {
+				value = genre
+			}
+		}
+	}
+	return value
+}
+
+// Remove surrounding whitespace and collapse runs of spaces. Values with several lines such as lyrics keep their line
+// breaks and only lose the whitespace around each line.
+func trim(value string) string {
+	if !strings.Contains(value, "\n") {
+		return strings.Join(strings.Fields(value), " ")
+	}
+	lines := strings.Split(strings.TrimSpace(value), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func applyCase(value string, rule string) string {
+	switch rule {
+	case "upper":
+		return strings.ToUpper(value)
+	case "lower":
+		return strings.ToLower(value)
+	case "sentence":
+		return upperFirst(value)
+	}
+	// title case only raises the first letter of each word so abbreviations such as AC/DC are kept
+	words := strings.Split(value, " ")
+	for i, word := range words {
+		words[i] = upperFirst(word)
+	}
+	return strings.Join(words, " ")
+}
+
+func upperFirst(value string) string {
+	first, size := utf8.DecodeRuneInString(value)
+	if first == utf8.RuneError {
+		return value
+	}
+	return string(unicode.ToUpper(first)) + value[size:]
+}
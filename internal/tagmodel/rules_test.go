@@ -0,0 +1,80 @@
+package tagmodel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrim(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"  Some   Title ", "Some Title"},
+		{"Tab\tseparated", "Tab separated"},
+		{"\nFirst line  \n  second  line\n\n  third\n", "First line\nsecond  line\n\nthird"},
+		{"Windows\r\nline breaks\r\n", "Windows\nline breaks"},
+	}
+	for _, test := range tests {
+		if got := trim(test.value); got != test.want {
+			t.Errorf("trim(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	lyrics := "First line\nSecond line\n\nChorus"
+	tests := []struct {
+		name  string
+		rules Rules
+		tags  Tags
+		want  Tags
+	}{
+		{
+			"trim keeps line breaks",
+			Rules{Trim: true},
+			Tags{"TITLE": {" A  Title "}, "LYRICS": {lyrics + "\n"}, "COMMENT": {"One\n  Two"}},
+			Tags{"TITLE": {"A Title"}, "LYRICS": {lyrics}, "COMMENT": {"One\nTwo"}},
+		},
+		{
+			"pair and total",
+			Rules{Trim: true},
+			Tags{"TRACKNUMBER": {"3/12"}, "TRACKTOTAL": {"12"}},
+			Tags{"TRACKNUMBER": {"3"}, "TRACKTOTAL": {"12"}},
+		},
+		{
+			"separate total takes precedence",
+			Rules{},
+			Tags{"DISCNUMBER": {"1/3"}, "DISCTOTAL": {"2"}},
+			Tags{"DISCNUMBER": {"1"}, "DISCTOTAL": {"2"}},
+		},
+		{
+			"pad to the total",
+			Rules{Trim: true, Numbers: "pad"},
+			Tags{"TRACKNUMBER": {" 7 / 120"}, "DISCNUMBER": {"1"}},
+			Tags{"TRACKNUMBER": {"007"}, "TRACKTOTAL": {"120"}, "DISCNUMBER": {"01"}},
+		},
+		{
+			"plain numbers",
+			Rules{Numbers: "plain"},
+			Tags{"TRACKNUMBER": {"03/012"}},
+			Tags{"TRACKNUMBER": {"3"}, "TRACKTOTAL": {"12"}},
+		},
+		{
+			"case and genres",
+			Rules{Case: map[string]string{"title": "title", "Artist": "upper"}, Genres: map[string]string{"hip hop": "Hip-Hop"}},
+			Tags{"TITLE": {"the song of AC/DC"}, "ARTIST": {"someone"}, "GENRE": {"Hip Hop", "Jazz"}},
+			Tags{"TITLE": {"The Song Of AC/DC"}, "ARTIST": {"SOMEONE"}, "GENRE": {"Hip-Hop", "Jazz"}},
+		},
+	}
+	for _, test := range tests {
+		// the result must not depend on the iteration order of the map
+		for range 20 {
+			got := test.rules.Apply(test.tags)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s: Apply = %q, want %q", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
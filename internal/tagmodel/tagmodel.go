@@ -0,0 +1,208 @@
+package tagmodel
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/mp4"
+)
+
+// Tags in the common model. Keys are upper case Vorbis comment names, each key holds one or more values. Track and
+// disc numbers are kept apart from their totals.
+type Tags map[string][]string
+
+// Mapping of a key to the ID3v2 frame and the MP4 item storing it.
+//
+// ID3 frames are given by their ID3v2.4 ID, "TXXX:<description>" names a user defined text frame. ID3v2.3 stores dates
+// in TYER and TORY which only take a year. MP4 items are given by their atom name, freeform items by
+// "----:com.apple.iTunes:<name>". Keys without a mapping are stored as TXXX frame and freeform item named after the key,
+// so every key can be represented in all three systems.
+//
+// A few keys need special treatment:
+//   - TRACKNUMBER/TRACKTOTAL and DISCNUMBER/DISCTOTAL are joined to "number/total" in TRCK and TPOS, and stored as
+//     integer pairs in trkn and disk, which can't hold values that aren't numbers.
+//   - COMPILATION is a flag in cpil and BPM an integer in tmpo.
+//   - COMMENT and LYRICS are stored in COMM and USLT frames without a description. LYRICS in the LRC format are
+//     additionally stored as SYLT frame, USLT and UNSYNCEDLYRICS then hold the plain text.
+//   - MUSICBRAINZ_TRACKID is the recording ID and stored in the UFID frame owned by http://musicbrainz.org.
+type Mapping struct {
+	Key string
+	ID3 string
+	MP4 string
+}
+
+const musicBrainzOwner = "http://musicbrainz.org"
+
+var Mappings = []Mapping{
+	{"TITLE", "TIT2", mp4.Title},
+	{"SUBTITLE", "TIT3", ""},
+	{"ARTIST", "TPE1", mp4.Artist},
+	{"ALBUM", "TALB", mp4.Album},
+	{"ALBUMARTIST", "TPE2", mp4.AlbumArtist},
+	{"COMPOSER", "TCOM", mp4.Composer},
+	{"LYRICIST", "TEXT", ""},
+	{"CONDUCTOR", "TPE3", ""},
+	{"REMIXER", "TPE4", ""},
+	{"GENRE", "TCON", mp4.Genre},
+	{"DATE", "TDRC", mp4.Date},
+	{"ORIGINALDATE", "TDOR", ""},
+	{"GROUPING", "TIT1", mp4.Grouping},
+	{"COPYRIGHT", "TCOP", mp4.Copyright},
+	{"LABEL", "TPUB", ""},
+	{"ISRC", "TSRC", ""},
+	{"MOOD", "TMOO", ""},
+	{"LANGUAGE", "TLAN", ""},
+	{"MEDIA", "TMED", ""},
+	{"INITIALKEY", "TKEY", ""},
+	{"ORIGINALARTIST", "TOPE", ""},
+	{"ORIGINALALBUM", "TOAL", ""},
+	{"DISCSUBTITLE", "TSST", ""},
+	{"ENCODEDBY", "TENC", ""},
+	{"ENCODER", "TSSE", mp4.Encoder},
+	{"TITLESORT", "TSOT", mp4.TitleSort},
+	{"ARTISTSORT", "TSOP", mp4.ArtistSort},
+	{"ALBUMSORT", "TSOA", mp4.AlbumSort},
+	{"ALBUMARTISTSORT", "TSO2", mp4.AlbumArtistSort},
+	{"COMPOSERSORT", "TSOC", mp4.ComposerSort},
+	{"TRACKNUMBER", "TRCK", mp4.Track},
+	{"TRACKTOTAL", "TRCK", mp4.Track},
+	{"DISCNUMBER", "TPOS", mp4.Disc},
+	{"DISCTOTAL", "TPOS", mp4.Disc},
+	{"COMPILATION", "TCMP", mp4.Compilation},
+	{"BPM", "TBPM", mp4.Tempo},
+	{"COMMENT", "COMM", mp4.Comment},
+	{"LYRICS", "USLT", mp4.Lyrics},
+	{"MUSICBRAINZ_TRACKID", "UFID", mp4.FreeformPrefix + "MusicBrainz Track Id"},
+	{"MUSICBRAINZ_RELEASETRACKID", "TXXX:MusicBrainz Release Track Id", mp4.FreeformPrefix + "MusicBrainz Release Track Id"},
+	{"MUSICBRAINZ_ALBUMID", "TXXX:MusicBrainz Album Id", mp4.FreeformPrefix + "MusicBrainz Album Id"},
+	{"MUSICBRAINZ_ARTISTID", "TXXX:MusicBrainz Artist Id", mp4.FreeformPrefix + "MusicBrainz Artist Id"},
+	{"MUSICBRAINZ_ALBUMARTISTID", "TXXX:MusicBrainz Album Artist Id", mp4.FreeformPrefix + "MusicBrainz Album Artist Id"},
+	{"MUSICBRAINZ_RELEASEGROUPID", "TXXX:MusicBrainz Release Group Id", mp4.FreeformPrefix + "MusicBrainz Release Group Id"},
+	{"MUSICBRAINZ_WORKID", "TXXX:MusicBrainz Work Id", mp4.FreeformPrefix + "MusicBrainz Work Id"},
+	{"RELEASETYPE", "TXXX:MusicBrainz Album Type", mp4.FreeformPrefix + "MusicBrainz Album Type"},
+	{"RELEASESTATUS", "TXXX:MusicBrainz Album Status", mp4.FreeformPrefix + "MusicBrainz Album Status"},
+	{"RELEASECOUNTRY", "TXXX:MusicBrainz Album Release Country", mp4.FreeformPrefix + "MusicBrainz Album Release Country"},
+}
+
+// Alternative Vorbis comment names which are read as the key of the model.
+var aliases = map[string]string{
+	"ALBUM ARTIST":   "ALBUMARTIST",
+	"TOTALTRACKS":    "TRACKTOTAL",
+	"TOTALDISCS":     "DISCTOTAL",
+	"UNSYNCEDLYRICS": "LYRICS",
+	"YEAR":           "DATE",
+	"ORGANIZATION":   "LABEL",
+}
+
+func mappingFor(key string) (Mapping, bool) {
+	for _, mapping := range Mappings {
+		if mapping.Key == key {
+			return mapping, true
+		}
+	}
+	return Mapping{}, false
+}
+
+// Original spelling of unmapped TXXX descriptions and freeform item names as read from files, keyed by their key in
+// the model. Players look these names up case sensitively, so iTunSMPB must not be written back as ITUNSMPB.
+var spellings = map[string]string{}
+
+// Remember the spelling of an unmapped name.
+func remember(name string) {
+	key := Canonical(name)
+	if key != name {
+		spellings[key] = name
+	}
+}
+
+// Return the name an unmapped key is written as in formats with case sensitive names.
+func spelling(key string) string {
+	name, ok := spellings[key]
+	if ok {
+		return name
+	}
+	return key
+}
+
+// Return the key of the model for a Vorbis comment name.
+func Canonical(key string) string {
+	key = strings.ToUpper(strings.TrimSpace(key))
+	alias, ok := aliases[key]
+	if ok {
+		return alias
+	}
+	return key
+}
+
+// Return the first value of a key.
+func (tags Tags) Get(key string) string {
+	values := tags[Canonical(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Replace the values of a key. No values remove the key.
+func (tags Tags) Set(key string, values ...string) {
+	key = Canonical(key)
+	var kept []string
+	for _, value := range values {
+		if value != "" {
+			kept = append(kept, value)
+		}
+	}
+	if len(kept) == 0 {
+		delete(tags, key)
+		return
+	}
+	tags[key] = kept
+}
+
+// Add values to a key. Numbers given as "number/total" are split into the number and total keys.
+func (tags Tags) Add(key string, values ...string) {
+	key = Canonical(key)
+	for _, value := range values {
+		value = strings.TrimRight(value, "\x00")
+		if value == "" {
+			continue
+		}
+		if key == "TRACKNUMBER" || key == "DISCNUMBER" {
+			number, total, ok := strings.Cut(value, "/")
+			if ok {
+				value = number
+				tags.Set(strings.TrimSuffix(key, "NUMBER")+"TOTAL", total)
+			}
+		}
+		if value != "" {
+			tags[key] = append(tags[key], value)
+		}
+	}
+}
+
+// Keys in the order of the mapping table followed by the remaining keys in alphabetical order.
+func (tags Tags) Keys() []string {
+	var keys, rest []string
+	for _, mapping := range Mappings {
+		if _, ok := tags[mapping.Key]; ok {
+			keys = append(keys, mapping.Key)
+		}
+	}
+	for key := range tags {
+		if !slices.Contains(keys, key) {
+			rest = append(rest, key)
+		}
+	}
+	slices.Sort(rest)
+	return append(keys, rest...)
+}
+
+// Join a number and its total as used by TRCK and TPOS.
+func (tags Tags) pair(prefix string) string {
+	number := tags.Get(prefix + "NUMBER")
+	total := tags.Get(prefix + "TOTAL")
+	if total == "" {
+		return number
+	}
+	return number + "/" + total
+}
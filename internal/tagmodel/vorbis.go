@@ -0,0 +1,51 @@
+package tagmodel
+
+import (
+	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/lrc"
+	"github.com/Chromfalke/audio-workbench/internal/vorbiscomment"
+)
+
+const pictureKey = "METADATA_BLOCK_PICTURE"
+
+// Plain text lyrics next to LRC lyrics.
+const unsyncedLyricsKey = "UNSYNCEDLYRICS"
+
+func FromComments(comments vorbiscomment.Comments) Tags {
+	hasLyrics := len(comments.Get("LYRICS")) > 0
+	tags := Tags{}
+	for _, field := range comments.Fields {
+		if strings.EqualFold(field.Key, pictureKey) {
+			continue
+		}
+		if hasLyrics && strings.EqualFold(field.Key, unsyncedLyricsKey) {
+			// the plain text of the LRC lyrics
+			continue
+		}
+		tags.Add(field.Key, field.Value)
+	}
+	return tags
+}
+
+// Replace all fields except the pictures. Vorbis comments can hold any key so nothing is lost.
+func ToComments(tags Tags, comments *vorbiscomment.Comments) []string {
+	var pictures []vorbiscomment.Field
+	for _, field := range comments.Fields {
+		if strings.EqualFold(field.Key, pictureKey) {
+			pictures = append(pictures, field)
+		}
+	}
+	comments.Fields = nil
+	for _, key := range tags.Keys() {
+		comments.Set(key, tags[key]...)
+		if key == "LYRICS" {
+			lyrics := lrc.Parse(tags[key][0])
+			if lyrics.Synced {
+				comments.Set(unsyncedLyricsKey, lyrics.Plain())
+			}
+		}
+	}
+	comments.Fields = append(comments.Fields, pictures...)
+	return nil
+}
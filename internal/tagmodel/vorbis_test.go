@@ -0,0 +1,41 @@
+package tagmodel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Chromfalke/audio-workbench/internal/vorbiscomment"
+)
+
+func TestCommentsRoundTrip(t *testing.T) {
+	picture := vorbiscomment.Field{Key: "METADATA_BLOCK_PICTURE", Value: "AAAA"}
+	comments := vorbiscomment.Comments{Vendor: "vendor", Fields: []vorbiscomment.Field{
+		{Key: "title", Value: "Title"},
+		{Key: "TRACKNUMBER", Value: "3/12"},
+		{Key: "Album Artist", Value: "Artist"},
+		{Key: "LYRICS", Value: "[00:01.00]Line"},
+		{Key: "UNSYNCEDLYRICS", Value: "Line"},
+		picture,
+	}}
+	tags := FromComments(comments)
+	want := Tags{"TITLE": {"Title"}, "TRACKNUMBER": {"3"}, "TRACKTOTAL": {"12"}, "ALBUMARTIST": {"Artist"}, "LYRICS": {"[00:01.00]Line"}}
+	if !reflect.DeepEqual(tags, want) {
+		t.Fatalf("FromComments = %q, want %q", tags, want)
+	}
+
+	tags.Set("TITLE", "New title")
+	ToComments(tags, &comments)
+	if comments.Vendor != "vendor" {
+		t.Errorf("Vendor = %q", comments.Vendor)
+	}
+	if got := comments.Get("UNSYNCEDLYRICS"); len(got) != 1 || got[0] != "Line" {
+		t.Errorf("UNSYNCEDLYRICS = %q, want the plain text of the LRC lyrics", got)
+	}
+	if last := comments.Fields[len(comments.Fields)-1]; last != picture {
+		t.Errorf("The picture was not kept, last field is %+v", last)
+	}
+	want["TITLE"] = []string{"New title"}
+	if got := FromComments(comments); !reflect.DeepEqual(got, want) {
+		t.Errorf("Read back %q, want %q", got, want)
+	}
+}
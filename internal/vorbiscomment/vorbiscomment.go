@@ -0,0 +1,153 @@
+package vorbiscomment
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Chromfalke/audio-workbench/internal/picture"
+)
+
+const pictureKey = "METADATA_BLOCK_PICTURE"
+
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Vorbis comments as used by Ogg Vorbis, Ogg Opus and FLAC. The order of the fields is kept.
+type Comments struct {
+	Vendor string
+	Fields []Field
+}
+
+// Parse a comment block without any codec specific framing.
+func Parse(data []byte) (Comments, error) {
+	reader := bytes.NewReader(data)
+	readString := func() (string, error) {
+		var length uint32
+		err := binary.Read(reader, binary.LittleEndian, &length)
+		if err != nil {
+			return "", err
+		}
+		if int64(length) > int64(reader.Len()) {
+			return "", fmt.Errorf("Comment exceeds the block")
+		}
+		text := make([]byte, length)
+		_, err = io.ReadFull(reader, text)
+		return string(text), err
+	}
+
+	var comments Comments
+	var err error
+	comments.Vendor, err = readString()
+	if err != nil {
+		return Comments{}, fmt.Errorf("Truncated comment block")
+	}
+	var count uint32
+	err = binary.Read(reader, binary.LittleEndian, &count)
+	if err != nil {
+		return Comments{}, fmt.Errorf("Truncated comment block")
+	}
+	for range count {
+		entry, err := readString()
+		if err != nil {
+			return Comments{}, fmt.Errorf("Truncated comment block")
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			// skip malformed entries instead of failing on the whole file
+			continue
+		}
+		comments.Fields = append(comments.Fields, Field{Key: key, Value: value})
+	}
+
+	return comments, nil
+}
+
+// Serialize the comments without any codec specific framing.
+func (comments Comments) Bytes() []byte {
+	var buffer bytes.Buffer
+	writeString := func(text string) {
+		binary.Write(&buffer, binary.LittleEndian, uint32(len(text)))
+		buffer.WriteString(text)
+	}
+
+	writeString(comments.Vendor)
+	binary.Write(&buffer, binary.LittleEndian, uint32(len(comments.Fields)))
+	for _, field := range comments.Fields {
+		writeString(field.Key + "=" + field.Value)
+	}
+
+	return buffer.Bytes()
+}
+
+// Return all values of a key. Keys are case insensitive.
+func (comments Comments) Get(key string) []string {
+	var values []string
+	for _, field := range comments.Fields {
+		if strings.EqualFold(field.Key, key) {
+			values = append(values, field.Value)
+		}
+	}
+	return values
+}
+
+// Replace all values of a key. The first existing entry keeps its position.
+func (comments *Comments) Set(key string, values ...string) {
+	position := len(comments.Fields)
+	for i, field := range comments.Fields {
+		if strings.EqualFold(field.Key, key) {
+			position = i
+			break
+		}
+	}
+	comments.Remove(key)
+	position = min(position, len(comments.Fields))
+
+	var fields []Field
+	for _, value := range values {
+		fields = append(fields, Field{Key: strings.ToUpper(key), Value: value})
+	}
+	comments.Fields = append(comments.Fields[:position], append(fields, comments.Fields[position:]...)...)
+}
+
+// Remove all values of a key.
+func (comments *Comments) Remove(key string) {
+	var fields []Field
+	for _, field := range comments.Fields {
+		if !strings.EqualFold(field.Key, key) {
+			fields = append(fields, field)
+		}
+	}
+	comments.Fields = fields
+}
+
+// Decode the pictures stored as base64 METADATA_BLOCK_PICTURE entries.
+func (comments Comments) Pictures() ([]picture.Picture, error) {
+	var pictures []picture.Picture
+	for _, value := range comments.Get(pictureKey) {
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid base64 in %s: %s", pictureKey, err)
+		}
+		pic, err := picture.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		pictures = append(pictures, pic)
+	}
+	return pictures, nil
+}
+
+// Replace all embedded pictures.
+func (comments *Comments) SetPictures(pictures []picture.Picture) {
+	var values []string
+	for _, pic := range pictures {
+		values = append(values, base64.StdEncoding.EncodeToString(pic.Bytes()))
+	}
+	comments.Set(pictureKey, values...)
+}
@@ -0,0 +1,44 @@
+package vorbiscomment
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	comments := Comments{
+		Vendor: "test",
+		Fields: []Field{{Key: "TITLE", Value: "Title"}, {Key: "ARTIST", Value: "One"}, {Key: "ARTIST", Value: "Two"}, {Key: "COMMENT", Value: ""}},
+	}
+	read, err := Parse(comments.Bytes())
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if read.Vendor != comments.Vendor || len(read.Fields) != len(comments.Fields) {
+		t.Fatalf("Parse returned %+v, want %+v", read, comments)
+	}
+	for i := range comments.Fields {
+		if read.Fields[i] != comments.Fields[i] {
+			t.Errorf("Field %d is %+v, want %+v", i, read.Fields[i], comments.Fields[i])
+		}
+	}
+}
+
+func TestParseEmptyStrings(t *testing.T) {
+	// an empty vendor and an empty last entry end exactly at the end of the block
+	data := binary.LittleEndian.AppendUint32(nil, 0)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	comments, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if comments.Vendor != "" || len(comments.Fields) != 0 {
+		t.Errorf("Parse returned %+v, want no vendor and no fields", comments)
+	}
+
+	_, err = Parse(data[:len(data)-1])
+	if err == nil {
+		t.Errorf("Parse of a truncated block succeeded")
+	}
+}
@@ -12,33 +12,103 @@ import (
 
 	"github.com/spf13/pflag"
 
+	"github.com/Chromfalke/audio-workbench/internal/commands"
+	"github.com/Chromfalke/audio-workbench/internal/formats"
 	"github.com/Chromfalke/audio-workbench/internal/lib"
+	"github.com/Chromfalke/audio-workbench/internal/picture"
 	"github.com/Chromfalke/audio-workbench/internal/processors"
+	"github.com/Chromfalke/audio-workbench/internal/tagmodel"
 )
 
 func main() {
 	normalizeCmd := pflag.NewFlagSet("normalize", pflag.ExitOnError)
 	normalizeCmd.SetOutput(os.Stderr)
 	targetLoudness := normalizeCmd.Float64P("lufs", "l", -18.0, "Target loudness in LUFS")
+	normalizeMode := normalizeCmd.StringP("mode", "m", "loudnorm", "Normalization mode: loudnorm, peak or rms")
+	normalizePeak := normalizeCmd.Float64P("peak", "p", -1.0, "Target peak level in dBFS for the peak mode")
+	normalizeTruePeak := normalizeCmd.Bool("true-peak", false, "Use the true peak instead of the sample peak in the peak mode")
+	normalizeRMS := normalizeCmd.Float64("rms", -20.0, "Target RMS level in dBFS for the rms mode")
+	normalizeSilence := normalizeCmd.String("silence", "skip", "How to handle silent or too short files: skip, copy or fail")
+	normalizeSilenceThreshold := normalizeCmd.Float64("silence-threshold", -70.0, "Sample peak in dBFS below which a file counts as silent")
+	normalizeMinDuration := normalizeCmd.Float64("min-duration", 0.4, "Minimum duration in seconds a file needs to be normalized")
+	normalizeMaxGain := normalizeCmd.Float64("max-gain", 20.0, "Refuse to apply more than this gain in dB")
+	normalizeLossless := normalizeCmd.Bool("lossless", false, "Adjust the gain without re-encoding where the format allows it (opus, mp3)")
 
 	convertCmd := pflag.NewFlagSet("convert", pflag.ExitOnError)
 	convertCmd.SetOutput(os.Stderr)
 	conversionFormat := convertCmd.StringP("format", "f", "mp3", "Output format")
+	convertQuality := addQualityFlags(convertCmd)
+	convertPolicy := addPolicyFlag(convertCmd)
+	convertGuard := addGuardFlags(convertCmd)
 
 	resampleCmd := pflag.NewFlagSet("resample", pflag.ExitOnError)
 	resampleCmd.SetOutput(os.Stderr)
 	resampleRate := resampleCmd.IntP("samplerate", "r", 48000, "Target sample rate")
+	resampleEngine := resampleCmd.String("engine", "", "Resampler engine: "+strings.Join(commands.ResamplerEngines, ", "))
+	resamplePrecision := resampleCmd.Int("precision", 0, "Precision of the soxr engine in bits (15 to 33)")
+	resampleCutoff := resampleCmd.Float64("cutoff", 0, "Cutoff frequency as a fraction of the Nyquist frequency")
+	resampleVerify := resampleCmd.Int("verify", 0, "Instead of processing files report the ripple and aliasing of resampling a sweep from this sample rate")
+	resampleQuality := addQualityFlags(resampleCmd)
+	resamplePolicy := addPolicyFlag(resampleCmd)
+	resampleGuard := addGuardFlags(resampleCmd)
+
+	bitDepthCmd := pflag.NewFlagSet("bitdepth", pflag.ExitOnError)
+	bitDepthCmd.SetOutput(os.Stderr)
+	bitDepth := bitDepthCmd.StringP("depth", "d", "16", "Target bit depth: 8, 16, 24, 32 or float")
+	bitDepthDither := bitDepthCmd.String("dither", "triangular", "Dither when reducing the bit depth: "+strings.Join(formats.Dithers, ", "))
 
 	imgExtractCmd := pflag.NewFlagSet("extract-cover", pflag.ExitOnError)
 	imgExtractCmd.SetOutput(os.Stderr)
 	imgFormat := imgExtractCmd.StringP("format", "f", "jpg", "Output format")
+	imgExtractAll := imgExtractCmd.BoolP("all", "a", false, "Extract every picture in its original encoding with its type in the file name")
+
+	setCoverCmd := pflag.NewFlagSet("set-cover", pflag.ExitOnError)
+	setCoverCmd.SetOutput(os.Stderr)
+	setCoverType := setCoverCmd.StringP("type", "t", picture.TypeNames[picture.FrontCover], "Picture type: "+strings.Join(picture.TypeNames, ", "))
+	setCoverMaxSize := setCoverCmd.Int("max-size", 0, "Scale the image down to at most this many pixels on its longest side")
+	setCoverQuality := setCoverCmd.IntP("quality", "q", 0, "Re-encode the image as baseline JPEG with this quality (1-100)")
+	setCoverMaxKB := setCoverCmd.Int("max-kb", 0, "Size budget in KiB, the quality and then the size are lowered until the image fits")
+	setCoverStripExif := setCoverCmd.Bool("strip-exif", false, "Remove EXIF, XMP, IPTC and text metadata from the image")
 
 	audioExtractCmd := pflag.NewFlagSet("extract-audio", pflag.ExitOnError)
 	audioExtractCmd.SetOutput(os.Stderr)
 	audioFormat := audioExtractCmd.StringP("format", "f", "mp3", "Output format")
+	audioExtractQuality := addQualityFlags(audioExtractCmd)
+	audioExtractPolicy := addPolicyFlag(audioExtractCmd)
+	audioExtractGuard := addGuardFlags(audioExtractCmd)
 	audioExtractCopyCover := audioExtractCmd.BoolP("copy-cover", "c", false, "Copy the cover from the video")
 	audioExtractCoverTimestamp := audioExtractCmd.StringP("cover-timestamp", "t", "00:00:10", "The timestamp in the video to extract the cover from")
 
+	replayGainCmd := pflag.NewFlagSet("replaygain", pflag.ExitOnError)
+	replayGainCmd.SetOutput(os.Stderr)
+	replayGainAlbum := replayGainCmd.BoolP("album", "a", false, "Treat all files as one album and write album gain tags")
+	replayGainRemove := replayGainCmd.BoolP("remove", "d", false, "Remove existing gain tags instead of writing new ones")
+	replayGainSilenceThreshold := replayGainCmd.Float64("silence-threshold", -70.0, "Sample peak in dBFS below which a file counts as silent")
+
+	tagsCmd := pflag.NewFlagSet("tags", pflag.ExitOnError)
+	tagsCmd.SetOutput(os.Stderr)
+	tagsJSON := tagsCmd.Bool("json", false, "Print the tags of each file as a JSON object (show)")
+	tagsFrom := tagsCmd.String("from", "", "File to copy the tags from (copy)")
+	tagsYes := tagsCmd.BoolP("yes", "y", false, "Write the imported tags without asking (import)")
+	tagsRules := tagsCmd.String("rules", "", "JSON file with the cleanup rules, only whitespace is trimmed without it (normalize)")
+	tagsDryRun := tagsCmd.Bool("dry-run", false, "Only print the changes (normalize)")
+
+	tagFromNameCmd := pflag.NewFlagSet("tag-from-name", pflag.ExitOnError)
+	tagFromNameCmd.SetOutput(os.Stderr)
+	tagFromNamePattern := tagFromNameCmd.StringP("pattern", "p", "%track% - %artist% - %title%", "Pattern of the file names, fields are written as %key%")
+	tagFromNamePreview := tagFromNameCmd.Bool("preview", false, "Only print the parsed tags")
+
+	renameCmd := pflag.NewFlagSet("rename", pflag.ExitOnError)
+	renameCmd.SetOutput(os.Stderr)
+	renameTemplate := renameCmd.StringP("template", "t", "%track% - %artist% - %title%", "Template of the new file names, fields are written as %key%")
+	renamePreview := renameCmd.Bool("preview", false, "Only print the new names")
+
+	stripCmd := pflag.NewFlagSet("strip", pflag.ExitOnError)
+	stripCmd.SetOutput(os.Stderr)
+	stripKeep := stripCmd.StringSlice("keep", nil, "Keys to keep, everything else is removed (wildcards such as MUSICBRAINZ_* are allowed)")
+	stripRemove := stripCmd.StringSlice("remove", nil, "Keys to remove, everything else is kept")
+	stripKeepPictures := stripCmd.Bool("keep-pictures", false, "Keep the embedded pictures")
+
 	if len(os.Args) < 2 || os.Args[1] == "help" {
 		writer := tabwriter.NewWriter(os.Stderr, 15, 2, 1, ' ', 0)
 		fmt.Fprintln(writer, "Usage: audio-workbench <command> [<args>]")
@@ -46,6 +116,13 @@ func main() {
 		fmt.Fprintln(writer, "  normalize\tNormalize the loudness of an audio file")
 		fmt.Fprintln(writer, "  convert\tConvert from one audio codec to another")
 		fmt.Fprintln(writer, "  resample\tResample the audio to a different sample rate")
+		fmt.Fprintln(writer, "  bitdepth\tChange the bit depth of lossless audio files")
+		fmt.Fprintln(writer, "  replaygain\tWrite ReplayGain tags without re-encoding the audio")
+		fmt.Fprintln(writer, "  tags\tShow, set, remove, clear or copy the tags of audio files")
+		fmt.Fprintln(writer, "  tag-from-name\tWrite tags parsed from the file names")
+		fmt.Fprintln(writer, "  rename\tRename files after their tags")
+		fmt.Fprintln(writer, "  lyrics\tEmbed lyrics from .lrc or .txt files or extract them to .lrc files")
+		fmt.Fprintln(writer, "  strip\tRemove tags and pictures from audio files")
 		fmt.Fprintln(writer, "  set-cover\tSet the cover image for an audio file")
 		fmt.Fprintln(writer, "  extract-cover\tExtract the cover image from a media file")
 		fmt.Fprintln(writer, "  extract-audio\tExtract the audio from a video")
@@ -66,7 +143,32 @@ func main() {
 			log.Fatalln("Fatal: You need to provide an input directory or file.")
 		}
 
-		runner(normalizeCmd.Arg(0), normalizeCmd.Arg(1), processors.Normalizer{TargetLoudness: *targetLoudness})
+		normalizer := processors.Normalizer{
+			Mode:             *normalizeMode,
+			TargetLoudness:   *targetLoudness,
+			TruePeak:         *normalizeTruePeak,
+			Lossless:         *normalizeLossless,
+			SilencePolicy:    *normalizeSilence,
+			SilenceThreshold: *normalizeSilenceThreshold,
+			MinDuration:      *normalizeMinDuration,
+			MaxGain:          *normalizeMaxGain,
+		}
+		silencePolicies := []string{"skip", "copy", "fail"}
+		if !slices.Contains(silencePolicies, *normalizeSilence) {
+			log.Println("Supported silence policies are: ", strings.Join(silencePolicies, ", "))
+			log.Fatalf("Fatal: Invalid silence policy %s\n", *normalizeSilence)
+		}
+		switch *normalizeMode {
+		case "loudnorm":
+		case "peak":
+			normalizer.TargetLevel = *normalizePeak
+		case "rms":
+			normalizer.TargetLevel = *normalizeRMS
+		default:
+			log.Fatalf("Fatal: Unknown normalization mode %s\n", *normalizeMode)
+		}
+
+		runner(normalizeCmd.Arg(0), normalizeCmd.Arg(1), normalizer)
 	case "convert":
 		err := convertCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -78,19 +180,20 @@ func main() {
 			log.Fatalln("Fatal: You need to provide an input directory or file.")
 		}
 
-		validFormats := []string{"flac", "mp3", "opus", "wav"}
-		if !slices.Contains(validFormats, *conversionFormat) {
+		validFormats := formats.Encodable()
+		format, ok := formats.ByName(*conversionFormat)
+		if !ok || format.Encoder == "" {
 			log.Println("Supported formats are: ", strings.Join(validFormats, ", "))
 			log.Fatalf("Fatal: Invalid format %s\n", *conversionFormat)
 		}
 
-		runner(convertCmd.Arg(0), convertCmd.Arg(1), processors.Converter{Format: *conversionFormat})
+		runner(convertCmd.Arg(0), convertCmd.Arg(1), processors.Converter{Format: format, Quality: convertQuality(), Policy: convertPolicy(), Guard: convertGuard()})
 	case "resample":
 		err := resampleCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Fatalln("Failed to parse flags: ", err)
 		}
-		if resampleCmd.Arg(0) == "" {
+		if resampleCmd.Arg(0) == "" && *resampleVerify == 0 {
 			log.Println("Usage: audio-workbench resample [<args>] <path>")
 			resampleCmd.PrintDefaults()
 			log.Fatalln("Fatal: You need to provide an input directory or file.")
@@ -99,28 +202,309 @@ func main() {
 		if *resampleRate > 192000 || *resampleRate < 8000 {
 			log.Fatalf("Unable to resample to %d Hz.\n", *resampleRate)
 		}
-		validOpusRates := []int{48000, 24000, 16000, 12000, 8000}
-		validOpusStrings := []string{"48000", "24000", "16000", "12000", "8000"}
-		if strings.HasSuffix(resampleCmd.Arg(0), ".opus") || strings.HasSuffix(resampleCmd.Arg(0), ".ogg") {
-			if !slices.Contains(validOpusRates, *resampleRate) {
-				log.Println("Supported sample rates for opus are: ", strings.Join(validOpusStrings, ", "))
-				log.Fatalf("Fatal: Invalid sample rate for opus %d\n", *resampleRate)
+		options := commands.ResamplerOptions{Engine: *resampleEngine, Precision: *resamplePrecision, Cutoff: *resampleCutoff}
+		err = options.Validate()
+		if err != nil {
+			log.Fatalf("Fatal: %s\n", err)
+		}
+		if *resampleVerify != 0 {
+			if *resampleVerify > 192000 || *resampleVerify < 8000 {
+				log.Fatalf("Unable to resample from %d Hz.\n", *resampleVerify)
+			}
+			err := processors.VerifyResampler(*resampleVerify, *resampleRate, options)
+			if err != nil {
+				log.Fatalln(err)
 			}
+			return
 		}
 
-		runner(resampleCmd.Arg(0), resampleCmd.Arg(1), processors.Resampler{SampleRate: *resampleRate})
-	case "set-cover":
+		runner(resampleCmd.Arg(0), resampleCmd.Arg(1), processors.Resampler{SampleRate: *resampleRate, Options: options, Quality: resampleQuality(), Policy: resamplePolicy(), Guard: resampleGuard()})
+	case "bitdepth":
+		err := bitDepthCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Fatalln("Failed to parse flags: ", err)
+		}
+		if bitDepthCmd.Arg(0) == "" {
+			log.Println("Usage: audio-workbench bitdepth [<args>] <path> [<outpath>]")
+			bitDepthCmd.PrintDefaults()
+			log.Fatalln("Fatal: You need to provide an input directory or file.")
+		}
+
+		sampleFormat, err := formats.ParseBitDepth(*bitDepth)
+		if err != nil {
+			log.Fatalf("Fatal: %s\n", err)
+		}
+		if !slices.Contains(formats.Dithers, *bitDepthDither) {
+			log.Println("Supported dithers are: ", strings.Join(formats.Dithers, ", "))
+			log.Fatalf("Fatal: Invalid dither %s\n", *bitDepthDither)
+		}
+
+		runner(bitDepthCmd.Arg(0), bitDepthCmd.Arg(1), processors.BitDepthConverter{SampleFormat: sampleFormat, Dither: *bitDepthDither})
+	case "replaygain":
+		err := replayGainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Fatalln("Failed to parse flags: ", err)
+		}
+		if replayGainCmd.Arg(0) == "" {
+			log.Println("Usage: audio-workbench replaygain [<args>] <path> [<outpath>]")
+			replayGainCmd.PrintDefaults()
+			log.Fatalln("Fatal: You need to provide an input directory or file.")
+		}
+
+		tagger := processors.ReplayGainTagger{Remove: *replayGainRemove, SilenceThreshold: *replayGainSilenceThreshold}
+		if *replayGainAlbum && !*replayGainRemove {
+			files, err := lib.CollectInputFiles(replayGainCmd.Arg(0))
+			if err != nil {
+				log.Fatalln("Failed to collect input files: ", err)
+			}
+			var paths []string
+			for _, file := range files {
+				if processors.SupportsReplayGain(file) {
+					paths = append(paths, file.Path)
+				}
+			}
+			album, err := commands.MeasureLoudness(paths...)
+			if err != nil {
+				log.Fatalln("Failed to measure the album loudness: ", err)
+			}
+			if tagger.Silent(album) {
+				log.Fatalln("Fatal: Unable to calculate an album gain since the album is silent.")
+			}
+			tagger.Album = &album
+		}
+
+		runner(replayGainCmd.Arg(0), replayGainCmd.Arg(1), tagger)
+	case "tags":
+		tagsUsage := func() {
+			log.Println("Usage: audio-workbench tags show [--json] <path>")
+			log.Println("       audio-workbench tags set <KEY=VALUE>... <path>")
+			log.Println("       audio-workbench tags remove <KEY>... <path>")
+			log.Println("       audio-workbench tags clear <path>")
+			log.Println("       audio-workbench tags copy --from <file> <path>")
+			log.Println("       audio-workbench tags export <sheet.csv|sheet.json> <path>")
+			log.Println("       audio-workbench tags import [--yes] <sheet.csv|sheet.json>")
+			log.Println("       audio-workbench tags normalize [--rules <rules.json>] [--dry-run] <path>")
+			tagsCmd.PrintDefaults()
+		}
+		if len(os.Args) < 3 {
+			tagsUsage()
+			log.Fatalln("Fatal: You need to provide a subcommand.")
+		}
+		err := tagsCmd.Parse(os.Args[3:])
+		if err != nil {
+			log.Fatalln("Failed to parse flags: ", err)
+		}
+		if tagsCmd.NArg() == 0 {
+			tagsUsage()
+			log.Fatalln("Fatal: You need to provide an input directory or file.")
+		}
+		if tagsCmd.Changed("json") && os.Args[2] != "show" {
+			log.Fatalln("Fatal: --json is only supported by show.")
+		}
+		if tagsCmd.Changed("from") && os.Args[2] != "copy" {
+			log.Fatalln("Fatal: --from is only supported by copy.")
+		}
+		if tagsCmd.Changed("yes") && os.Args[2] != "import" {
+			log.Fatalln("Fatal: --yes is only supported by import.")
+		}
+		if (tagsCmd.Changed("rules") || tagsCmd.Changed("dry-run")) && os.Args[2] != "normalize" {
+			log.Fatalln("Fatal: --rules and --dry-run are only supported by normalize.")
+		}
+		path := tagsCmd.Arg(tagsCmd.NArg() - 1)
+		operands := tagsCmd.Args()[:tagsCmd.NArg()-1]
+
+		switch os.Args[2] {
+		case "show":
+			runner(path, "", processors.TagPrinter{JSON: *tagsJSON})
+		case "set":
+			if len(operands) == 0 {
+				tagsUsage()
+				log.Fatalln("Fatal: You need to provide at least one KEY=VALUE pair.")
+			}
+			editor := processors.TagEditor{Set: tagmodel.Tags{}}
+			for _, operand := range operands {
+				key, value, ok := strings.Cut(operand, "=")
+				if !ok || strings.TrimSpace(key) == "" {
+					log.Fatalf("Fatal: %s is not a KEY=VALUE pair.\n", operand)
+				}
+				if value == "" {
+					editor.Remove = append(editor.Remove, key)
+					continue
+				}
+				// repeated keys set multiple values
+				editor.Set.Add(key, value)
+			}
+			runner(path, "", editor)
+		case "remove":
+			if len(operands) == 0 {
+				tagsUsage()
+				log.Fatalln("Fatal: You need to provide at least one key to remove.")
+			}
+			runner(path, "", processors.TagEditor{Remove: operands})
+		case "clear":
+			runner(path, "", processors.TagEditor{Clear: true})
+		case "copy":
+			if *tagsFrom == "" {
+				tagsUsage()
+				log.Fatalln("Fatal: You need to provide a file to copy the tags from.")
+			}
+			source := lib.NewMediafile(*tagsFrom)
+			if !source.IsAudio() {
+				log.Fatalf("Fatal: %s is not an audio file of a supported format.\n", *tagsFrom)
+			}
+			tags, err := commands.ReadMetadata(source)
+			if err != nil {
+				log.Fatalf("Fatal: Failed to read the tags of %s: %s\n", *tagsFrom, err)
+			}
+			runner(path, "", processors.TagEditor{Clear: true, Set: tags})
+		case "export":
+			if len(operands) != 1 {
+				tagsUsage()
+				log.Fatalln("Fatal: You need to provide the sheet to export to.")
+			}
+			sheet := operands[0]
+			if !slices.Contains(processors.SheetFormats, filepath.Ext(sheet)) {
+				log.Println("Supported sheet formats: ", strings.Join(processors.SheetFormats, ", "))
+				log.Fatalf("Fatal: Provided sheet format %s is not supported.\n", filepath.Ext(sheet))
+			}
+			files, err := lib.CollectInputFiles(path)
+			if err != nil {
+				log.Fatalln("Failed to collect input files: ", err)
+			}
+			err = processors.ExportTags(files, sheet)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		case "import":
+			if len(operands) != 0 {
+				tagsUsage()
+				log.Fatalln("Fatal: import only takes the sheet.")
+			}
+			if !slices.Contains(processors.SheetFormats, filepath.Ext(path)) {
+				log.Println("Supported sheet formats: ", strings.Join(processors.SheetFormats, ", "))
+				log.Fatalf("Fatal: Provided sheet format %s is not supported.\n", filepath.Ext(path))
+			}
+			confirm := func() bool {
+				if *tagsYes {
+					return true
+				}
+				fmt.Print("Write these changes? [y/N] ")
+				var answer string
+				fmt.Scanln(&answer)
+				return strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")
+			}
+			err = processors.ImportTags(path, confirm)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		case "normalize":
+			rules := tagmodel.Rules{Trim: true}
+			if *tagsRules != "" {
+				rules, err = tagmodel.LoadRules(*tagsRules)
+				if err != nil {
+					log.Fatalf("Fatal: %s\n", err)
+				}
+			}
+			runner(path, "", processors.TagNormalizer{Rules: rules, DryRun: *tagsDryRun})
+		default:
+			tagsUsage()
+			log.Fatalln("Fatal: Unknown tags subcommand:", os.Args[2])
+		}
+	case "tag-from-name":
+		err := tagFromNameCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Fatalln("Failed to parse flags: ", err)
+		}
+		if tagFromNameCmd.Arg(0) == "" {
+			log.Println("Usage: audio-workbench tag-from-name [<args>] <path>")
+			tagFromNameCmd.PrintDefaults()
+			log.Fatalln("Fatal: You need to provide an input directory or file.")
+		}
+
+		pattern, err := tagmodel.ParsePattern(*tagFromNamePattern)
+		if err != nil {
+			log.Fatalf("Fatal: %s\n", err)
+		}
+
+		runner(tagFromNameCmd.Arg(0), "", processors.NameTagger{Pattern: pattern, Preview: *tagFromNamePreview})
+	case "rename":
+		err := renameCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Fatalln("Failed to parse flags: ", err)
+		}
+		if renameCmd.Arg(0) == "" {
+			log.Println("Usage: audio-workbench rename [<args>] <path>")
+			renameCmd.PrintDefaults()
+			log.Fatalln("Fatal: You need to provide an input directory or file.")
+		}
+
+		template, err := tagmodel.ParsePattern(*renameTemplate)
+		if err != nil {
+			log.Fatalf("Fatal: %s\n", err)
+		}
+
+		runner(renameCmd.Arg(0), "", processors.Renamer{Template: template, Preview: *renamePreview})
+	case "lyrics":
 		if len(os.Args) < 4 {
-			log.Println("Usage: audio-workbench set-cover <cover> <path>")
+			log.Println("Usage: audio-workbench lyrics embed <path> [<outpath>]")
+			log.Println("       audio-workbench lyrics extract <path> [<outpath>]")
+			log.Fatalln("Fatal: You need to provide a subcommand and an input directory or file.")
+		}
+		outpath := ""
+		if len(os.Args) > 4 {
+			outpath = os.Args[4]
+		}
+
+		switch os.Args[2] {
+		case "embed":
+			runner(os.Args[3], outpath, processors.LyricsEmbedder{})
+		case "extract":
+			runner(os.Args[3], outpath, processors.LyricsExtractor{})
+		default:
+			log.Fatalln("Fatal: Unknown lyrics subcommand:", os.Args[2])
+		}
+	case "strip":
+		err := stripCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Fatalln("Failed to parse flags: ", err)
+		}
+		if stripCmd.Arg(0) == "" {
+			log.Println("Usage: audio-workbench strip [<args>] <path> [<outpath>]")
+			stripCmd.PrintDefaults()
+			log.Fatalln("Fatal: You need to provide an input directory or file.")
+		}
+		if len(*stripKeep) > 0 && len(*stripRemove) > 0 {
+			log.Fatalln("Fatal: --keep and --remove can't be combined.")
+		}
+
+		runner(stripCmd.Arg(0), stripCmd.Arg(1), processors.Stripper{Keep: *stripKeep, Remove: *stripRemove, KeepPictures: *stripKeepPictures})
+	case "set-cover":
+		err := setCoverCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Fatalln("Failed to parse flags: ", err)
+		}
+		if setCoverCmd.NArg() < 2 {
+			log.Println("Usage: audio-workbench set-cover [<args>] <cover> <path>")
+			setCoverCmd.PrintDefaults()
 			log.Fatalln("Fatal: You need to provide a cover file and a file or directory of files to apply it to.")
 		}
-		imgExtensions := []string{".jpeg", ".jpg", ".png"}
-		if !slices.Contains(imgExtensions, filepath.Ext(os.Args[2])) {
-			log.Println("Supported image types: ", strings.Join(imgExtensions, ", "))
-			log.Fatalf("Fatal: Provided cover format %s is not a supported image format.\n", filepath.Ext(os.Args[2]))
+		options := picture.Options{MaxSize: *setCoverMaxSize, Quality: *setCoverQuality, MaxBytes: *setCoverMaxKB * 1024, StripHeaders: *setCoverStripExif}
+		err = options.Validate()
+		if err != nil {
+			log.Fatalf("Fatal: %s\n", err)
+		}
+		pictureType, err := picture.ParseType(*setCoverType)
+		if err != nil {
+			log.Println("Supported picture types: ", strings.Join(picture.TypeNames, ", "))
+			log.Fatalf("Fatal: %s\n", err)
+		}
+		cover, err := prepareCover(setCoverCmd.Arg(0), options)
+		if err != nil {
+			log.Fatalf("Fatal: %s\n", err)
 		}
+		defer os.RemoveAll(filepath.Dir(cover))
 
-		runner(os.Args[3], "", processors.CoverImageSetter{CoverImage: os.Args[2]})
+		runner(setCoverCmd.Arg(1), "", processors.CoverImageSetter{CoverImage: cover, PictureType: pictureType})
 	case "extract-cover":
 		err := imgExtractCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -147,7 +531,7 @@ func main() {
 			log.Fatalf("Fatal: Extracting a cover with format %s is not a supported.\n", usedFormat)
 		}
 
-		runner(imgExtractCmd.Arg(0), imgExtractCmd.Arg(1), processors.CoverImageExtractor{ImageFormat: "." + usedFormat})
+		runner(imgExtractCmd.Arg(0), imgExtractCmd.Arg(1), processors.CoverImageExtractor{ImageFormat: "." + usedFormat, All: *imgExtractAll})
 	case "extract-audio":
 		err := audioExtractCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -159,8 +543,9 @@ func main() {
 			log.Fatalln("Fatal: You need to provide an input directory or file.")
 		}
 
-		validFormats := []string{"flac", "mp3", "opus", "wav"}
-		if !slices.Contains(validFormats, *audioFormat) {
+		validFormats := formats.Encodable()
+		format, ok := formats.ByName(*audioFormat)
+		if !ok || format.Encoder == "" {
 			log.Println("Supported formats are: ", strings.Join(validFormats, ", "))
 			log.Fatalf("Fatal: Invalid format %s\n", *audioFormat)
 		}
@@ -175,12 +560,112 @@ func main() {
 			}
 		}
 
-		runner(audioExtractCmd.Arg(0), audioExtractCmd.Arg(1), processors.AudioExtractor{AudioFormat: "." + *audioFormat, CopyCover: *audioExtractCopyCover, VideoTimestamp: *audioExtractCoverTimestamp})
+		runner(audioExtractCmd.Arg(0), audioExtractCmd.Arg(1), processors.AudioExtractor{Format: format, Quality: audioExtractQuality(), Policy: audioExtractPolicy(), Guard: audioExtractGuard(), CopyCover: *audioExtractCopyCover, VideoTimestamp: *audioExtractCoverTimestamp})
 	default:
 		log.Fatalln("Unknown command:", os.Args[1])
 	}
 }
 
+// Add the encoder quality flags to a command. The returned function reads and validates them after parsing.
+func addQualityFlags(cmd *pflag.FlagSet) func() formats.Quality {
+	bitrate := cmd.IntP("bitrate", "b", 0, "Bitrate in kbit/s for lossy formats, 0 picks a default for the source")
+	vbr := cmd.Int("vbr", -1, "Variable bitrate quality for mp3 from 0 (best) to 9")
+	application := cmd.String("application", "", "Opus application: "+strings.Join(formats.OpusApplications, ", "))
+	frameDuration := cmd.Float64("frame-duration", 0, "Opus frame size in milliseconds")
+	compression := cmd.Int("compression", -1, "FLAC compression level from 0 to 12")
+	sampleFormat := cmd.String("sample-format", "", "Sample format of lossless formats: "+strings.Join(formats.SampleFormats, ", "))
+	bitDepth := cmd.String("bitdepth", "", "Bit depth of lossless formats: 8, 16, 24, 32 or float")
+	dither := cmd.String("dither", "", "Dither when reducing the bit depth: "+strings.Join(formats.Dithers, ", "))
+
+	return func() formats.Quality {
+		quality := formats.Quality{
+			Bitrate:          *bitrate * 1000,
+			VBRQuality:       *vbr,
+			Application:      *application,
+			FrameDuration:    *frameDuration,
+			CompressionLevel: *compression,
+			SampleFormat:     *sampleFormat,
+			Dither:           *dither,
+		}
+		if *bitrate > 0 && *vbr >= 0 {
+			log.Fatalln("Fatal: --bitrate and --vbr can't be combined.")
+		}
+		if *bitDepth != "" {
+			if *sampleFormat != "" {
+				log.Fatalln("Fatal: --bitdepth and --sample-format can't be combined.")
+			}
+			var err error
+			quality.SampleFormat, err = formats.ParseBitDepth(*bitDepth)
+			if err != nil {
+				log.Fatalf("Fatal: %s\n", err)
+			}
+		}
+		err := quality.Validate()
+		if err != nil {
+			log.Fatalf("Fatal: %s\n", err)
+		}
+		return quality
+	}
+}
+
+// Add the flag choosing how parameters a format can't store are handled. The returned function validates it after
+// parsing.
+func addPolicyFlag(cmd *pflag.FlagSet) func() string {
+	policy := cmd.String("constraints", formats.Snap, "How to handle parameters the output format can't store: "+strings.Join(formats.Policies, " or "))
+
+	return func() string {
+		if !slices.Contains(formats.Policies, *policy) {
+			log.Println("Supported constraint policies are: ", strings.Join(formats.Policies, ", "))
+			log.Fatalf("Fatal: Invalid constraint policy %s\n", *policy)
+		}
+		return *policy
+	}
+}
+
+// Add the flags of the quality guard against lossy to lossless conversions and upsampling. The returned function
+// validates them after parsing.
+func addGuardFlags(cmd *pflag.FlagSet) func() processors.QualityGuard {
+	policy := cmd.String("guard", "warn", "How to handle lossy to lossless conversions and upsampling: "+strings.Join(processors.GuardPolicies, ", "))
+	allowUpscale := cmd.Bool("allow-upscale", false, "Allow lossy to lossless conversions and upsampling without a warning")
+
+	return func() processors.QualityGuard {
+		if !slices.Contains(processors.GuardPolicies, *policy) {
+			log.Println("Supported guard policies are: ", strings.Join(processors.GuardPolicies, ", "))
+			log.Fatalf("Fatal: Invalid guard policy %s\n", *policy)
+		}
+		return processors.QualityGuard{Policy: *policy, AllowUpscale: *allowUpscale}
+	}
+}
+
+// Check the cover image by decoding it and write the prepared image to a temporary directory. The caller removes the
+// directory of the returned path.
+func prepareCover(cover string, options picture.Options) (string, error) {
+	data, err := os.ReadFile(cover)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %s: %s", cover, err)
+	}
+	data, format, err := picture.Prepare(data, options)
+	if err != nil {
+		return "", fmt.Errorf("Failed to prepare %s: %s", cover, err)
+	}
+
+	dir, err := os.MkdirTemp("", "audio-workbench")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create a temporary directory: %s", err)
+	}
+	extension := ".png"
+	if format == "jpeg" {
+		extension = ".jpg"
+	}
+	path := filepath.Join(dir, "cover"+extension)
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("Failed to write %s: %s", path, err)
+	}
+	return path, nil
+}
+
 func runner(input string, outputDir string, processor processors.Processor) {
 	err := lib.CreateOutputDir(outputDir)
 	if err != nil {
@@ -195,6 +680,9 @@ func runner(input string, outputDir string, processor processors.Processor) {
 	for _, file := range files {
 		log.Println("Processing ", file.Path)
 		outpath := lib.BuildOutputPath(file, outputDir)
-		processor.Run(file, outpath)
+		err := processor.Run(file, outpath)
+		if err != nil {
+			log.Println(err)
+		}
 	}
 }